@@ -2,22 +2,118 @@ package main
 
 import (
 	"fmt"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"errors"
 	"encoding/json"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 
 // InsuranceChaincode
 type InsuranceCrudChaincode struct {}
 
-//Holds a list of keys added to state
+//Holds a list of keys added to state. No longer written, only read when migrating to the key index.
 type KeyHolder struct {
 	Keys 	[]string 	`json:"keys"`
 }
 
 const KEYHOLDER_KEY = "KEYHOLDER_KEY"
 
+//Every key saved has a marker entry in the key index, stored under this prefix, so that all keys can be found with a
+//range query without holding them all in a single entry
+const KEY_INDEX_PREFIX = "KEY_INDEX_"
+
+//Sorts after any valid key under the key index prefix, for use as the end of a range query
+var KEY_INDEX_END = KEY_INDEX_PREFIX + string(utf8.MaxRune)
+
+//Maximum number of entries returned in a single page of a list or range query
+const MAX_PAGE_SIZE = 100
+
+//A single key/value pair, with the version of the value.  When saving in a batch, ExpectedVersion can be given to only
+//save if the stored value is still at that version
+type KeyValue struct {
+	Key					string		`json:"key"`
+	Value				string		`json:"value"`
+	Version				int			`json:"version"`
+	ExpectedVersion		*int		`json:"expectedVersion,omitempty"`
+}
+
+//Returned when a conditional save finds the stored value at a different version to the one expected
+type VersionConflictError struct {
+	Key					string		`json:"key"`
+	ExpectedVersion		int			`json:"expectedVersion"`
+	ActualVersion		int			`json:"actualVersion"`
+}
+
+//The details are given as JSON so that the calling chaincode can parse them
+func (e VersionConflictError) Error() (string) {
+	bytes, _ := json.Marshal(e)
+	return VERSION_CONFLICT_ERROR_PREFIX + string(bytes)
+}
+
+const VERSION_CONFLICT_ERROR_PREFIX = "VERSION_CONFLICT: "
+
+//Each chaincode allowed to call this chaincode is registered at Init with a name and a token.  Only a hash of the token
+//is stored, under this prefix, and a caller must send its name and token with every invoke and query.
+const REGISTERED_CALLER_PREFIX = "REGISTERED_CALLER_"
+
+//Set once the chaincode has been initialised, after which only a superuser can initialise it again
+const INITIALISED_KEY = "CRUD_INITIALISED"
+
+//Passed as an init argument to wipe all data.  Only intended for dev mode, where the chaincode keeps its state when
+//deployed again with the same name.
+const DEV_MODE_ARG = "devMode"
+
+//Role attribute required to initialise the chaincode again
+const ROLE_SUPER_USER = "superuser"
+
+//Emitted whenever an invoke is rejected because the caller could not be authenticated.  Events are only delivered for
+//successful transactions, so a rejected invoke succeeds without writing anything and returns the rejection as its
+//response.  Queries are not recorded on the ledger and can't emit events, so a rejected query is only logged.
+const EVENT_TYPE_ACCESS_REJECTED = "CrudAccessRejected"
+
+//Prefix of the response to a rejected invoke and the error from a rejected query
+const ACCESS_REJECTED_PREFIX = "ACCESS_REJECTED: "
+
+//Emitted when init wipes all data, to leave an audit record of the wipe
+const EVENT_TYPE_DATA_WIPED = "CrudDataWiped"
+
+type DataWipedEvent struct {
+	Type			string		`json:"eventType"`
+	KeysDeleted		int			`json:"keysDeleted"`
+	TxId			string		`json:"txId"`
+}
+
+//Returned when the caller of an invoke or query could not be authenticated
+type AccessRejectedError struct {
+	Caller		string
+	Reason		string
+}
+
+func (e AccessRejectedError) Error() (string) {
+	return ACCESS_REJECTED_PREFIX + e.Reason
+}
+
+type AccessRejectedEvent struct {
+	Type		string		`json:"eventType"`
+	Function	string		`json:"function"`
+	Caller		string		`json:"caller"`
+	Reason		string		`json:"reason"`
+	TxId		string		`json:"txId"`
+}
+
+//A page of results from a list or range query. If there are more results, NextKey should be passed as startAfter to
+//retrieve the next page
+type Page struct {
+	Entries		[]KeyValue	`json:"entries"`
+	NextKey		string		`json:"nextKey"`
+}
+
 func main() {
 	err := shim.Start(new(InsuranceCrudChaincode))
 	if err != nil {
@@ -27,17 +123,42 @@ func main() {
 
 func (t *InsuranceCrudChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 
-	//Only applies in dev mode as the chaincode will be deployed with a fresh chaincodeId in prod mode
-	t.wipeData(stub)
+	//Once initialised, the chaincode can only be initialised again by a superuser
+	initialised, err := stub.GetState(INITIALISED_KEY)
+	if err != nil { return nil, err }
 
-	//Add new key holder
-	var keyHolder KeyHolder
-	keyHolder.Keys = []string{}
-	bytes, err := json.Marshal(keyHolder)
+	if len(initialised) > 0 && !t.isSuperUser(stub) {
+		return t.rejectInvoke(stub, function, AccessRejectedError{"", "Only a superuser can initialise the chaincode again"})
+	}
+
+	//Each argument registers a calling chaincode, in the form name:token, apart from the dev mode flag
+	devMode := false
+	registrations := []string{}
+
+	for _, arg := range args {
+		if arg == DEV_MODE_ARG {
+			devMode = true
+		} else {
+			registrations = append(registrations, arg)
+		}
+	}
+
+	err = t.registerCallers(stub, registrations)
+	if (err != nil) {fmt.Printf("\nError when registering callers: %s", err); return nil, err}
+
+	//Existing state may have been saved before the key index was introduced
+	err = t.migrateKeyHolder(stub)
+	if (err != nil) {fmt.Printf("\nError when migrating key holder: %s", err); return nil, err}
 
-	if (err != nil) {fmt.Printf("\nError when creating empty key holder: %s", err); return nil, err}
+	//Only wipe when asked to, in dev mode the chaincode keeps its state when deployed again with the same name
+	if devMode {
+		keysDeleted, err := t.wipeData(stub)
+		if (err != nil) {fmt.Printf("\nError when wiping state: %s", err); return nil, err}
 
-	err = stub.PutState(KEYHOLDER_KEY, bytes)
+		t.emitDataWipedEvent(stub, keysDeleted)
+	}
+
+	err = stub.PutState(INITIALISED_KEY, []byte("true"))
 
 	return nil, err
 }
@@ -46,19 +167,25 @@ func (t *InsuranceCrudChaincode) Init(stub shim.ChaincodeStubInterface, function
 func (t *InsuranceCrudChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	fmt.Println("invoke is running " + function)
 
-	//Allow init to be run externally
+	//Allow init to be run externally, Init checks that the caller is a superuser
 	if function == "init" {
 		return t.Init(stub, "init", args)
 	}
 
-	//Check that this chaincode was invoked internally by another chaincode contract
-	if !t.isTxIdValid(stub, args) {
-		fmt.Println("TxId does not match, aborting")
-		return nil, errors.New("TxId does not match")
-	}
+	//Check that this chaincode was invoked internally by a registered chaincode contract
+	args, err := t.authenticateCaller(stub, args)
+	if err != nil { return t.rejectInvoke(stub, function, err) }
 
 	if function == "save" {
 		return t.save(stub, args)
+	} else if function == "saveIfVersion" {
+		return t.saveIfVersion(stub, args)
+	} else if function == "saveBatch" {
+		return t.saveBatch(stub, args)
+	} else if function == "delete" {
+		return t.delete(stub, args)
+	} else if function == "deleteBatch" {
+		return t.deleteBatch(stub, args)
 	}
 	fmt.Println("invoke did not find func: " + function)
 
@@ -69,14 +196,19 @@ func (t *InsuranceCrudChaincode) Invoke(stub shim.ChaincodeStubInterface, functi
 func (t *InsuranceCrudChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	fmt.Println("query is running " + function)
 
-	//Check that this chaincode was invoked internally by another chaincode contract
-	if !t.isTxIdValid(stub, args) {
-		fmt.Println("TxId does not match, aborting")
-		return nil, errors.New("TxId does not match")
-	}
+	//Check that this chaincode was invoked internally by a registered chaincode contract.  A query can't emit an event,
+	//so the rejection is only logged.
+	args, err := t.authenticateCaller(stub, args)
+	if err != nil { fmt.Println("Rejected query " + function + ": " + err.Error()); return nil, err }
 
 	if function == "retrieve" {
 		return t.retrieve(stub, args)
+	} else if function == "retrieveVersioned" {
+		return t.retrieveVersioned(stub, args)
+	} else if function == "list" {
+		return t.list(stub, args)
+	} else if function == "range" {
+		return t.rangeQuery(stub, args)
 	}
 
 	fmt.Println("query did not find func: " + function)
@@ -94,12 +226,172 @@ func (t *InsuranceCrudChaincode) save(stub shim.ChaincodeStubInterface, args []s
 		return nil, errors.New("Incorrect number of arguments. Expecting 3 (TxId, Key, Value)")
 	}
 
-	err := stub.PutState(args[1], []byte(args[2]))
+	err := t.checkKeyIsValid(args[1])
+	if err != nil { return nil, err }
 
-	if (err == nil) {fmt.Println("SAVE (KEY " + args[1] + "): " + string(args[2]))}
+	return nil, t.putKey(stub, args[1], args[2], nil)
+}
 
-	err = t.addKey(stub, args[1])
-	return nil, err
+//=================================================================================================================================
+//	 Saves a value into state, with the specified key, only if the stored value is at the expected version.
+//	 An expected version of 0 only saves if there is no stored value.
+//		args - txId, key, value, expectedVersion
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) saveIfVersion(stub shim.ChaincodeStubInterface, args []string) ([]byte, error){
+
+	if len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4 (TxId, Key, Value, ExpectedVersion)")
+	}
+
+	err := t.checkKeyIsValid(args[1])
+	if err != nil { return nil, err }
+
+	expectedVersion, err := strconv.Atoi(args[3])
+	if err != nil { return nil, errors.New("Invalid expected version: " + args[3]) }
+
+	return nil, t.putKey(stub, args[1], args[2], &expectedVersion)
+}
+
+//=================================================================================================================================
+//	 Saves a batch of values into state.  Every key and expected version is checked before anything is saved.
+//		args - txId, keyValues (JSON array of {"key":..., "value":..., "expectedVersion":...}, expectedVersion is optional)
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) saveBatch(stub shim.ChaincodeStubInterface, args []string) ([]byte, error){
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 (TxId, KeyValues)")
+	}
+
+	var keyValues []KeyValue
+
+	err := json.Unmarshal([]byte(args[1]), &keyValues)
+	if err != nil { return nil, errors.New("Unable to parse key values: " + err.Error()) }
+
+	for _, keyValue := range keyValues {
+		err = t.checkKeyIsValid(keyValue.Key)
+		if err != nil { return nil, err }
+
+		err = t.checkVersion(stub, keyValue.Key, keyValue.ExpectedVersion)
+		if err != nil { return nil, err }
+	}
+
+	for _, keyValue := range keyValues {
+		err = t.putKey(stub, keyValue.Key, keyValue.Value, keyValue.ExpectedVersion)
+		if err != nil { return nil, err }
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 Deletes the value with the specified key from state
+//		args - txId, key
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) delete(stub shim.ChaincodeStubInterface, args []string) ([]byte, error){
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 (TxId, Key)")
+	}
+
+	err := t.checkKeyIsValid(args[1])
+	if err != nil { return nil, err }
+
+	return nil, t.deleteKey(stub, args[1])
+}
+
+//=================================================================================================================================
+//	 Deletes a batch of values from state.  Every key is checked before anything is deleted.
+//		args - txId, keys (JSON array of keys)
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) deleteBatch(stub shim.ChaincodeStubInterface, args []string) ([]byte, error){
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 (TxId, Keys)")
+	}
+
+	var keys []string
+
+	err := json.Unmarshal([]byte(args[1]), &keys)
+	if err != nil { return nil, errors.New("Unable to parse keys: " + err.Error()) }
+
+	for _, key := range keys {
+		err = t.checkKeyIsValid(key)
+		if err != nil { return nil, err }
+	}
+
+	for _, key := range keys {
+		err = t.deleteKey(stub, key)
+		if err != nil { return nil, err }
+	}
+
+	return nil, nil
+}
+
+func (t *InsuranceCrudChaincode) checkKeyIsValid(key string) (error) {
+	if key == "" { return errors.New("Invalid key, key cannot be empty") }
+
+	if strings.HasPrefix(key, KEY_INDEX_PREFIX) {
+		return errors.New("Invalid key, cannot write to the key index: " + key)
+	}
+
+	if strings.HasPrefix(key, REGISTERED_CALLER_PREFIX) {
+		return errors.New("Invalid key, cannot write to the registered callers: " + key)
+	}
+
+	if key == INITIALISED_KEY {
+		return errors.New("Invalid key, reserved by the chaincode: " + key)
+	}
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 Puts the value into state and adds the key to the key index with the next version.  If an expected version is given,
+//	 the value is only saved if the stored value is at that version.
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) putKey(stub shim.ChaincodeStubInterface, key string, value string, expectedVersion *int) (error) {
+	version, err := t.getVersion(stub, key)
+	if err != nil { return err }
+
+	if expectedVersion != nil && *expectedVersion != version {
+		fmt.Println("VERSION CONFLICT (KEY " + key + "): expected " + strconv.Itoa(*expectedVersion) + ", found " + strconv.Itoa(version))
+		return VersionConflictError{key, *expectedVersion, version}
+	}
+
+	err = stub.PutState(key, []byte(value))
+
+	if (err != nil) {fmt.Printf("\nError when saving key " + key + ": %s", err); return err}
+
+	fmt.Println("SAVE (KEY " + key + ", VERSION " + strconv.Itoa(version + 1) + "): " + value)
+
+	return t.addKey(stub, key, version + 1)
+}
+
+//=================================================================================================================================
+//	 Checks that the stored value is at the expected version, if one is given
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) checkVersion(stub shim.ChaincodeStubInterface, key string, expectedVersion *int) (error) {
+	if expectedVersion == nil { return nil }
+
+	version, err := t.getVersion(stub, key)
+	if err != nil { return err }
+
+	if *expectedVersion != version { return VersionConflictError{key, *expectedVersion, version} }
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 Deletes the value from state and removes the key from the key index
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) deleteKey(stub shim.ChaincodeStubInterface, key string) (error) {
+	err := stub.DelState(key)
+
+	if (err != nil) {fmt.Printf("\nError when deleting key " + key + ": %s", err); return err}
+
+	fmt.Println("DELETE (KEY " + key + ")")
+
+	return stub.DelState(KEY_INDEX_PREFIX + key)
 }
 
 //=================================================================================================================================
@@ -119,6 +411,111 @@ func (t *InsuranceCrudChaincode) retrieve(stub shim.ChaincodeStubInterface, args
 	return bytes, err;
 }
 
+//=================================================================================================================================
+//	 Retrieves a value from state, with the specified key, along with its version.  A key with no value has version 0.
+//		args - txId, key
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) retrieveVersioned(stub shim.ChaincodeStubInterface, args []string) ([]byte, error){
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 (TxId, Key)")
+	}
+
+	bytes, err := stub.GetState(args[1])
+	if err != nil { return nil, err }
+
+	version, err := t.getVersion(stub, args[1])
+	if err != nil { return nil, err }
+
+	fmt.Println("RETRIEVE (KEY " + args[1] + ", VERSION " + strconv.Itoa(version) + "): " + string(bytes))
+
+	return json.Marshal(KeyValue{Key: args[1], Value: string(bytes), Version: version})
+}
+
+//=================================================================================================================================
+//	 Retrieves a page of the key/value pairs with keys starting with the specified prefix
+//		args - txId, prefix, pageSize, [startAfter]
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) list(stub shim.ChaincodeStubInterface, args []string) ([]byte, error){
+
+	if len(args) != 3 && len(args) != 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 or 4 (TxId, Prefix, PageSize, [StartAfter])")
+	}
+
+	return t.retrievePage(stub, args[1], args[1] + string(utf8.MaxRune), args[2], args[3:])
+}
+
+//=================================================================================================================================
+//	 Retrieves a page of the key/value pairs with keys from startKey (inclusive) to endKey (exclusive).
+//	 An empty endKey retrieves all keys from the startKey onwards.
+//		args - txId, startKey, endKey, pageSize, [startAfter]
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) rangeQuery(stub shim.ChaincodeStubInterface, args []string) ([]byte, error){
+
+	if len(args) != 4 && len(args) != 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 4 or 5 (TxId, StartKey, EndKey, PageSize, [StartAfter])")
+	}
+
+	endKey := args[2]
+	if endKey == "" { endKey = string(utf8.MaxRune) }
+
+	return t.retrievePage(stub, args[1], endKey, args[3], args[4:])
+}
+
+func (t *InsuranceCrudChaincode) retrievePage(stub shim.ChaincodeStubInterface, startKey string, endKey string, pageSizeStr string, startAfter []string) ([]byte, error){
+
+	pageSize, err := strconv.Atoi(pageSizeStr)
+	if err != nil || pageSize <= 0 || pageSize > MAX_PAGE_SIZE { pageSize = MAX_PAGE_SIZE }
+
+	//Continue from the key after the last one returned in the previous page
+	if len(startAfter) == 1 && startAfter[0] != "" && startAfter[0] >= startKey {
+		startKey = startAfter[0] + "\x00"
+	}
+
+	page, err := t.getPage(stub, startKey, endKey, pageSize)
+	if err != nil { fmt.Printf("\nError when retrieving page: %s", err); return nil, err }
+
+	fmt.Println("PAGE (" + startKey + " - " + endKey + "): " + strconv.Itoa(len(page.Entries)) + " entries")
+
+	return json.Marshal(page)
+}
+
+//=================================================================================================================================
+//	 Gets a page of key/value pairs from the key index, for keys from startKey (inclusive) to endKey (exclusive)
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) getPage(stub shim.ChaincodeStubInterface, startKey string, endKey string, pageSize int) (Page, error) {
+	var page Page
+	page.Entries = []KeyValue{}
+
+	iterator, err := stub.RangeQueryState(KEY_INDEX_PREFIX + startKey, KEY_INDEX_PREFIX + endKey)
+	if err != nil { return page, err }
+
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		indexKey, marker, err := iterator.Next()
+		if err != nil { return page, err }
+
+		key := indexKey[len(KEY_INDEX_PREFIX):]
+
+		//The range may include the end key itself
+		if key >= endKey { break }
+
+		//There are more results, so tell the caller where to continue from
+		if len(page.Entries) == pageSize {
+			page.NextKey = page.Entries[pageSize - 1].Key
+			break
+		}
+
+		value, err := stub.GetState(key)
+		if err != nil { return page, err }
+
+		page.Entries = append(page.Entries, KeyValue{Key: key, Value: string(value), Version: parseVersion(marker)})
+	}
+
+	return page, nil
+}
+
 //=================================================================================================================================
 //	 This chaincode should not be invoked externally.  To confirm that the invoke/query was performed from
 //	 another chaincode, the txId must be passed as the first argument, and if this does not match the txId
@@ -134,43 +531,191 @@ func (t *InsuranceCrudChaincode) isTxIdValid(stub shim.ChaincodeStubInterface, a
 	return stub.GetTxID() == args[0]
 }
 
-//Wipes all data
-func (t *InsuranceCrudChaincode) wipeData(stub shim.ChaincodeStubInterface){
+//=================================================================================================================================
+//	 Checks that the invoke/query was sent by a registered chaincode.  The caller's name and token must follow the txId
+//	 in the arguments, i.e. [txId, callerName, token, ...].  Returns the arguments with the caller's name and token
+//	 removed, so that the functions still receive the txId first.  Returns an AccessRejectedError if the caller could not be
+//	 authenticated.
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) authenticateCaller(stub shim.ChaincodeStubInterface, args []string) ([]string, error) {
 
-	fmt.Println("WIPING STATE")
+	if !t.isTxIdValid(stub, args) {
+		return nil, AccessRejectedError{"", "TxId does not match"}
+	}
+
+	if len(args) < 3 {
+		return nil, AccessRejectedError{"", "Caller name and token not provided"}
+	}
+
+	callerName := args[1]
 
-	keyHolder, err := t.getKeyHolder(stub)
+	tokenHash, err := stub.GetState(REGISTERED_CALLER_PREFIX + callerName)
+	if err != nil || len(tokenHash) == 0 {
+		return nil, AccessRejectedError{callerName, "Caller is not registered"}
+	}
+
+	if subtle.ConstantTimeCompare(tokenHash, []byte(hashToken(args[2]))) != 1 {
+		return nil, AccessRejectedError{callerName, "Invalid token"}
+	}
 
-	if (err != nil) {fmt.Printf("\nError when wiping state: %s", err)}
+	return append([]string{args[0]}, args[3:]...), nil
+}
+
+//=================================================================================================================================
+//	 Rejects an invoke by emitting an access rejected event.  Fabric doesn't deliver the events of a failed transaction,
+//	 so instead of returning an error the invoke succeeds without writing anything, with the rejection as its response.
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) rejectInvoke(stub shim.ChaincodeStubInterface, function string, err error) ([]byte, error) {
+	rejection, ok := err.(AccessRejectedError)
+	if !ok { return nil, err }
+
+	fmt.Println("Rejected " + function + " from caller '" + rejection.Caller + "': " + rejection.Reason)
+
+	event := AccessRejectedEvent{EVENT_TYPE_ACCESS_REJECTED, function, rejection.Caller, rejection.Reason, stub.GetTxID()}
+
+	eventBytes, err := json.Marshal(&event)
 	if err == nil {
-		for _,key := range keyHolder.Keys {
-			stub.DelState(key)
-			fmt.Println("Deleted key: " + key)
+		stub.SetEvent(event.Type, eventBytes)
+	}
+
+	return []byte(rejection.Error()), nil
+}
+
+//=================================================================================================================================
+//	 Registers the chaincodes that are allowed to call this chaincode.  Each argument is in the form name:token.
+//	 Registering an existing name replaces its token.
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) registerCallers(stub shim.ChaincodeStubInterface, args []string) (error) {
+	for _, registration := range args {
+		parts := strings.SplitN(registration, ":", 2)
+
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return errors.New("Invalid caller registration, expected name:token")
 		}
+
+		err := stub.PutState(REGISTERED_CALLER_PREFIX + parts[0], []byte(hashToken(parts[1])))
+		if err != nil { return err }
+
+		fmt.Println("Registered caller: " + parts[0])
 	}
+
+	return nil
+}
+
+func hashToken(token string) (string) {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
 }
 
-func (t *InsuranceCrudChaincode) addKey(stub shim.ChaincodeStubInterface, key string) (error) {
-	bytes, _ := stub.GetState(KEYHOLDER_KEY)
+//Wipes all data, returning the number of keys deleted
+func (t *InsuranceCrudChaincode) wipeData(stub shim.ChaincodeStubInterface) (int, error) {
+
+	fmt.Println("WIPING STATE")
+
+	keys, err := t.getKeys(stub)
+	if err != nil { return 0, err }
 
-	keyHolder, err := t.getKeyHolder(stub)
+	for _,key := range keys {
+		err = stub.DelState(key)
+		if err != nil { return 0, err }
 
+		err = stub.DelState(KEY_INDEX_PREFIX + key)
+		if err != nil { return 0, err }
+
+		fmt.Println("Deleted key: " + key)
+	}
+
+	return len(keys), nil
+}
+
+func (t *InsuranceCrudChaincode) emitDataWipedEvent(stub shim.ChaincodeStubInterface, keysDeleted int) {
+	event := DataWipedEvent{EVENT_TYPE_DATA_WIPED, keysDeleted, stub.GetTxID()}
+
+	eventBytes, err := json.Marshal(&event)
 	if err == nil {
-		keyHolder.Keys = append(keyHolder.Keys, key)
+		stub.SetEvent(event.Type, eventBytes)
+	}
+}
 
-		bytes, err = json.Marshal(keyHolder)
-		stub.PutState(KEYHOLDER_KEY, bytes)
+//=================================================================================================================================
+//	 Checks the role attribute of the caller's certificate
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) isSuperUser(stub shim.ChaincodeStubInterface) (bool) {
+	role, err := stub.ReadCertAttribute("role")
+	if err != nil { fmt.Printf("Couldn't get attribute 'role'. Error: %s", err); return false }
+
+	return string(role) == ROLE_SUPER_USER
+}
+
+//=================================================================================================================================
+//	 Adds a marker for the key to the key index, holding the version of the value.  Saving the same key again just
+//	 overwrites the marker.
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) addKey(stub shim.ChaincodeStubInterface, key string, version int) (error) {
+	return stub.PutState(KEY_INDEX_PREFIX + key, []byte(strconv.Itoa(version)))
+}
+
+//=================================================================================================================================
+//	 Gets the version of the value stored with the key, or 0 if there is no value
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) getVersion(stub shim.ChaincodeStubInterface, key string) (int, error) {
+	marker, err := stub.GetState(KEY_INDEX_PREFIX + key)
+	if err != nil { return 0, err }
+
+	return parseVersion(marker), nil
+}
+
+func parseVersion(marker []byte) (int) {
+	if len(marker) == 0 { return 0 }
+
+	version, err := strconv.Atoi(string(marker))
+
+	//Keys indexed before versions were introduced hold the key rather than a version
+	if err != nil { return 1 }
+
+	return version
+}
+
+//=================================================================================================================================
+//	 Gets all of the keys in the key index
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) getKeys(stub shim.ChaincodeStubInterface) ([]string, error) {
+	keys := []string{}
+
+	iterator, err := stub.RangeQueryState(KEY_INDEX_PREFIX, KEY_INDEX_END)
+	if err != nil { return keys, err }
+
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		indexKey, _, err := iterator.Next()
+		if err != nil { return keys, err }
+
+		keys = append(keys, indexKey[len(KEY_INDEX_PREFIX):])
 	}
 
-	return err
+	return keys, nil
 }
 
-func (t *InsuranceCrudChaincode) getKeyHolder(stub shim.ChaincodeStubInterface) (KeyHolder, error) {
-	bytes, _ := stub.GetState(KEYHOLDER_KEY)
+//=================================================================================================================================
+//	 Moves any keys held in the old key holder into the key index, then removes the key holder
+//=================================================================================================================================
+func (t *InsuranceCrudChaincode) migrateKeyHolder(stub shim.ChaincodeStubInterface) (error) {
+	bytes, err := stub.GetState(KEYHOLDER_KEY)
+
+	if err != nil || len(bytes) == 0 { return err }
 
 	var keyHolder KeyHolder
 
-	err := json.Unmarshal(bytes, &keyHolder);
+	err = json.Unmarshal(bytes, &keyHolder)
+	if err != nil { return err }
+
+	fmt.Println("MIGRATING KEY HOLDER")
+
+	for _, key := range keyHolder.Keys {
+		err = t.addKey(stub, key, 1)
+		if err != nil { return err }
+	}
 
-	return keyHolder, err
+	return stub.DelState(KEYHOLDER_KEY)
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	crudTestStub - Holds state in memory.  Only the stub functions used by the CRUD chaincode are implemented.
+//==============================================================================================================================
+type crudTestStub struct {
+	shim.ChaincodeStubInterface
+	state		map[string][]byte
+	txId		string
+	attributes	map[string]string
+	events		map[string][]byte
+}
+
+func newCrudTestStub() (*crudTestStub) {
+	return &crudTestStub{state: map[string][]byte{}, txId: "tx1", attributes: map[string]string{}, events: map[string][]byte{}}
+}
+
+func (s *crudTestStub) GetTxID() (string) { return s.txId }
+
+func (s *crudTestStub) GetState(key string) ([]byte, error) { return s.state[key], nil }
+
+func (s *crudTestStub) PutState(key string, value []byte) (error) { s.state[key] = value; return nil }
+
+func (s *crudTestStub) DelState(key string) (error) { delete(s.state, key); return nil }
+
+func (s *crudTestStub) SetEvent(name string, payload []byte) (error) { s.events[name] = payload; return nil }
+
+func (s *crudTestStub) ReadCertAttribute(name string) ([]byte, error) {
+	value, found := s.attributes[name]
+	if !found { return nil, errors.New("No attribute " + name) }
+
+	return []byte(value), nil
+}
+
+func (s *crudTestStub) RangeQueryState(startKey string, endKey string) (shim.StateRangeQueryIteratorInterface, error) {
+	keys := []string{}
+
+	for key := range s.state {
+		if key >= startKey && key < endKey { keys = append(keys, key) }
+	}
+
+	sort.Strings(keys)
+
+	return &crudTestIterator{s, keys}, nil
+}
+
+type crudTestIterator struct {
+	stub	*crudTestStub
+	keys	[]string
+}
+
+func (t *crudTestIterator) HasNext() (bool) { return len(t.keys) > 0 }
+
+func (t *crudTestIterator) Next() (string, []byte, error) {
+	key := t.keys[0]
+	t.keys = t.keys[1:]
+
+	return key, t.stub.state[key], nil
+}
+
+func (t *crudTestIterator) Close() (error) { return nil }
+
+func crudTestCall(stub *crudTestStub, args ...string) ([]string) {
+	return append([]string{stub.txId, "insurance_main", "token"}, args...)
+}
+
+func retrieveTestVersion(t *testing.T, cc *InsuranceCrudChaincode, stub *crudTestStub, key string) (KeyValue) {
+	bytes, err := cc.Query(stub, "retrieveVersioned", crudTestCall(stub, key))
+	if err != nil { t.Fatalf("retrieveVersioned %s: %s", key, err) }
+
+	var keyValue KeyValue
+
+	err = json.Unmarshal(bytes, &keyValue)
+	if err != nil { t.Fatal(err) }
+
+	return keyValue
+}
+
+func TestSaveBatchRejectsStaleVersion(t *testing.T) {
+	cc := new(InsuranceCrudChaincode)
+	stub := newCrudTestStub()
+
+	_, err := cc.Init(stub, "init", []string{"insurance_main:token"})
+	if err != nil { t.Fatal(err) }
+
+	for _, key := range []string{"claim:C1", "claim:C2"} {
+		_, err = cc.Invoke(stub, "save", crudTestCall(stub, key, "first"))
+		if err != nil { t.Fatal(err) }
+	}
+
+	//Read at version N, then another transaction saves the claim, moving it to N+1
+	read := retrieveTestVersion(t, cc, stub, "claim:C1")
+
+	_, err = cc.Invoke(stub, "save", crudTestCall(stub, "claim:C1", "second"))
+	if err != nil { t.Fatal(err) }
+
+	expected := read.Version
+	batch, _ := json.Marshal([]KeyValue{
+		{Key: "claim:C2", Value: "stale"},
+		{Key: "claim:C1", Value: "stale", ExpectedVersion: &expected},
+	})
+
+	_, err = cc.Invoke(stub, "saveBatch", crudTestCall(stub, string(batch)))
+	if err == nil || !strings.HasPrefix(err.Error(), VERSION_CONFLICT_ERROR_PREFIX) {
+		t.Fatalf("Expected a version conflict, got: %v", err)
+	}
+
+	var conflict VersionConflictError
+
+	err = json.Unmarshal([]byte(strings.TrimPrefix(err.Error(), VERSION_CONFLICT_ERROR_PREFIX)), &conflict)
+	if err != nil || conflict.Key != "claim:C1" || conflict.ExpectedVersion != read.Version || conflict.ActualVersion != read.Version + 1 {
+		t.Fatalf("Unexpected conflict details: %+v %v", conflict, err)
+	}
+
+	//Nothing in the batch is written, including the key listed before the conflict
+	c1 := retrieveTestVersion(t, cc, stub, "claim:C1")
+	if c1.Value != "second" || c1.Version != read.Version + 1 { t.Fatalf("claim:C1 changed: %+v", c1) }
+
+	c2 := retrieveTestVersion(t, cc, stub, "claim:C2")
+	if c2.Value != "first" || c2.Version != 1 { t.Fatalf("claim:C2 partly written: %+v", c2) }
+}
+
+func TestSaveBatchAtExpectedVersion(t *testing.T) {
+	cc := new(InsuranceCrudChaincode)
+	stub := newCrudTestStub()
+
+	_, err := cc.Init(stub, "init", []string{"insurance_main:token"})
+	if err != nil { t.Fatal(err) }
+
+	_, err = cc.Invoke(stub, "save", crudTestCall(stub, "claim:C1", "first"))
+	if err != nil { t.Fatal(err) }
+
+	expected := retrieveTestVersion(t, cc, stub, "claim:C1").Version
+	batch, _ := json.Marshal([]KeyValue{{Key: "claim:C1", Value: "second", ExpectedVersion: &expected}})
+
+	_, err = cc.Invoke(stub, "saveBatch", crudTestCall(stub, string(batch)))
+	if err != nil { t.Fatal(err) }
+
+	c1 := retrieveTestVersion(t, cc, stub, "claim:C1")
+	if c1.Value != "second" || c1.Version != expected + 1 { t.Fatalf("claim:C1 not saved: %+v", c1) }
+}
+
+func TestRejectedInvokeEmitsEventWithoutWriting(t *testing.T) {
+	cc := new(InsuranceCrudChaincode)
+	stub := newCrudTestStub()
+
+	_, err := cc.Init(stub, "init", []string{"insurance_main:token"})
+	if err != nil { t.Fatal(err) }
+
+	keys := len(stub.state)
+
+	//The invoke succeeds, so that the event is delivered, but nothing is written
+	response, err := cc.Invoke(stub, "save", []string{stub.txId, "insurance_main", "wrong", "claim:C1", "first"})
+	if err != nil { t.Fatalf("A rejected invoke should not fail: %s", err) }
+
+	if !strings.HasPrefix(string(response), ACCESS_REJECTED_PREFIX) { t.Fatalf("Unexpected response: %s", response) }
+	if len(stub.state) != keys { t.Fatal("A rejected invoke wrote to state") }
+
+	var event AccessRejectedEvent
+
+	err = json.Unmarshal(stub.events[EVENT_TYPE_ACCESS_REJECTED], &event)
+	if err != nil || event.Function != "save" || event.Caller != "insurance_main" || event.Reason != "Invalid token" {
+		t.Fatalf("Unexpected access rejected event: %+v %v", event, err)
+	}
+
+	//Initialising again without being a superuser is rejected in the same way
+	delete(stub.events, EVENT_TYPE_ACCESS_REJECTED)
+
+	response, err = cc.Init(stub, "init", []string{"intruder:token", DEV_MODE_ARG})
+	if err != nil || !strings.HasPrefix(string(response), ACCESS_REJECTED_PREFIX) { t.Fatalf("Unexpected rejection of init: %s %v", response, err) }
+
+	if len(stub.state) != keys || len(stub.state[REGISTERED_CALLER_PREFIX + "intruder"]) != 0 { t.Fatal("A rejected init wrote to state") }
+	if _, found := stub.events[EVENT_TYPE_ACCESS_REJECTED]; !found { t.Fatal("No event was emitted for the rejected init") }
+
+	//Queries can't emit events, so a rejected query fails
+	delete(stub.events, EVENT_TYPE_ACCESS_REJECTED)
+
+	_, err = cc.Query(stub, "retrieve", []string{stub.txId, "insurance_main", "wrong", "claim:C1"})
+	if err == nil || !strings.HasPrefix(err.Error(), ACCESS_REJECTED_PREFIX) { t.Fatalf("Expected the query to be rejected, got: %v", err) }
+}
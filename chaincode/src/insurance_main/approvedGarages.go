@@ -2,6 +2,8 @@ package main
 
 //==============================================================================================================================
 //	ApprovedGarages - Defines the structure for an ApprovedGarages object.
+//		The list of approved garage names saved before garage profiles were introduced.  Only read to migrate the
+//		garages to profiles when the chaincode is initialised.
 //==============================================================================================================================
 type ApprovedGarages struct {
 	Garages		[]string	`json:"garages"`
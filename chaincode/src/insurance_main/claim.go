@@ -24,18 +24,24 @@ type ClaimDetails struct {
 	Status		string							`json:"status"`
 	Description	string							`json:"description"`
 	Incident	ClaimDetailsIncident			`json:"incident"`
+	PoliceReport	PoliceReport				`json:"policeReport"`
 	Report		ClaimDetailsClaimGarageReport	`json:"report"`
 	Repair		RepairWorkOrder             	`json:"repair"`
 	Settlement	ClaimDetailsSettlement			`json:"settlement"`
-	IsLiable	bool							`json:"liable"`
+	LiabilityShare	int							`json:"liabilityShare"`
+	LiabilityAccepted	bool						`json:"liabilityAccepted"`
+	LiabilityDispute	ClaimDetailsLiabilityDispute	`json:"liabilityDispute"`
+	AssignedGarage		string							`json:"assignedGarage"`
+	GarageAssignments	[]ClaimDetailsGarageAssignment	`json:"garageAssignments"`
 }
 
 //==============================================================================================================================
 //	ClaimDetailsIncident - Defines the structure for a ClaimDetailsIncident object.
 //==============================================================================================================================
 type ClaimDetailsIncident struct {
-	Date	string	`json:"date"`
-	Type	string	`json:"type"`
+	Date					string	`json:"date"`
+	Type					string	`json:"type"`
+	PoliceReportRequired	bool	`json:"policeReportRequired"`
 }
 
 //==============================================================================================================================
@@ -48,6 +54,37 @@ type ClaimDetailsClaimGarageReport struct {
 	Notes		string	`json:"notes"`
 }
 
+//==============================================================================================================================
+//	ClaimDetailsGarageAssignment - Defines the structure for a ClaimDetailsGarageAssignment object.
+//		A garage assigned to a claim by the claimant or insurer.  Every assignment is kept, the last one being current.
+//==============================================================================================================================
+type ClaimDetailsGarageAssignment struct {
+	Garage		string	`json:"garage"`
+	AssignedBy	string	`json:"assignedBy"`
+	Reason		string	`json:"reason"`
+	TxId		string	`json:"txId"`
+	Timestamp	string	`json:"timestamp"`
+}
+
+//==============================================================================================================================
+//	ClaimDetailsLiabilityDispute - Defines the structure for a ClaimDetailsLiabilityDispute object.
+//==============================================================================================================================
+type ClaimDetailsLiabilityDispute struct {
+	Submissions		[]ClaimDetailsLiabilityDisputeSubmission	`json:"submissions"`
+	AgreedShares	map[string]int								`json:"agreedLiabilityShares"`
+}
+
+//==============================================================================================================================
+//	ClaimDetailsLiabilityDisputeSubmission - Defines the structure for a ClaimDetailsLiabilityDisputeSubmission object.
+//		The evidence and proposed resolution submitted by the insurer of one of the parties (ClaimId) in the dispute
+//==============================================================================================================================
+type ClaimDetailsLiabilityDisputeSubmission struct {
+	Insurer				string	`json:"insurer"`
+	ClaimId				string	`json:"claimId"`
+	Evidence			string			`json:"evidence"`
+	ProposedShares		map[string]int	`json:"proposedLiabilityShares"`
+}
+
 //==============================================================================================================================
 //	ClaimDetailsSettlement - Defines the structure for a ClaimDetailsSettlement object.
 //==============================================================================================================================
@@ -77,6 +114,8 @@ type ClaimDetailsSettlementPayment struct {
 	Status			string		`json:"status"`
 	SenderType		string		`json:"senderType"`
 	Sender			string		`json:"sender"`
+	LinkedClaim		string		`json:"linkedClaim"`
+	LinkedPayment	string		`json:"linkedPayment"`
 }
 
 //==============================================================================================================================
@@ -84,8 +123,9 @@ type ClaimDetailsSettlementPayment struct {
 //==============================================================================================================================
 type ClaimRelations struct {
 	RelatedPolicy	string		`json:"relatedPolicy"`
-	OtherPartyReg	string		`json:"otherPartyRegistration"`
+	OtherPartyRegs	[]string	`json:"otherPartyRegistrations"`
 	LinkedClaims	[]string	`json:"linkedClaims"`
+	ThirdParties	[]string	`json:"thirdParties"`
 }
 
 //=============================================================================================================================
@@ -103,11 +143,15 @@ type RepairWorkOrder struct {
 	WorkStatus             string   `json:"workStatus"`
 }
 
+//Format of the start and end dates of a repair work order
+const   WORK_ORDER_DATE_FORMAT	= "2006-01-02"
+
 //==============================================================================================================================
 //	 Claim Status types
 //==============================================================================================================================
 const   STATE_AWAITING_POLICE_REPORT                = "awaiting_police_report"
 const	STATE_AWAITING_LIABILITY_ACCEPTANCE			= "awaiting_liability_acceptance"
+const   STATE_LIABILITY_DISPUTED                    = "liability_disputed"
 const   STATE_AWAITING_GARAGE_REPORT                = "awaiting_garage_report"
 const   STATE_PENDING_AFTER_REPORT_DECISION         = "pending_decision"
 const   STATE_TOTAL_LOSS_ESTABLISHED                = "total_loss_established"
@@ -133,6 +177,12 @@ const   MULTIPLE_PARTIES  			=  "multiple_parties"
 const   TOTAL_LOSS          =  "total_loss"
 const   LIABILITY  			=  "liability"
 const   NOT_AT_FAULT        =  "not_at_fault"
+const   REPAIR              =  "repair"
+
+//==============================================================================================================================
+//	 Liability shares are percentages, the shares across a set of linked claims must add up to FULL_LIABILITY
+//==============================================================================================================================
+const   FULL_LIABILITY      =  100
 
 //
 //
@@ -154,22 +204,29 @@ func NewClaim(id string, relatedPolicy string, description string, incidentDate
 
 	claim.Relations.RelatedPolicy = relatedPolicy
 	claim.Relations.LinkedClaims = []string{}
+	claim.Relations.OtherPartyRegs = []string{}
+	claim.Relations.ThirdParties = []string{}
 	claim.Details.Description = description
 	claim.Details.Incident.Date = incidentDate
 	claim.Details.Incident.Type = incidentType
 	claim.Details.Settlement.Payments = []ClaimDetailsSettlementPayment{}
-	claim.Details.IsLiable = true
+	claim.Details.LiabilityDispute.Submissions = []ClaimDetailsLiabilityDisputeSubmission{}
+	claim.Details.GarageAssignments = []ClaimDetailsGarageAssignment{}
+	claim.Details.LiabilityShare = FULL_LIABILITY
 
 	return claim
 }
 
 //=================================================================================================================================
 //	 AddPayment - Adds a new payment to a claim, assigning the payment an id that is unique for the claim
+//	 Returns the payment with the assigned id
 //=================================================================================================================================
-func (t *Claim) AddPayment(payment ClaimDetailsSettlementPayment) {
+func (t *Claim) AddPayment(payment ClaimDetailsSettlementPayment) (ClaimDetailsSettlementPayment) {
 	payment.Id = strconv.Itoa(len(t.Details.Settlement.Payments) + 1)
 
 	t.Details.Settlement.Payments = append(t.Details.Settlement.Payments, payment)
+
+	return payment
 }
 
 //=================================================================================================================================
@@ -181,7 +238,7 @@ func (t *Claim) UpdatePayment(payment ClaimDetailsSettlementPayment) (error){
 		if aPayment.Id == payment.Id { paymentIndex = index}
 	}
 
-	if paymentIndex == -1 { errors.New("Unable to find payment with id: " + payment.Id) }
+	if paymentIndex == -1 { return errors.New("Unable to find payment with id: " + payment.Id) }
 
 	t.Details.Settlement.Payments[paymentIndex] = payment
 
@@ -211,21 +268,110 @@ func (t *Claim) AreAllPaymentsPaid() (bool){
 }
 
 //=================================================================================================================================
-//	 GetLiableClaim	- Gets the liable claim from the list of linked claims.  (Or the claim in question if it is liable)
+//	 AddLiabilityDisputeSubmission - Adds a liability dispute submission to a claim, replacing any earlier submission
+//	 made for the same party
 //=================================================================================================================================
-func (t *Claim) GetLiableClaim(stub shim.ChaincodeStubInterface) (Claim, error){
-	if (t.Details.IsLiable) {
-		return *t, nil
+func (t *Claim) AddLiabilityDisputeSubmission(submission ClaimDetailsLiabilityDisputeSubmission) {
+	for index, aSubmission := range t.Details.LiabilityDispute.Submissions {
+		if aSubmission.ClaimId == submission.ClaimId {
+			t.Details.LiabilityDispute.Submissions[index] = submission
+			return
+		}
 	}
 
+	t.Details.LiabilityDispute.Submissions = append(t.Details.LiabilityDispute.Submissions, submission)
+}
+
+//=================================================================================================================================
+//	 GetLiabilityDisputeSubmission - Retrieves the liability dispute submission made for the party with the specified claim id
+//=================================================================================================================================
+func (t *Claim) GetLiabilityDisputeSubmission(claimId string) (ClaimDetailsLiabilityDisputeSubmission, bool) {
+	for _, submission := range t.Details.LiabilityDispute.Submissions {
+		if submission.ClaimId == claimId { return submission, true }
+	}
+
+	return ClaimDetailsLiabilityDisputeSubmission{}, false
+}
+
+//=================================================================================================================================
+//	 AssignGarage - Makes the garage the one assigned to the claim, keeping the earlier assignments
+//=================================================================================================================================
+func (t *Claim) AssignGarage(assignment ClaimDetailsGarageAssignment) {
+	t.Details.AssignedGarage = assignment.Garage
+	t.Details.GarageAssignments = append(t.Details.GarageAssignments, assignment)
+}
+
+//=================================================================================================================================
+//	 IsLiable	- Checks if the claimant holds any share of the liability
+//=================================================================================================================================
+func (t *Claim) IsLiable() (bool){
+	return t.Details.LiabilityShare > 0
+}
+
+//=================================================================================================================================
+//	 GetLiableClaims	- Gets the linked claims that hold a share of the liability
+//=================================================================================================================================
+func (t *Claim) GetLiableClaims(stub shim.ChaincodeStubInterface) ([]Claim, error){
+	liableClaims := []Claim{}
+
 	for _, claimId := range t.Relations.LinkedClaims {
 		linkedClaim, err := RetrieveClaim(stub, claimId)
-		if err != nil { fmt.Printf("Unable to retrieve linked claim: %s", err); return *t, err }
+		if err != nil { fmt.Printf("Unable to retrieve linked claim: %s", err); return liableClaims, err }
+
+		if (linkedClaim.IsLiable()) { liableClaims = append(liableClaims, linkedClaim) }
+	}
+
+	//Third parties may hold the rest of the liability
+	if len(liableClaims) == 0 && !t.IsLiable() && len(t.Relations.ThirdParties) == 0 { return liableClaims, errors.New("Unable to obtain liable claim") }
 
-		if (linkedClaim.Details.IsLiable) { return linkedClaim, nil }
+	return liableClaims, nil
+}
+
+//=================================================================================================================================
+//	 ValidateLiabilityShares	- Checks that the liability shares of a set of linked claims and third parties add up to 100%
+//=================================================================================================================================
+func ValidateLiabilityShares(claims []Claim, thirdParties []ThirdParty) (error){
+	total := 0
+
+	for _, claim := range claims {
+		if claim.Details.LiabilityShare < 0 || claim.Details.LiabilityShare > FULL_LIABILITY {
+			return errors.New("Invalid liability share for claim: " + claim.Id)
+		}
+
+		total += claim.Details.LiabilityShare
+	}
+
+	for _, thirdParty := range thirdParties {
+		if thirdParty.Details.LiabilityShare < 0 || thirdParty.Details.LiabilityShare > FULL_LIABILITY {
+			return errors.New("Invalid liability share for third party: " + thirdParty.Id)
+		}
+
+		total += thirdParty.Details.LiabilityShare
+	}
+
+	if total != FULL_LIABILITY {
+		return errors.New("Liability shares must add up to " + strconv.Itoa(FULL_LIABILITY) + "%, but add up to " + strconv.Itoa(total) + "%")
 	}
 
-	return *t, errors.New("Unable to obtain liable claim")
+	return nil
+}
+
+//=================================================================================================================================
+//	 ParseLiabilityShare	- Parses a liability share, given either as a percentage or as true/false for full or no liability
+//=================================================================================================================================
+func ParseLiabilityShare(shareStr string) (int, error){
+	liable, err := strconv.ParseBool(shareStr)
+	if err == nil {
+		if liable { return FULL_LIABILITY, nil }
+		return 0, nil
+	}
+
+	share, err := strconv.Atoi(shareStr)
+	if err != nil || share < 0 || share > FULL_LIABILITY {
+		return 0, errors.New("Invalid liability share: " + shareStr + ". Expecting true, false or a percentage")
+	}
+
+	return share, nil
 }
 
 //================================================================================================================================================
@@ -251,6 +397,54 @@ func NewGarageReport(Garage string, EstimateStr string,  WriteOffStr string, Not
 	return report, nil
 }
 
+//================================================================================================================================================
+//  NewGarageAssignment: creates a new ClaimDetailsGarageAssignment made in the current transaction
+//================================================================================================================================================
+func NewGarageAssignment(stub shim.ChaincodeStubInterface, Garage string, AssignedBy string, Reason string) (ClaimDetailsGarageAssignment) {
+
+	var assignment ClaimDetailsGarageAssignment
+
+	assignment.Garage     = Garage
+	assignment.AssignedBy = AssignedBy
+	assignment.Reason     = Reason
+	assignment.TxId       = stub.GetTxID()
+	assignment.Timestamp  = GetTxTimestamp(stub)
+
+	return assignment
+}
+
+//================================================================================================================================================
+//  NewRepairWorkOrder: creates a new, open RepairWorkOrder
+//================================================================================================================================================
+func NewRepairWorkOrder(Garage string, WorkOrderSeq string, Description string, EstimatedRepairCost int) (RepairWorkOrder) {
+
+	var workOrder RepairWorkOrder
+
+	workOrder.Garage              = Garage
+	workOrder.WorkOrderSeq        = WorkOrderSeq
+	workOrder.Description         = Description
+	workOrder.EstimatedRepairCost = EstimatedRepairCost
+	workOrder.WorkStatus          = STATUS_OPEN
+
+	return workOrder
+}
+
+//================================================================================================================================================
+//  NewClaimDetailsLiabilityDisputeSubmission: creates a new ClaimDetailsLiabilityDisputeSubmission
+//================================================================================================================================================
+func NewClaimDetailsLiabilityDisputeSubmission(Insurer string, ClaimId string, Evidence string,
+	ProposedShares map[string]int) (ClaimDetailsLiabilityDisputeSubmission) {
+
+	var submission ClaimDetailsLiabilityDisputeSubmission
+
+	submission.Insurer             = Insurer
+	submission.ClaimId             = ClaimId
+	submission.Evidence            = Evidence
+	submission.ProposedShares      = ProposedShares
+
+	return submission
+}
+
 //================================================================================================================================================
 //  NewClaimDetailsSettlementPayment: creates a new NewClaimDetailsSettlementPayment
 //================================================================================================================================================
@@ -0,0 +1,489 @@
+//go:build identityinjector && memorystorage
+// +build identityinjector,memorystorage
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+//Invokes the function in a new transaction, as a caller with the given username and role
+func invokeAs(cc *InsuranceChaincode, stub *testStub, username string, role string, function string, args ...string) (error) {
+	InjectRole(username, role)
+	stub.nextTx()
+
+	_, err := cc.Invoke(stub, function, args)
+
+	return err
+}
+
+//Gets the only claim made on the policy
+func claimOnPolicy(t *testing.T, stub *testStub, policyId string) (Claim) {
+	var found []Claim
+
+	for _, claim := range RetrieveAllClaims(stub) {
+		if claim.Relations.RelatedPolicy == policyId { found = append(found, claim) }
+	}
+
+	if len(found) != 1 { t.Fatalf("Expected one claim on policy %s, got %d", policyId, len(found)) }
+
+	return found[0]
+}
+
+func expectStatus(t *testing.T, stub *testStub, claimId string, status string) (Claim) {
+	claim, err := RetrieveClaim(stub, claimId)
+	if err != nil { t.Fatal(err) }
+
+	if claim.Details.Status != status { t.Fatalf("Expected claim %s to be %s, got: %s", claimId, status, claim.Details.Status) }
+
+	return claim
+}
+
+func TestPoliceReportRequiredBeforeGarageReport(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_MEMORY)
+
+	err := invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, "createClaim", "P1", "Hit a tree", "2017-01-01", SINGLE_PARTY, "true")
+	if err != nil { t.Fatal(err) }
+
+	claim := claimOnPolicy(t, stub, "P1")
+	expectStatus(t, stub, claim.Id, STATE_AWAITING_POLICE_REPORT)
+
+	err = invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, ACTION_ASSIGN_GARAGE, claim.Id, "garage1")
+	if err != nil { t.Fatal(err) }
+
+	err = invokeAs(cc, stub, "garage1", ROLE_GARAGE, ACTION_ADD_GARAGE_REPORT, claim.Id, "1000", "false", "Dented bumper", "BP08BRV")
+	if err == nil || !strings.HasPrefix(err.Error(), "INVALID_TRANSITION") { t.Fatalf("Expected the garage report to wait for the police report, got: %v", err) }
+
+	//Only the police, or an oracle on their behalf, can add the report
+	err = invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, ACTION_ADD_POLICE_REPORT, claim.Id, "Hit a tree", "1", "2", "BP08BRV")
+	if err == nil || !strings.HasPrefix(err.Error(), "NOT_PERMITTED") { t.Fatalf("Expected the claimant to be refused, got: %v", err) }
+
+	err = invokeAs(cc, stub, "police1", ROLE_POLICE, ACTION_ADD_POLICE_REPORT, claim.Id, "Hit a tree", "1.5", "52.4", "BP08BRV")
+	if err != nil { t.Fatal(err) }
+
+	claim = expectStatus(t, stub, claim.Id, STATE_AWAITING_GARAGE_REPORT)
+
+	report := claim.Details.PoliceReport
+	if report.Description != "Hit a tree" || !report.DriverAtFault || claim.Details.LiabilityShare != FULL_LIABILITY {
+		t.Fatalf("Unexpected police report or liability: %+v, %d", report, claim.Details.LiabilityShare)
+	}
+
+	//A claim only has one police report
+	err = invokeAs(cc, stub, "police1", ROLE_POLICE, ACTION_ADD_POLICE_REPORT, claim.Id, "Hit a wall", "1.5", "52.4", "")
+	if err == nil || !strings.HasPrefix(err.Error(), "INVALID_TRANSITION") { t.Fatalf("Expected a second police report to be refused, got: %v", err) }
+
+	err = invokeAs(cc, stub, "garage1", ROLE_GARAGE, ACTION_ADD_GARAGE_REPORT, claim.Id, "1000", "false", "Dented bumper", "BP08BRV")
+	if err != nil { t.Fatal(err) }
+}
+
+func TestPoliceReportSetsLiabilityOfLinkedClaims(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_MEMORY)
+
+	//The claimant accepts no liability, but the police report decides
+	err := invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, "createClaim", "P1", "Collision", "2017-01-01", MULTIPLE_PARTIES, "DZ14TYV", "false", "true")
+	if err != nil { t.Fatal(err) }
+
+	claim := expectStatus(t, stub, claimOnPolicy(t, stub, "P1").Id, STATE_AWAITING_POLICE_REPORT)
+	otherClaim := expectStatus(t, stub, claimOnPolicy(t, stub, "P2").Id, STATE_AWAITING_POLICE_REPORT)
+
+	//A report naming a vehicle that wasn't in the accident is refused, leaving the claims waiting
+	err = invokeAs(cc, stub, "police1", ROLE_POLICE, ACTION_ADD_POLICE_REPORT, claim.Id, "Collision", "1", "2", "XX99XXX")
+	if err == nil { t.Fatal("Expected a report naming an unknown vehicle to be refused") }
+
+	expectStatus(t, stub, claim.Id, STATE_AWAITING_POLICE_REPORT)
+
+	err = invokeAs(cc, stub, "police1", ROLE_POLICE, ACTION_ADD_POLICE_REPORT, otherClaim.Id, "Collision", "1", "2", "BP08BRV")
+	if err != nil { t.Fatal(err) }
+
+	claim = expectStatus(t, stub, claim.Id, STATE_AWAITING_GARAGE_REPORT)
+	otherClaim = expectStatus(t, stub, otherClaim.Id, STATE_AWAITING_GARAGE_REPORT)
+
+	if !claim.Details.PoliceReport.DriverAtFault || claim.Details.LiabilityShare != FULL_LIABILITY {
+		t.Fatalf("Expected claimant1 to be at fault: %+v", claim.Details)
+	}
+
+	if otherClaim.Details.PoliceReport.DriverAtFault || otherClaim.Details.LiabilityShare != 0 {
+		t.Fatalf("Expected claimant2 not to be at fault: %+v", otherClaim.Details)
+	}
+}
+
+//Creates a single party claim on policy P1 with the garage assigned, and adds the garage's report with the estimate.  No oracle
+//host is set, so the default vehicle value of 5555 is used straight away.
+func reportOnSinglePartyClaim(t *testing.T, cc *InsuranceChaincode, stub *testStub, garage string, estimate string) (Claim) {
+	err := invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, "createClaim", "P1", "Hit a tree", "2017-01-01", SINGLE_PARTY)
+	if err != nil { t.Fatal(err) }
+
+	var claim Claim
+	for _, aClaim := range RetrieveAllClaims(stub) {
+		if aClaim.Details.Status == STATE_AWAITING_GARAGE_REPORT { claim = aClaim }
+	}
+
+	err = invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, ACTION_ASSIGN_GARAGE, claim.Id, garage)
+	if err != nil { t.Fatal(err) }
+
+	err = invokeAs(cc, stub, garage, ROLE_GARAGE, ACTION_ADD_GARAGE_REPORT, claim.Id, estimate, "false", "Dented bumper", "BP08BRV")
+	if err != nil { t.Fatal(err) }
+
+	claim, _ = RetrieveClaim(stub, claim.Id)
+
+	return claim
+}
+
+func TestRepairPathThroughToClosure(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_MEMORY)
+
+	claim := reportOnSinglePartyClaim(t, cc, stub, "garage1", "1000")
+	claim = expectStatus(t, stub, claim.Id, STATE_ORDER_GARAGE_WORK)
+
+	repair := claim.Details.Repair
+	if claim.Details.Settlement.Decision != REPAIR || repair.Garage != "garage1" || repair.WorkOrderSeq != "1" || repair.EstimatedRepairCost != 1000 {
+		t.Fatalf("Unexpected work order: %+v", repair)
+	}
+
+	err := invokeAs(cc, stub, "insurer1", ROLE_INSURER, ACTION_ORDER_GARAGE_WORK, claim.Id, "01/02/2017")
+	if err == nil { t.Fatal("Expected a start date that isn't yyyy-mm-dd to be refused") }
+
+	err = invokeAs(cc, stub, "insurer1", ROLE_INSURER, ACTION_ORDER_GARAGE_WORK, claim.Id, "2017-02-01")
+	if err != nil { t.Fatal(err) }
+
+	claim = expectStatus(t, stub, claim.Id, STATE_AWAITING_GARAGE_WORK_CONFIRMATION)
+	if claim.Details.Repair.StartDate != "2017-02-01" || claim.Details.Repair.WorkStatus != STATE_IN_PROGRESS { t.Fatalf("Unexpected work order: %+v", claim.Details.Repair) }
+
+	//Only the garage carrying out the repair confirms it, with a cost that isn't negative
+	err = invokeAs(cc, stub, "garage2", ROLE_GARAGE, ACTION_CONFIRM_WORK, claim.Id, "900", "2017-02-10")
+	if err == nil { t.Fatal("Expected another garage to be refused") }
+
+	err = invokeAs(cc, stub, "garage1", ROLE_GARAGE, ACTION_CONFIRM_WORK, claim.Id, "-900", "2017-02-10")
+	if err == nil { t.Fatal("Expected a negative repair cost to be refused") }
+
+	err = invokeAs(cc, stub, "garage1", ROLE_GARAGE, ACTION_CONFIRM_WORK, claim.Id, "900", "2017-02-10")
+	if err != nil { t.Fatal(err) }
+
+	if _, found := stub.events[EVENT_TYPE_CLAIM_SETTLED]; !found { t.Fatal("No claim settled event was emitted") }
+
+	claim = expectStatus(t, stub, claim.Id, STATE_SETTLED)
+
+	//The insurer pays the garage, less the excess of 300 on P1
+	payments := claim.Details.Settlement.Payments
+	if len(payments) != 1 || payments[0].RecipientType != PAYMENT_TYPE_GARAGE || payments[0].Recipient != "garage1" ||
+		payments[0].Sender != "insurer1" || payments[0].Amount != 600 || payments[0].Status != STATE_NOT_PAID {
+		t.Fatalf("Unexpected payments: %+v", payments)
+	}
+
+	err = invokeAs(cc, stub, "insurer1", ROLE_INSURER, ACTION_CLOSE_CLAIM, claim.Id)
+	if err == nil { t.Fatal("Expected the claim to stay open until the garage is paid") }
+
+	err = invokeAs(cc, stub, "insurer1", ROLE_INSURER, ACTION_CONFIRM_PAID_OUT, claim.Id, payments[0].Id)
+	if err != nil { t.Fatal(err) }
+
+	err = invokeAs(cc, stub, "insurer1", ROLE_INSURER, ACTION_CLOSE_CLAIM, claim.Id)
+	if err != nil { t.Fatal(err) }
+
+	expectStatus(t, stub, claim.Id, STATUS_CLOSED)
+}
+
+func TestWorkOrdersAreSequencedPerGarage(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_MEMORY)
+
+	for _, expected := range []struct{ garage, seq string }{{"garage1", "1"}, {"garage1", "2"}, {"garage2", "1"}, {"garage1", "3"}} {
+		claim := reportOnSinglePartyClaim(t, cc, stub, expected.garage, "1000")
+
+		if claim.Details.Repair.Garage != expected.garage || claim.Details.Repair.WorkOrderSeq != expected.seq {
+			t.Fatalf("Expected work order %s for %s, got: %+v", expected.seq, expected.garage, claim.Details.Repair)
+		}
+	}
+}
+
+//Creates a claim by claimant1 on P1 with claimant2's vehicle as the other party, where claimant1 takes the liability share
+func createTwoPartyClaims(t *testing.T, cc *InsuranceChaincode, stub *testStub, liable string) (Claim, Claim) {
+	err := invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, "createClaim", "P1", "Collision", "2017-01-01", MULTIPLE_PARTIES, "DZ14TYV", liable)
+	if err != nil { t.Fatal(err) }
+
+	return claimOnPolicy(t, stub, "P1"), claimOnPolicy(t, stub, "P2")
+}
+
+func liabilitySharesJSON(t *testing.T, shares map[string]int) (string) {
+	bytes, err := marshall(shares)
+	if err != nil { t.Fatal(err) }
+
+	return string(bytes)
+}
+
+func TestDisputedLiabilityResolvedBySuperuser(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_MEMORY)
+
+	claim, otherClaim := createTwoPartyClaims(t, cc, stub, "false")
+	expectStatus(t, stub, otherClaim.Id, STATE_AWAITING_LIABILITY_ACCEPTANCE)
+
+	//Only the other party's claimant can dispute the liability they were given
+	err := invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, ACTION_DECLARE_LIABILITY, otherClaim.Id, "false")
+	if err == nil { t.Fatal("Expected claimant1 to be refused") }
+
+	err = invokeAs(cc, stub, "claimant2", ROLE_POLICY_HOLDER, ACTION_DECLARE_LIABILITY, otherClaim.Id, "false")
+	if err != nil { t.Fatal(err) }
+
+	expectStatus(t, stub, claim.Id, STATE_LIABILITY_DISPUTED)
+	expectStatus(t, stub, otherClaim.Id, STATE_LIABILITY_DISPUTED)
+
+	//The insurers disagree
+	err = invokeAs(cc, stub, "insurer1", ROLE_INSURER, ACTION_SUBMIT_LIABILITY_EVIDENCE, claim.Id, "Dashcam footage",
+		liabilitySharesJSON(t, map[string]int{claim.Id: 0, otherClaim.Id: 100}))
+	if err != nil { t.Fatal(err) }
+
+	err = invokeAs(cc, stub, "insurer2", ROLE_INSURER, ACTION_SUBMIT_LIABILITY_EVIDENCE, otherClaim.Id, "Witness statement",
+		liabilitySharesJSON(t, map[string]int{claim.Id: 50, otherClaim.Id: 50}))
+	if err != nil { t.Fatal(err) }
+
+	claim = expectStatus(t, stub, claim.Id, STATE_LIABILITY_DISPUTED)
+	if len(claim.Details.LiabilityDispute.Submissions) != 2 { t.Fatalf("Expected both submissions, got: %+v", claim.Details.LiabilityDispute) }
+
+	//Shares that don't add up to 100% are refused
+	err = invokeAs(cc, stub, "admin", ROLE_SUPER_USER, ACTION_RESOLVE_LIABILITY_DISPUTE, claim.Id,
+		liabilitySharesJSON(t, map[string]int{claim.Id: 30, otherClaim.Id: 60}))
+	if err == nil { t.Fatal("Expected shares adding up to 90% to be refused") }
+
+	err = invokeAs(cc, stub, "admin", ROLE_SUPER_USER, ACTION_RESOLVE_LIABILITY_DISPUTE, claim.Id,
+		liabilitySharesJSON(t, map[string]int{claim.Id: 30, otherClaim.Id: 70}))
+	if err != nil { t.Fatal(err) }
+
+	claim = expectStatus(t, stub, claim.Id, STATE_AWAITING_GARAGE_REPORT)
+	otherClaim = expectStatus(t, stub, otherClaim.Id, STATE_AWAITING_GARAGE_REPORT)
+
+	if claim.Details.LiabilityShare != 30 || otherClaim.Details.LiabilityShare != 70 {
+		t.Fatalf("Unexpected liability shares: %d, %d", claim.Details.LiabilityShare, otherClaim.Details.LiabilityShare)
+	}
+}
+
+func TestDisputedLiabilityResolvedWhenInsurersAgree(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_MEMORY)
+
+	claim, otherClaim := createTwoPartyClaims(t, cc, stub, "false")
+
+	err := invokeAs(cc, stub, "claimant2", ROLE_POLICY_HOLDER, ACTION_DECLARE_LIABILITY, otherClaim.Id, "false")
+	if err != nil { t.Fatal(err) }
+
+	agreed := liabilitySharesJSON(t, map[string]int{claim.Id: 40, otherClaim.Id: 60})
+
+	//An insurer can only submit evidence for the claim they insure
+	err = invokeAs(cc, stub, "insurer1", ROLE_INSURER, ACTION_SUBMIT_LIABILITY_EVIDENCE, otherClaim.Id, "Dashcam footage", agreed)
+	if err == nil { t.Fatal("Expected insurer1 to be refused evidence for claimant2's claim") }
+
+	err = invokeAs(cc, stub, "insurer1", ROLE_INSURER, ACTION_SUBMIT_LIABILITY_EVIDENCE, claim.Id, "Dashcam footage", agreed)
+	if err != nil { t.Fatal(err) }
+
+	expectStatus(t, stub, claim.Id, STATE_LIABILITY_DISPUTED)
+
+	err = invokeAs(cc, stub, "insurer2", ROLE_INSURER, ACTION_SUBMIT_LIABILITY_EVIDENCE, otherClaim.Id, "Witness statement", agreed)
+	if err != nil { t.Fatal(err) }
+
+	claim = expectStatus(t, stub, claim.Id, STATE_AWAITING_GARAGE_REPORT)
+	otherClaim = expectStatus(t, stub, otherClaim.Id, STATE_AWAITING_GARAGE_REPORT)
+
+	if claim.Details.LiabilityShare != 40 || otherClaim.Details.LiabilityShare != 60 {
+		t.Fatalf("Unexpected liability shares: %d, %d", claim.Details.LiabilityShare, otherClaim.Details.LiabilityShare)
+	}
+}
+
+//Assigns garage1 to the claim and adds a report that writes the vehicle off, so the claim waits for the claimant to agree the payout
+func reportTotalLoss(t *testing.T, cc *InsuranceChaincode, stub *testStub, claim Claim, owner string, registration string) {
+	err := invokeAs(cc, stub, owner, ROLE_POLICY_HOLDER, ACTION_ASSIGN_GARAGE, claim.Id, "garage1")
+	if err != nil { t.Fatal(err) }
+
+	err = invokeAs(cc, stub, "garage1", ROLE_GARAGE, ACTION_ADD_GARAGE_REPORT, claim.Id, "5000", "true", "Written off", registration)
+	if err != nil { t.Fatal(err) }
+
+	expectStatus(t, stub, claim.Id, STATE_AWAITING_CLAIMANT_CONFIRMATION)
+}
+
+func findPayment(t *testing.T, claim Claim, senderType string, sender string) (ClaimDetailsSettlementPayment) {
+	for _, payment := range claim.Details.Settlement.Payments {
+		if payment.SenderType == senderType && payment.Sender == sender { return payment }
+	}
+
+	t.Fatalf("No payment from %s %s on claim %s: %+v", senderType, sender, claim.Id, claim.Details.Settlement.Payments)
+	return ClaimDetailsSettlementPayment{}
+}
+
+func TestSplitLiabilitySharesPayments(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_MEMORY)
+
+	err := invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, "createClaim", "P1", "Collision", "2017-01-01", MULTIPLE_PARTIES, "DZ14TYV", "150")
+	if err == nil { t.Fatal("Expected a liability share over 100% to be refused") }
+
+	claim, otherClaim := createTwoPartyClaims(t, cc, stub, "30")
+
+	if claim.Details.LiabilityShare != 30 || otherClaim.Details.LiabilityShare != 70 {
+		t.Fatalf("Unexpected liability shares: %d, %d", claim.Details.LiabilityShare, otherClaim.Details.LiabilityShare)
+	}
+
+	err = invokeAs(cc, stub, "claimant2", ROLE_POLICY_HOLDER, ACTION_DECLARE_LIABILITY, otherClaim.Id, "true")
+	if err != nil { t.Fatal(err) }
+
+	expectStatus(t, stub, otherClaim.Id, STATE_AWAITING_GARAGE_REPORT)
+
+	reportTotalLoss(t, cc, stub, claim, "claimant1", "BP08BRV")
+
+	err = invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, ACTION_AGREE_PAYOUT_AMOUNT, claim.Id, "true")
+	if err != nil { t.Fatal(err) }
+
+	claim = expectStatus(t, stub, claim.Id, STATE_SETTLED)
+
+	//The claimant only pays 30% of the excess of 300, and insurer2 pays 70% of the settlement to insurer1
+	payout := findPayment(t, claim, PAYMENT_TYPE_INSURER, "insurer1")
+	if payout.RecipientType != PAYMENT_TYPE_CLAIMANT || payout.Amount != 5555 - 90 { t.Fatalf("Unexpected payout: %+v", payout) }
+
+	recovery := findPayment(t, claim, PAYMENT_TYPE_INSURER, "insurer2")
+	if recovery.Recipient != "insurer1" || recovery.Amount != 5555 * 70 / 100 || recovery.LinkedClaim != otherClaim.Id {
+		t.Fatalf("Unexpected recovery payment: %+v", recovery)
+	}
+
+	//The recovery payment is double entered on the liable claim, and paying it updates both entries
+	err = invokeAs(cc, stub, "insurer2", ROLE_INSURER, ACTION_CONFIRM_PAID_OUT, claim.Id, recovery.Id)
+	if err != nil { t.Fatal(err) }
+
+	otherClaim, _ = RetrieveClaim(stub, otherClaim.Id)
+
+	linked, err := otherClaim.GetPayment(recovery.LinkedPayment)
+	if err != nil { t.Fatal(err) }
+
+	if linked.Amount != recovery.Amount || linked.Status != STATE_PAID { t.Fatalf("Unexpected linked payment: %+v", linked) }
+}
+
+//Adds policy P3 (claimant3, insurer3) on vehicle AB12CDE to the reference data
+func addThirdPolicy(t *testing.T, stub *testStub) {
+	stub.nextTx()
+	OpenTxContext(stub, "test", "insurer3", ROLE_INSURER)
+	defer CloseTxContext(stub)
+
+	_, err := SaveVehicle(stub, NewVehicle("AB12CDE", "Vauxhall", "Corsa", "2012", 40000, "100512331"))
+	if err != nil { t.Fatal(err) }
+
+	_, err = SavePolicy(stub, NewPolicy("P3", "claimant3", "insurer3", "01/01/17", "31/12/17", 200, "AB12CDE"))
+	if err != nil { t.Fatal(err) }
+}
+
+func TestClaimWithMoreThanTwoParties(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_MEMORY)
+	addThirdPolicy(t, stub)
+
+	//Nothing is saved for a claim with a repeated registration
+	err := invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, "createClaim", "P1", "Pile-up", "2017-01-01", MULTIPLE_PARTIES, "DZ14TYV, DZ14TYV", "false")
+	if err == nil { t.Fatal("Expected a repeated registration to be refused") }
+
+	if saved := RetrieveAllClaims(stub); len(saved) != 0 { t.Fatalf("Claims were saved for a refused claim: %+v", saved) }
+
+	err = invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, "createClaim", "P1", "Pile-up", "2017-01-01", MULTIPLE_PARTIES, "DZ14TYV, AB12CDE", "false")
+	if err != nil { t.Fatal(err) }
+
+	parties := []Claim{claimOnPolicy(t, stub, "P1"), claimOnPolicy(t, stub, "P2"), claimOnPolicy(t, stub, "P3")}
+
+	//Every claim is linked to the others, and the other parties share the liability
+	for index, party := range parties {
+		expectStatus(t, stub, party.Id, STATE_AWAITING_LIABILITY_ACCEPTANCE)
+
+		if len(party.Relations.LinkedClaims) != 2 { t.Fatalf("Unexpected linked claims of %s: %q", party.Id, party.Relations.LinkedClaims) }
+
+		for _, other := range parties {
+			if other.Id != party.Id && indexOf(party.Relations.LinkedClaims, other.Id) < 0 { t.Fatalf("%s isn't linked to %s", party.Id, other.Id) }
+		}
+
+		if expected := []int{0, 50, 50}[index]; party.Details.LiabilityShare != expected {
+			t.Fatalf("Expected a liability share of %d for %s, got: %d", expected, party.Id, party.Details.LiabilityShare)
+		}
+	}
+
+	//The claims wait until every liable party has accepted
+	err = invokeAs(cc, stub, "claimant2", ROLE_POLICY_HOLDER, ACTION_DECLARE_LIABILITY, parties[1].Id, "true")
+	if err != nil { t.Fatal(err) }
+
+	expectStatus(t, stub, parties[0].Id, STATE_AWAITING_LIABILITY_ACCEPTANCE)
+
+	err = invokeAs(cc, stub, "claimant3", ROLE_POLICY_HOLDER, ACTION_DECLARE_LIABILITY, parties[2].Id, "true")
+	if err != nil { t.Fatal(err) }
+
+	for _, party := range parties {
+		expectStatus(t, stub, party.Id, STATE_AWAITING_GARAGE_REPORT)
+	}
+
+	reportTotalLoss(t, cc, stub, parties[0], "claimant1", "BP08BRV")
+
+	err = invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, ACTION_AGREE_PAYOUT_AMOUNT, parties[0].Id, "true")
+	if err != nil { t.Fatal(err) }
+
+	var event ClaimSettledEvent
+
+	err = unmarshal(stub.events[EVENT_TYPE_CLAIM_SETTLED], &event)
+	if err != nil { t.Fatal(err) }
+
+	if event.ClaimId != parties[0].Id || len(event.LinkedClaimIds) != 2 { t.Fatalf("Unexpected claim settled event: %+v", event) }
+
+	//Each liable party's insurer pays their share
+	claim, _ := RetrieveClaim(stub, parties[0].Id)
+
+	for _, insurer := range []string{"insurer2", "insurer3"} {
+		if recovery := findPayment(t, claim, PAYMENT_TYPE_INSURER, insurer); recovery.Amount != 5555 / 2 {
+			t.Fatalf("Unexpected recovery payment: %+v", recovery)
+		}
+	}
+}
+
+func TestClaimWithThirdPartiesNotOnTheLedger(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_MEMORY)
+
+	err := invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, "createClaim", "P1", "Collision", "2017-01-01", MULTIPLE_PARTIES, "XX99XXX, " + UNKNOWN_REGISTRATION, "false")
+	if err != nil { t.Fatal(err) }
+
+	//No other party on the ledger needs to accept liability
+	claim := expectStatus(t, stub, claimOnPolicy(t, stub, "P1").Id, STATE_AWAITING_GARAGE_REPORT)
+
+	if len(claim.Relations.LinkedClaims) != 0 || len(claim.Relations.ThirdParties) != 2 { t.Fatalf("Unexpected relations: %+v", claim.Relations) }
+
+	uninsured, err := RetrieveThirdParty(stub, claim.Relations.ThirdParties[0])
+	if err != nil { t.Fatal(err) }
+
+	hitAndRun, err := RetrieveThirdParty(stub, claim.Relations.ThirdParties[1])
+	if err != nil { t.Fatal(err) }
+
+	if uninsured.Details.Registration != "XX99XXX" || uninsured.Details.Category != THIRD_PARTY_UNINSURED || uninsured.Details.LiabilityShare != 50 {
+		t.Fatalf("Unexpected third party: %+v", uninsured)
+	}
+
+	if hitAndRun.Details.Category != THIRD_PARTY_HIT_AND_RUN || hitAndRun.Details.LiabilityShare != 50 { t.Fatalf("Unexpected third party: %+v", hitAndRun) }
+
+	//Only an insurer of a linked claim can say who insures the third party
+	err = invokeAs(cc, stub, "insurer2", ROLE_INSURER, "updateThirdParty", uninsured.Id, THIRD_PARTY_OTHER_INSURER, "Acme Insurance")
+	if err == nil { t.Fatal("Expected insurer2 to be refused") }
+
+	err = invokeAs(cc, stub, "insurer1", ROLE_INSURER, "updateThirdParty", uninsured.Id, THIRD_PARTY_OTHER_INSURER, "")
+	if err == nil { t.Fatal("Expected an external insurer to be required") }
+
+	err = invokeAs(cc, stub, "insurer1", ROLE_INSURER, "updateThirdParty", uninsured.Id, THIRD_PARTY_OTHER_INSURER, "Acme Insurance")
+	if err != nil { t.Fatal(err) }
+
+	reportTotalLoss(t, cc, stub, claim, "claimant1", "BP08BRV")
+
+	err = invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, ACTION_AGREE_PAYOUT_AMOUNT, claim.Id, "true")
+	if err != nil { t.Fatal(err) }
+
+	claim = expectStatus(t, stub, claim.Id, STATE_SETTLED)
+
+	//Recovery payments are requested from the external insurer and the hit and run driver
+	fromInsurer := findPayment(t, claim, PAYMENT_TYPE_THIRDPARTY, "Acme Insurance")
+	fromDriver := findPayment(t, claim, PAYMENT_TYPE_THIRDPARTY, hitAndRun.Id)
+
+	if fromInsurer.Amount != 5555 / 2 || fromDriver.Amount != 5555 / 2 || fromInsurer.Recipient != "insurer1" {
+		t.Fatalf("Unexpected recovery payments: %+v, %+v", fromInsurer, fromDriver)
+	}
+
+	//The receiving insurer confirms that a third party has paid
+	err = invokeAs(cc, stub, "insurer2", ROLE_INSURER, ACTION_CONFIRM_PAID_OUT, claim.Id, fromInsurer.Id)
+	if err == nil { t.Fatal("Expected insurer2 to be refused") }
+
+	err = invokeAs(cc, stub, "insurer1", ROLE_INSURER, ACTION_CONFIRM_PAID_OUT, claim.Id, fromInsurer.Id)
+	if err != nil { t.Fatal(err) }
+
+	claim, _ = RetrieveClaim(stub, claim.Id)
+
+	if payment, _ := claim.GetPayment(fromInsurer.Id); payment.Status != STATE_PAID { t.Fatalf("Unexpected payment: %+v", payment) }
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	ClaimHistory - Defines the structure for a ClaimHistory object.
+//		Holds an entry for every change saved to a claim. Stored separately to the claim to keep the claim small.
+//==============================================================================================================================
+type ClaimHistory struct {
+	ClaimId		string					`json:"claimId"`
+	Type		string					`json:"type"`
+	Entries		[]ClaimHistoryEntry		`json:"entries"`
+}
+
+//==============================================================================================================================
+//	ClaimHistoryEntry - Defines the structure for a ClaimHistoryEntry object.
+//==============================================================================================================================
+type ClaimHistoryEntry struct {
+	PreviousStatus		string		`json:"previousStatus"`
+	NewStatus			string		`json:"newStatus"`
+	Function			string		`json:"function"`
+	Caller				string		`json:"caller"`
+	CallerAffiliation	string		`json:"callerAffiliation"`
+	TxId				string		`json:"txId"`
+	Timestamp			string		`json:"timestamp"`
+}
+
+//=================================================================================================================================
+//	 NewClaimHistory	-	Constructs a new, empty claim history
+//=================================================================================================================================
+func NewClaimHistory(claimId string) (ClaimHistory) {
+	var history ClaimHistory
+
+	history.ClaimId = claimId
+	history.Type = "claimHistory"
+	history.Entries = []ClaimHistoryEntry{}
+
+	return history
+}
+
+//=================================================================================================================================
+//	 NewClaimHistoryEntry	-	Constructs a history entry for a claim being saved within the current transaction
+//=================================================================================================================================
+func NewClaimHistoryEntry(stub shim.ChaincodeStubInterface, previousStatus string, newStatus string) (ClaimHistoryEntry) {
+	var entry ClaimHistoryEntry
+
+	context := GetTxContext(stub)
+
+	entry.PreviousStatus = previousStatus
+	entry.NewStatus = newStatus
+	entry.Function = context.Function
+	entry.Caller = context.Caller
+	entry.CallerAffiliation = context.CallerAffiliation
+	entry.TxId = stub.GetTxID()
+
+	entry.Timestamp = GetTxTimestamp(stub)
+
+	return entry
+}
+
+//=================================================================================================================================
+//	 CurrentStatus	-	The status the claim was last saved with, or empty if the claim has never been saved
+//=================================================================================================================================
+func (t *ClaimHistory) CurrentStatus() (string) {
+	if len(t.Entries) == 0 { return "" }
+
+	return t.Entries[len(t.Entries) - 1].NewStatus
+}
+
+//=================================================================================================================================
+//	 AddEntry	-	Appends an entry to the claim history
+//=================================================================================================================================
+func (t *ClaimHistory) AddEntry(entry ClaimHistoryEntry) {
+	t.Entries = append(t.Entries, entry)
+}
@@ -0,0 +1,90 @@
+//go:build identityinjector && memorystorage
+// +build identityinjector,memorystorage
+
+package main
+
+import (
+	"testing"
+)
+
+func TestClaimHistoryRecordsEveryTransition(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_MEMORY)
+
+	err := invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, "createClaim", "P1", "Hit a tree", "2017-01-01", SINGLE_PARTY)
+	if err != nil { t.Fatal(err) }
+
+	createTxId := stub.txId
+	claimId := claimOnPolicy(t, stub, "P1").Id
+
+	err = invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, ACTION_ASSIGN_GARAGE, claimId, "garage1")
+	if err != nil { t.Fatal(err) }
+
+	assignTxId := stub.txId
+
+	//The report and the repair decision are saved in the same transaction
+	err = invokeAs(cc, stub, "garage1", ROLE_GARAGE, ACTION_ADD_GARAGE_REPORT, claimId, "1000", "false", "Dented bumper", "BP08BRV")
+	if err != nil { t.Fatal(err) }
+
+	reportTxId := stub.txId
+
+	InjectRole("claimant1", ROLE_POLICY_HOLDER)
+
+	bytes, err := cc.Query(stub, "retrieveClaimHistory", []string{claimId})
+	if err != nil { t.Fatal(err) }
+
+	var history ClaimHistory
+
+	err = unmarshal(bytes, &history)
+	if err != nil { t.Fatal(err) }
+
+	expected := []ClaimHistoryEntry{
+		{"",								STATE_AWAITING_GARAGE_REPORT,			"createClaim",				"claimant1",	ROLE_POLICY_HOLDER,	createTxId,		""},
+		{STATE_AWAITING_GARAGE_REPORT,		STATE_AWAITING_GARAGE_REPORT,			ACTION_ASSIGN_GARAGE,		"claimant1",	ROLE_POLICY_HOLDER,	assignTxId,		""},
+		{STATE_AWAITING_GARAGE_REPORT,		STATE_PENDING_AFTER_REPORT_DECISION,	ACTION_ADD_GARAGE_REPORT,	"garage1",		ROLE_GARAGE,		reportTxId,		""},
+		{STATE_PENDING_AFTER_REPORT_DECISION,	STATE_ORDER_GARAGE_WORK,			ACTION_ADD_GARAGE_REPORT,	"garage1",		ROLE_GARAGE,		reportTxId,		""},
+	}
+
+	if history.ClaimId != claimId || len(history.Entries) != len(expected) { t.Fatalf("Unexpected history: %+v", history) }
+
+	for index, entry := range history.Entries {
+		if entry.Timestamp == "" { t.Fatalf("Entry %d has no timestamp: %+v", index, entry) }
+
+		entry.Timestamp = ""
+		if entry != expected[index] { t.Fatalf("Expected entry %d to be %+v, got: %+v", index, expected[index], entry) }
+	}
+
+	if history.Entries[0].Timestamp == history.Entries[1].Timestamp { t.Fatal("Entries from different transactions have the same timestamp") }
+}
+
+func TestClaimHistoryIsOnlyVisibleToClaimParties(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_MEMORY)
+
+	err := invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, "createClaim", "P1", "Hit a tree", "2017-01-01", SINGLE_PARTY)
+	if err != nil { t.Fatal(err) }
+
+	claimId := claimOnPolicy(t, stub, "P1").Id
+
+	err = invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, ACTION_ASSIGN_GARAGE, claimId, "garage1")
+	if err != nil { t.Fatal(err) }
+
+	tests := []struct {
+		username	string
+		role		string
+		visible		bool
+	}{
+		{"claimant1",	ROLE_POLICY_HOLDER,	true},
+		{"insurer1",	ROLE_INSURER,		true},
+		{"garage1",		ROLE_GARAGE,		true},
+		{"admin",		ROLE_SUPER_USER,	true},
+		{"claimant2",	ROLE_POLICY_HOLDER,	false},
+		{"insurer2",	ROLE_INSURER,		false},
+		{"garage2",		ROLE_GARAGE,		false},
+	}
+
+	for _, test := range tests {
+		InjectRole(test.username, test.role)
+
+		_, err := cc.Query(stub, "retrieveClaimHistory", []string{claimId})
+		if (err == nil) != test.visible { t.Fatalf("Expected the history to be visible to %s: %t, got: %v", test.username, test.visible, err) }
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+//==============================================================================================================================
+//	ClaimTransition - Defines a single transition of the claim state machine.
+//		An action can only be performed on a claim in the given state.  Who can perform it is decided by the permissions
+//		table on the ledger, which the default roles are only used to seed.
+//		Some actions have more than one outcome (e.g. a total loss or a repair), so every possible next state is listed.
+//==============================================================================================================================
+type ClaimTransition struct {
+	State			string		`json:"state"`
+	Action			string		`json:"action"`
+	DefaultRoles	[]string	`json:"defaultRoles"`
+	NextStates		[]string	`json:"nextStates"`
+}
+
+//==============================================================================================================================
+//	 Claim actions - These match the names of the invoke functions that perform them
+//==============================================================================================================================
+const   ACTION_ADD_POLICE_REPORT			= "addPoliceReport"
+const   ACTION_ASSIGN_GARAGE				= "assignGarage"
+const   ACTION_DECLARE_LIABILITY			= "declareLiability"
+const   ACTION_SUBMIT_LIABILITY_EVIDENCE	= "submitLiabilityEvidence"
+const   ACTION_RESOLVE_LIABILITY_DISPUTE	= "resolveLiabilityDispute"
+const   ACTION_ADD_GARAGE_REPORT			= "addGarageReport"
+const   ACTION_VEHICLE_VALUE_OBTAINED		= "vehicleValueOracleCallback"
+const   ACTION_ORDER_GARAGE_WORK			= "orderGarageWork"
+const   ACTION_CONFIRM_WORK					= "confirmWork"
+const   ACTION_AGREE_PAYOUT_AMOUNT			= "agreePayoutAmount"
+const   ACTION_CONFIRM_PAID_OUT				= "confirmPaidOut"
+const   ACTION_CLOSE_CLAIM					= "closeClaim"
+
+//==============================================================================================================================
+//	 CLAIM_TRANSITIONS - The claim state machine.  Every change to the status of an existing claim must be listed here.
+//==============================================================================================================================
+var CLAIM_TRANSITIONS = []ClaimTransition{
+	//A garage can be assigned, or the claim reassigned to another garage, until the garage report has been added
+	{STATE_AWAITING_POLICE_REPORT, ACTION_ASSIGN_GARAGE,
+		[]string{ROLE_POLICY_HOLDER, ROLE_INSURER, ROLE_SUPER_USER},
+		[]string{STATE_AWAITING_POLICE_REPORT}},
+
+	{STATE_AWAITING_LIABILITY_ACCEPTANCE, ACTION_ASSIGN_GARAGE,
+		[]string{ROLE_POLICY_HOLDER, ROLE_INSURER, ROLE_SUPER_USER},
+		[]string{STATE_AWAITING_LIABILITY_ACCEPTANCE}},
+
+	{STATE_LIABILITY_DISPUTED, ACTION_ASSIGN_GARAGE,
+		[]string{ROLE_POLICY_HOLDER, ROLE_INSURER, ROLE_SUPER_USER},
+		[]string{STATE_LIABILITY_DISPUTED}},
+
+	{STATE_AWAITING_GARAGE_REPORT, ACTION_ASSIGN_GARAGE,
+		[]string{ROLE_POLICY_HOLDER, ROLE_INSURER, ROLE_SUPER_USER},
+		[]string{STATE_AWAITING_GARAGE_REPORT}},
+
+	{STATE_AWAITING_POLICE_REPORT, ACTION_ADD_POLICE_REPORT,
+		[]string{ROLE_POLICE, ROLE_ORACLE},
+		[]string{STATE_AWAITING_GARAGE_REPORT}},
+
+	{STATE_AWAITING_LIABILITY_ACCEPTANCE, ACTION_DECLARE_LIABILITY,
+		[]string{ROLE_POLICY_HOLDER},
+		[]string{STATE_AWAITING_LIABILITY_ACCEPTANCE, STATE_AWAITING_GARAGE_REPORT, STATE_LIABILITY_DISPUTED}},
+
+	{STATE_LIABILITY_DISPUTED, ACTION_SUBMIT_LIABILITY_EVIDENCE,
+		[]string{ROLE_INSURER},
+		[]string{STATE_LIABILITY_DISPUTED, STATE_AWAITING_GARAGE_REPORT}},
+
+	{STATE_LIABILITY_DISPUTED, ACTION_RESOLVE_LIABILITY_DISPUTE,
+		[]string{ROLE_SUPER_USER},
+		[]string{STATE_AWAITING_GARAGE_REPORT}},
+
+	{STATE_AWAITING_GARAGE_REPORT, ACTION_ADD_GARAGE_REPORT,
+		[]string{ROLE_GARAGE, ROLE_SUPER_USER},
+		[]string{STATE_PENDING_AFTER_REPORT_DECISION}},
+
+	{STATE_PENDING_AFTER_REPORT_DECISION, ACTION_VEHICLE_VALUE_OBTAINED,
+		[]string{ROLE_ORACLE},
+		[]string{STATE_AWAITING_CLAIMANT_CONFIRMATION, STATE_ORDER_GARAGE_WORK}},
+
+	{STATE_ORDER_GARAGE_WORK, ACTION_ORDER_GARAGE_WORK,
+		[]string{ROLE_INSURER},
+		[]string{STATE_AWAITING_GARAGE_WORK_CONFIRMATION}},
+
+	{STATE_AWAITING_GARAGE_WORK_CONFIRMATION, ACTION_CONFIRM_WORK,
+		[]string{ROLE_GARAGE},
+		[]string{STATE_SETTLED}},
+
+	{STATE_AWAITING_CLAIMANT_CONFIRMATION, ACTION_AGREE_PAYOUT_AMOUNT,
+		[]string{ROLE_POLICY_HOLDER},
+		[]string{STATE_SETTLED, STATE_AWAITING_CLAIMANT_CONFIRMATION}},
+
+	{STATE_SETTLED, ACTION_CONFIRM_PAID_OUT,
+		[]string{ROLE_INSURER},
+		[]string{STATE_SETTLED}},
+
+	{STATE_SETTLED, ACTION_CLOSE_CLAIM,
+		[]string{ROLE_INSURER},
+		[]string{STATUS_CLOSED}},
+}
+
+//=================================================================================================================================
+//	 FindClaimTransition	-	Finds the transition for performing an action on a claim in the given state
+//=================================================================================================================================
+func FindClaimTransition(state string, action string) (ClaimTransition, bool) {
+	for _, transition := range CLAIM_TRANSITIONS {
+		if transition.State == state && transition.Action == action { return transition, true }
+	}
+
+	return ClaimTransition{}, false
+}
+
+//=================================================================================================================================
+//	 CheckClaimTransition	-	Checks that the action can be performed on the claim in its current state.  The caller's role
+//								has already been checked against the permissions table.
+//=================================================================================================================================
+func CheckClaimTransition(claim Claim, action string) (error) {
+	_, found := FindClaimTransition(claim.Details.Status, action)
+
+	if !found {
+		fmt.Println("INVALID_TRANSITION: " + action + " is not allowed for claim " + claim.Id + " in state " + claim.Details.Status)
+		return errors.New("INVALID_TRANSITION: " + action + " is not allowed for claim " + claim.Id + " in state " + claim.Details.Status)
+	}
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 GetClaimTransitions	-	Gets the transitions out of the claim's current state
+//=================================================================================================================================
+func GetClaimTransitions(claim Claim) ([]ClaimTransition) {
+	transitions := []ClaimTransition{}
+
+	for _, transition := range CLAIM_TRANSITIONS {
+		if transition.State == claim.Details.Status { transitions = append(transitions, transition) }
+	}
+
+	return transitions
+}
+
+//=================================================================================================================================
+//	 TransitionTo	-	Moves the claim to the next state, checking that the state machine allows it for the action
+//=================================================================================================================================
+func (t *Claim) TransitionTo(action string, nextState string) (error) {
+	transition, found := FindClaimTransition(t.Details.Status, action)
+
+	if !found || indexOf(transition.NextStates, nextState) < 0 {
+		fmt.Println("INVALID_TRANSITION: " + action + " cannot move claim " + t.Id + " from " + t.Details.Status + " to " + nextState)
+		return errors.New("INVALID_TRANSITION: " + action + " cannot move claim " + t.Id + " from " + t.Details.Status + " to " + nextState)
+	}
+
+	t.Details.Status = nextState
+
+	return nil
+}
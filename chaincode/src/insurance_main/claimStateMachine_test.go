@@ -0,0 +1,85 @@
+//go:build identityinjector && memorystorage
+// +build identityinjector,memorystorage
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRetrieveClaimStateMachine(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_MEMORY)
+
+	InjectRole("claimant1", ROLE_POLICY_HOLDER)
+
+	bytes, err := cc.Query(stub, "retrieveClaimStateMachine", []string{})
+	if err != nil { t.Fatal(err) }
+
+	var transitions []ClaimTransition
+
+	err = unmarshal(bytes, &transitions)
+	if err != nil { t.Fatal(err) }
+
+	if len(transitions) != len(CLAIM_TRANSITIONS) { t.Fatalf("Expected %d transitions, got %d", len(CLAIM_TRANSITIONS), len(transitions)) }
+
+	for index, transition := range transitions {
+		expected := CLAIM_TRANSITIONS[index]
+
+		if transition.State != expected.State || transition.Action != expected.Action || len(transition.NextStates) != len(expected.NextStates) {
+			t.Fatalf("Unexpected transition: %+v", transition)
+		}
+	}
+}
+
+func TestInvalidTransitionsFailTheSameWay(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_MEMORY)
+
+	err := invokeAs(cc, stub, "claimant1", ROLE_POLICY_HOLDER, "createClaim", "P1", "Hit a tree", "2017-01-01", SINGLE_PARTY)
+	if err != nil { t.Fatal(err) }
+
+	claimId := claimOnPolicy(t, stub, "P1").Id
+
+	//Each caller is permitted to perform the action, but not while the claim awaits a garage report
+	tests := []struct {
+		username	string
+		role		string
+		action		string
+		args		[]string
+	}{
+		{"claimant1",	ROLE_POLICY_HOLDER,	ACTION_DECLARE_LIABILITY,			[]string{claimId, "true"}},
+		{"admin",		ROLE_SUPER_USER,	ACTION_RESOLVE_LIABILITY_DISPUTE,	[]string{claimId, "{}"}},
+		{"police1",		ROLE_POLICE,		ACTION_ADD_POLICE_REPORT,			[]string{claimId, "Hit a tree", "1", "2", "BP08BRV"}},
+		{"insurer1",	ROLE_INSURER,		ACTION_ORDER_GARAGE_WORK,			[]string{claimId, "2017-02-01"}},
+		{"garage1",		ROLE_GARAGE,		ACTION_CONFIRM_WORK,				[]string{claimId, "900", "2017-02-10"}},
+		{"claimant1",	ROLE_POLICY_HOLDER,	ACTION_AGREE_PAYOUT_AMOUNT,			[]string{claimId, "true"}},
+		{"insurer1",	ROLE_INSURER,		ACTION_CONFIRM_PAID_OUT,			[]string{claimId, "1"}},
+		{"insurer1",	ROLE_INSURER,		ACTION_CLOSE_CLAIM,					[]string{claimId}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.action, func(t *testing.T) {
+			err := invokeAs(cc, stub, test.username, test.role, test.action, test.args...)
+
+			expected := "INVALID_TRANSITION: " + test.action + " is not allowed for claim " + claimId + " in state " + STATE_AWAITING_GARAGE_REPORT
+			if err == nil || err.Error() != expected { t.Fatalf("Expected %q, got: %v", expected, err) }
+
+			expectStatus(t, stub, claimId, STATE_AWAITING_GARAGE_REPORT)
+		})
+	}
+}
+
+func TestTransitionToRejectsStateNotInTable(t *testing.T) {
+	claim := NewClaim("C1", "P1", "Hit a tree", "2017-01-01", SINGLE_PARTY)
+	claim.Details.Status = STATE_SETTLED
+
+	err := claim.TransitionTo(ACTION_CLOSE_CLAIM, STATE_AWAITING_GARAGE_REPORT)
+	if err == nil || !strings.HasPrefix(err.Error(), "INVALID_TRANSITION") { t.Fatalf("Expected the transition to be refused, got: %v", err) }
+
+	if claim.Details.Status != STATE_SETTLED { t.Fatalf("The claim was moved to: %s", claim.Details.Status) }
+
+	err = claim.TransitionTo(ACTION_CLOSE_CLAIM, STATUS_CLOSED)
+	if err != nil { t.Fatal(err) }
+
+	if claim.Details.Status != STATUS_CLOSED { t.Fatalf("Unexpected state: %s", claim.Details.Status) }
+}
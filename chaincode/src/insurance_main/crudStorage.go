@@ -0,0 +1,244 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/util"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"strings"
+	"errors"
+	"fmt"
+)
+
+//Stored the chaincodeId of the CRUD chaincode
+const CRUD_CHAINCODE_ID_KEY = "CRUD_CHAINCODE_ID"
+
+//Stores the token this chaincode was registered with when the CRUD chaincode was deployed
+const CRUD_TOKEN_KEY = "CRUD_TOKEN"
+
+//The name this chaincode is registered with in the CRUD chaincode.  The name and token are sent with every call.
+const CRUD_CALLER_NAME = "insurance_main"
+
+const SAVE_FUNCTION = "save"
+const RETRIEVE_VERSIONED_FUNCTION = "retrieveVersioned"
+const LIST_FUNCTION = "list"
+const SAVE_BATCH_FUNCTION = "saveBatch"
+const DELETE_BATCH_FUNCTION = "deleteBatch"
+
+//The crud chaincode doesn't fail an invoke that it rejects, so that its access rejected event is delivered.  Instead the
+//response starts with this prefix.
+const CRUD_ACCESS_REJECTED_PREFIX = "ACCESS_REJECTED: "
+
+//Number of objects to retrieve from the crud chaincode in each list query
+const LIST_PAGE_SIZE = "100"
+
+//==============================================================================================================================
+//	 Page - A page of key/value pairs returned from a crud chaincode list query
+//==============================================================================================================================
+type Page struct {
+	Entries		[]KeyValue	`json:"entries"`
+	NextKey		string		`json:"nextKey"`
+}
+
+//==============================================================================================================================
+//	CrudStorage - Saves objects in the CRUD chaincode.  Writes made during an invoke are held in the transaction context
+//		and sent in a single batch when the transaction is flushed.  Objects retrieved earlier in the transaction are only
+//		saved if they haven't been changed since.
+//==============================================================================================================================
+type CrudStorage struct {}
+
+//=================================================================================================================================
+//	 InitCrudStorage	-	Stores the chaincodeId and token used to call the CRUD chaincode.  Values that aren't given are kept
+//							from the last time the chaincode was initialised.
+//=================================================================================================================================
+func InitCrudStorage(stub shim.ChaincodeStubInterface, crudChaincodeId string, crudToken string) {
+	if crudChaincodeId != "" { stub.PutState(CRUD_CHAINCODE_ID_KEY, []byte(crudChaincodeId)) }
+	if crudToken != "" { stub.PutState(CRUD_TOKEN_KEY, []byte(crudToken)) }
+}
+
+func (t CrudStorage) Retrieve(stub shim.ChaincodeStubInterface, id string) ([]byte, error) {
+	//Anything written earlier in this transaction hasn't been sent to the crud chaincode yet
+	if write, found := GetTxContext(stub).GetPendingWrite(id); found {
+		if write.Deleted { return nil, nil }
+		return write.Value, nil
+	}
+
+	bytes, err := query(stub, getCrudChaincodeId(stub), RETRIEVE_VERSIONED_FUNCTION, []string{id})
+	if err != nil { return nil, err }
+
+	var keyValue KeyValue
+
+	err = unmarshal(bytes, &keyValue)
+	if err != nil { fmt.Printf("retrieve: Cannot unmarshall value with id: " + id + " : %s", err); return nil, err }
+
+	//Saving this object later in the transaction should fail if it has been changed since now
+	GetTxContext(stub).RecordReadVersion(id, keyValue.Version)
+
+	if keyValue.Version == 0 { return nil, nil }
+
+	return []byte(keyValue.Value), nil
+}
+
+//=================================================================================================================================
+//	 List		-	Retrieves all key/value pairs with keys starting with the prefix, one page at a time
+//=================================================================================================================================
+func (t CrudStorage) List(stub shim.ChaincodeStubInterface, prefix string) ([]KeyValue, error) {
+	entries := []KeyValue{}
+	startAfter := ""
+
+	for {
+		bytes, err := query(stub, getCrudChaincodeId(stub), LIST_FUNCTION, []string{prefix, LIST_PAGE_SIZE, startAfter})
+		if err != nil { fmt.Printf("listObjects: Cannot list objects with prefix: " + prefix + " : %s", err); return entries, err }
+
+		var page Page
+
+		err = unmarshal(bytes, &page)
+		if err != nil { fmt.Printf("listObjects: Cannot unmarshall page with prefix: " + prefix + " : %s", err); return entries, err }
+
+		for _, entry := range page.Entries {
+			GetTxContext(stub).RecordReadVersion(entry.Key, entry.Version)
+		}
+
+		entries = append(entries, page.Entries...)
+
+		if page.NextKey == "" { return applyPendingWrites(stub, prefix, entries), nil }
+
+		startAfter = page.NextKey
+	}
+}
+
+//=================================================================================================================================
+//	 applyPendingWrites		-	Updates listed key/value pairs with anything written earlier in this transaction
+//=================================================================================================================================
+func applyPendingWrites(stub shim.ChaincodeStubInterface, prefix string, entries []KeyValue) ([]KeyValue) {
+	context := GetTxContext(stub)
+
+	if len(context.PendingKeys) == 0 { return entries }
+
+	result := []KeyValue{}
+	listed := map[string]bool{}
+
+	for _, entry := range entries {
+		listed[entry.Key] = true
+
+		write, found := context.GetPendingWrite(entry.Key)
+
+		if !found {
+			result = append(result, entry)
+		} else if !write.Deleted {
+			result = append(result, KeyValue{Key: entry.Key, Value: string(write.Value)})
+		}
+	}
+
+	for _, key := range context.PendingKeys {
+		write := context.PendingWrites[key]
+
+		if !listed[key] && !write.Deleted && strings.HasPrefix(key, prefix) {
+			result = append(result, KeyValue{Key: key, Value: string(write.Value)})
+		}
+	}
+
+	return result
+}
+
+func (t CrudStorage) Save(stub shim.ChaincodeStubInterface, id string, toSave []byte) (error){
+	context := GetTxContext(stub)
+
+	//Hold the write until the end of the transaction
+	if context.IsOpen {
+		context.AddPendingWrite(PendingWrite{id, toSave, false})
+		return nil
+	}
+
+	return invoke(stub, getCrudChaincodeId(stub), SAVE_FUNCTION, []string{id, string(toSave)})
+}
+
+func (t CrudStorage) Delete(stub shim.ChaincodeStubInterface, id string) (error){
+	context := GetTxContext(stub)
+
+	//Hold the delete until the end of the transaction
+	if context.IsOpen {
+		context.AddPendingWrite(PendingWrite{id, nil, true})
+		return nil
+	}
+
+	bytes, err := marshall([]string{id})
+	if err != nil { return err }
+
+	return invoke(stub, getCrudChaincodeId(stub), DELETE_BATCH_FUNCTION, []string{string(bytes)})
+}
+
+//=================================================================================================================================
+//	 Flush		-	Sends all of the writes held during the transaction to the crud chaincode, one batch of saves and one
+//					batch of deletes.  Objects retrieved earlier in the transaction are only saved if they haven't been
+//					changed since, otherwise a VersionConflictError is returned.
+//=================================================================================================================================
+func (t CrudStorage) Flush(stub shim.ChaincodeStubInterface) (error){
+	context := GetTxContext(stub)
+
+	saves := []KeyValue{}
+	deletes := []string{}
+
+	for _, key := range context.PendingKeys {
+		write := context.PendingWrites[key]
+
+		if write.Deleted {
+			deletes = append(deletes, key)
+		} else {
+			keyValue := KeyValue{Key: key, Value: string(write.Value)}
+
+			if version, found := context.GetReadVersion(key); found {
+				keyValue.ExpectedVersion = &version
+			}
+
+			saves = append(saves, keyValue)
+		}
+	}
+
+	context.ClearPendingWrites()
+
+	if len(saves) > 0 {
+		bytes, err := marshall(saves)
+		if err != nil { return err }
+
+		err = invoke(stub, getCrudChaincodeId(stub), SAVE_BATCH_FUNCTION, []string{string(bytes)})
+		if err != nil { fmt.Printf("FlushPendingWrites: Unable to save batch: %s", err); return toVersionConflictError(err) }
+	}
+
+	if len(deletes) > 0 {
+		bytes, err := marshall(deletes)
+		if err != nil { return err }
+
+		err = invoke(stub, getCrudChaincodeId(stub), DELETE_BATCH_FUNCTION, []string{string(bytes)})
+		if err != nil { fmt.Printf("FlushPendingWrites: Unable to delete batch: %s", err); return err }
+	}
+
+	return nil
+}
+
+func invoke(stub shim.ChaincodeStubInterface, chaincodeId, functionName string, args []string) (error){
+	response, err := stub.InvokeChaincode(chaincodeId, createArgs(functionName, stub.GetTxID(), getCrudToken(stub), args))
+	if err != nil { return err }
+
+	if strings.HasPrefix(string(response), CRUD_ACCESS_REJECTED_PREFIX) { return errors.New(string(response)) }
+
+	return nil
+}
+
+func query(stub shim.ChaincodeStubInterface, chaincodeId, functionName string, args []string) ([]byte, error){
+	return stub.QueryChaincode(chaincodeId, createArgs(functionName, stub.GetTxID(), getCrudToken(stub), args))
+}
+
+func createArgs(functionName string, txId string, crudToken string, args[]string) ([][]byte){
+	funcAndArgs := append([]string{functionName}, txId, CRUD_CALLER_NAME, crudToken)
+	funcAndArgs = append(funcAndArgs, args...)
+	return util.ArrayToChaincodeArgs(funcAndArgs)
+}
+
+func getCrudChaincodeId(stub shim.ChaincodeStubInterface) (string) {
+	bytes, _ := stub.GetState(CRUD_CHAINCODE_ID_KEY)
+	return string(bytes)
+}
+
+func getCrudToken(stub shim.ChaincodeStubInterface) (string) {
+	bytes, _ := stub.GetState(CRUD_TOKEN_KEY)
+	return string(bytes)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFlushRejectsBatchWithStaleRead(t *testing.T) {
+	stub := newTestStub()
+	stub.crud = newCrudPeer()
+
+	InitCrudStorage(stub, "insurance_crud", "token")
+
+	stub.crud.put("claim:C1", "first")
+	stub.crud.put("claim:C2", "first")
+
+	OpenTxContext(stub, "test", "insurer1", "")
+	defer CloseTxContext(stub)
+
+	storage := CrudStorage{}
+
+	//Read at version 1, then another transaction saves the claim, moving it to version 2
+	_, err := storage.Retrieve(stub, "claim:C1")
+	if err != nil { t.Fatal(err) }
+
+	stub.crud.put("claim:C1", "second")
+
+	storage.Save(stub, "claim:C1", []byte("stale"))
+	storage.Save(stub, "claim:C2", []byte("stale"))
+
+	if stub.crud.lastArgs[0] != RETRIEVE_VERSIONED_FUNCTION { t.Fatalf("Saves should be held until the flush, last call: %v", stub.crud.lastArgs) }
+
+	err = storage.Flush(stub)
+	if !IsVersionConflictError(err) { t.Fatalf("Expected a version conflict, got: %v", err) }
+
+	conflict := err.(VersionConflictError)
+	if conflict.Key != "claim:C1" || conflict.ExpectedVersion != 1 || conflict.ActualVersion != 2 {
+		t.Fatalf("Unexpected conflict details: %+v", conflict)
+	}
+
+	if stub.crud.lastArgs[0] != SAVE_BATCH_FUNCTION || stub.crud.lastArgs[1] != stub.txId || stub.crud.lastArgs[3] != "token" {
+		t.Fatalf("Unexpected call to the crud chaincode: %v", stub.crud.lastArgs)
+	}
+
+	//Nothing in the batch is written
+	if stub.crud.values["claim:C1"] != "second" || stub.crud.versions["claim:C1"] != 2 { t.Fatal("claim:C1 was overwritten") }
+	if stub.crud.values["claim:C2"] != "first" || stub.crud.versions["claim:C2"] != 1 { t.Fatal("claim:C2 was partly written") }
+}
+
+func TestFlushSavesBatchAtReadVersion(t *testing.T) {
+	stub := newTestStub()
+	stub.crud = newCrudPeer()
+
+	InitCrudStorage(stub, "insurance_crud", "token")
+
+	stub.crud.put("claim:C1", "first")
+
+	OpenTxContext(stub, "test", "insurer1", "")
+	defer CloseTxContext(stub)
+
+	storage := CrudStorage{}
+
+	_, err := storage.Retrieve(stub, "claim:C1")
+	if err != nil { t.Fatal(err) }
+
+	storage.Save(stub, "claim:C1", []byte("second"))
+	storage.Save(stub, "claim:C2", []byte("first"))
+
+	err = storage.Flush(stub)
+	if err != nil { t.Fatal(err) }
+
+	if stub.crud.values["claim:C1"] != "second" || stub.crud.versions["claim:C1"] != 2 { t.Fatal("claim:C1 was not saved") }
+	if stub.crud.values["claim:C2"] != "first" || stub.crud.versions["claim:C2"] != 1 { t.Fatal("claim:C2 was not saved") }
+}
+
+func TestFlushFailsWhenCrudRejectsCaller(t *testing.T) {
+	stub := newTestStub()
+	stub.crud = newCrudPeer()
+	stub.crud.token = "token"
+
+	InitCrudStorage(stub, "insurance_crud", "wrong")
+
+	OpenTxContext(stub, "test", "insurer1", "")
+	defer CloseTxContext(stub)
+
+	storage := CrudStorage{}
+
+	storage.Save(stub, "claim:C1", []byte("first"))
+
+	err := storage.Flush(stub)
+	if err == nil || !strings.HasPrefix(err.Error(), CRUD_ACCESS_REJECTED_PREFIX) { t.Fatalf("Expected the rejection to fail the flush, got: %v", err) }
+
+	if _, found := stub.crud.values["claim:C1"]; found { t.Fatal("claim:C1 was saved") }
+}
+
+func TestInitMigratesLegacyKeysOnce(t *testing.T) {
+	cc := new(InsuranceChaincode)
+	stub := newTestStub()
+	stub.crud = newCrudPeer()
+
+	stub.crud.put(LEGACY_APPROVED_GARAGES_KEY, `{"garages":["garage7"]}`)
+	stub.crud.put("currentClaimId", "3")
+
+	_, err := cc.Init(stub, "init", []string{"insurance_crud", "", "", STORAGE_BACKEND_CRUD})
+	if err != nil { t.Fatal(err) }
+
+	//The approved garages moved under the config namespace are read back before anything is sent to the crud chaincode
+	if _, found := stub.crud.values[GARAGE_NAMESPACE + "garage7"]; !found { t.Fatal("garage7 was not migrated to a garage profile") }
+
+	for _, key := range []string{LEGACY_APPROVED_GARAGES_KEY, APPROVED_GARAGES_KEY, "currentClaimId"} {
+		if _, found := stub.crud.values[key]; found { t.Fatalf("%s was not removed", key) }
+	}
+
+	if stub.crud.calls[SAVE_FUNCTION] != 0 || stub.crud.calls[SAVE_BATCH_FUNCTION] != 1 { t.Fatalf("Expected Init to save in one batch, got: %v", stub.crud.calls) }
+
+	if version := stub.crud.values[SCHEMA_VERSION_KEY]; version != SCHEMA_VERSION { t.Fatalf("Unexpected schema version: %s", version) }
+
+	//Later Inits don't look for legacy keys again
+	stub.crud.calls = map[string]int{}
+	stub.nextTx()
+
+	_, err = cc.Init(stub, "init", []string{})
+	if err != nil { t.Fatal(err) }
+
+	if stub.crud.calls[LIST_FUNCTION] != 0 { t.Fatalf("Expected no objects to be listed, got: %v", stub.crud.calls) }
+}
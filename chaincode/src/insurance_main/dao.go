@@ -1,333 +1,290 @@
 package main
 
 import (
-	"github.com/hyperledger/fabric/core/util"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"strconv"
+	"strings"
 	"fmt"
 )
 
 //==============================================================================================================================
-//	 Keys for obtaining the current id for the different domain object types.
-//   Ids are incremental so knowing the latest id is useful when querying for
-//   all domain objects of a certain type.
+//	 Key namespaces - Every object is saved in the crud chaincode under a key made up of the namespace for its type and its
+//	 id, so that objects of different types can never overwrite each other (e.g. a vehicle registered as a policy id)
 //==============================================================================================================================
-const   CURRENT_POLICY_ID_KEY	= "currentPolicyId"
-const	CURRENT_USER_ID_KEY	= "currentUserId"
-const   CURRENT_CLAIM_ID_KEY	= "currentClaimId"
+const	POLICY_NAMESPACE		= "policy:"
+const	CLAIM_NAMESPACE			= "claim:"
+const	CLAIM_HISTORY_NAMESPACE	= "claimHistory:"
+const	THIRD_PARTY_NAMESPACE	= "thirdParty:"
+const	VEHICLE_NAMESPACE		= "vehicle:"
+const	USER_NAMESPACE			= "user:"
+const	GARAGE_NAMESPACE		= "garage:"
+const	CONFIG_NAMESPACE		= "config:"
 
-//Used to store all current approved garages
-const	APPROVED_GARAGES_KEY	= "approvedGarages"
+var		NAMESPACES				= []string{POLICY_NAMESPACE, CLAIM_NAMESPACE, CLAIM_HISTORY_NAMESPACE, THIRD_PARTY_NAMESPACE,
+									VEHICLE_NAMESPACE, USER_NAMESPACE, GARAGE_NAMESPACE, CONFIG_NAMESPACE}
 
-//Stored the chaincodeId of the CRUD chaincode
-const CRUD_CHAINCODE_ID_KEY = "CRUD_CHAINCODE_ID"
+//Used to store the names of approved garages before garage profiles were introduced
+const	APPROVED_GARAGES_KEY	= CONFIG_NAMESPACE + "approvedGarages"
+
+//Used to store the roles allowed to call each function
+const	PERMISSIONS_KEY			= CONFIG_NAMESPACE + "permissions"
+
+//Used to store the version of the key layout, so that legacy keys are only looked for once
+const	SCHEMA_VERSION_KEY		= CONFIG_NAMESPACE + "schemaVersion"
+const	SCHEMA_VERSION			= "1"
 
 //==============================================================================================================================
-//	 Prefixes for the different domain object type ids
+//	 Keys used before namespaces were introduced, which are moved or removed when the chaincode is initialised
+//==============================================================================================================================
+const	LEGACY_APPROVED_GARAGES_KEY		= "approvedGarages"
+var		LEGACY_ID_COUNTER_KEYS			= []string{"currentPolicyId", "currentUserId", "currentClaimId", "currentThirdPartyId"}
+
+//==============================================================================================================================
+//	 Prefixes for the different domain object type ids.  Ids are made up of the prefix, a hash of the transaction id and
+//	 a sequence number within the transaction, so that no shared counter is needed to create a new object.
 //==============================================================================================================================
 const   POLICY_ID_PREFIX	= "P"
 const	USER_ID_PREFIX		= "U"
 const   CLAIM_ID_PREFIX		= "C"
+const   THIRD_PARTY_ID_PREFIX	= "T"
 
-const SAVE_FUNCTION = "save"
-const RETRIEVE_FUNCTION = "retrieve"
+//Number of hex characters of the transaction id hash used in an id
+const	ID_TX_HASH_LENGTH	= 16
 
 type Dao struct {}
 
-func InitDao(stub shim.ChaincodeStubInterface, crudChaincodeId string) {
-	stub.PutState(CRUD_CHAINCODE_ID_KEY, []byte(crudChaincodeId))
-	configureIdState(stub)
-	configureApprovedGaragesState(stub)
-}
+//==============================================================================================================================
+//	 InitDao - Chooses the storage backend, then moves any objects saved under legacy keys.  The crud chaincode id and
+//	 token are only used by the crud backend.
+//==============================================================================================================================
+func InitDao(stub shim.ChaincodeStubInterface, crudChaincodeId string, crudToken string, storageBackend string) (error) {
+	err := InitStorage(stub, storageBackend)
+	if err != nil { return err }
 
-func SavePolicy(stub shim.ChaincodeStubInterface, policy Policy) (Policy, error) {
-	if policy.Id == "" {
-		policyId := getNextPolicyId(stub)
+	InitCrudStorage(stub, crudChaincodeId, crudToken)
+	migrateKeyNamespaces(stub)
+	migrateApprovedGarages(stub)
 
-		policy.Id = policyId
-	}
+	return nil
+}
 
-	err := saveObject(stub, policy.Id, policy)
+//=================================================================================================================================
+//	 FlushPendingWrites		-	Sends anything held by the storage backend during the transaction, at the end of an invoke
+//=================================================================================================================================
+func FlushPendingWrites(stub shim.ChaincodeStubInterface) (error){
+	return GetStorage(stub).Flush(stub)
+}
 
-	return policy, err
+func SavePolicy(stub shim.ChaincodeStubInterface, policy Policy) (Policy, error) {
+	return GetRepositories(stub).Policies.Save(stub, policy)
 }
 
 func RetrievePolicy(stub shim.ChaincodeStubInterface, id string) (Policy, error){
-	var policy Policy
-
-	err := retrieveObject(stub, id, &policy)
-
-	return policy, err
+	return GetRepositories(stub).Policies.Retrieve(stub, id)
 }
 
 func RetrieveAllPolicies(stub shim.ChaincodeStubInterface) ([]Policy){
-	var policies []Policy
-
-	numberOfPolicies := getCurrentPolicyIdNumber(stub)
-
-	for i := 1; i <= numberOfPolicies; i++ {
-
-		policyId := POLICY_ID_PREFIX + strconv.Itoa(i)
-
-		policy, err := RetrievePolicy(stub, policyId)
-
-		if err != nil {	fmt.Printf("RetrieveAllPolicies: Error but continuing: %s", err); }
-
-		policies = append(policies, policy)
-	}
-
-	return policies
+	return GetRepositories(stub).Policies.RetrieveAll(stub)
 }
 
 func SaveClaim(stub shim.ChaincodeStubInterface, claim Claim) (Claim, error) {
-	if claim.Id == "" {
-		claimId := getNextClaimId(stub)
-
-		claim.Id = claimId
-	}
+	return GetRepositories(stub).Claims.Save(stub, claim)
+}
 
-	err := saveObject(stub, claim.Id, claim)
+func SaveClaimHistory(stub shim.ChaincodeStubInterface, history ClaimHistory) (error) {
+	return GetRepositories(stub).Claims.SaveHistory(stub, history)
+}
 
-	return claim, err
+func RetrieveClaimHistory(stub shim.ChaincodeStubInterface, claimId string) (ClaimHistory, error){
+	return GetRepositories(stub).Claims.RetrieveHistory(stub, claimId)
 }
 
 func RetrieveClaim(stub shim.ChaincodeStubInterface, id string) (Claim, error){
-	var claim Claim
-
-	err := retrieveObject(stub, id, &claim)
-
-	return claim, err
+	return GetRepositories(stub).Claims.Retrieve(stub, id)
 }
 
 func RetrieveAllClaims(stub shim.ChaincodeStubInterface) ([]Claim){
-	var claims []Claim
-
-	numberOfClaims := getCurrentClaimIdNumber(stub)
-
-	for i := 1; i <= numberOfClaims; i++ {
-
-		claimId := CLAIM_ID_PREFIX + strconv.Itoa(i)
-
-		claim, err := RetrieveClaim(stub, claimId)
-
-		if err != nil {	fmt.Printf("RetrieveAllClaims: Error but continuing: %s", err); }
+	return GetRepositories(stub).Claims.RetrieveAll(stub)
+}
 
-		claims = append(claims, claim)
-	}
+func SaveThirdParty(stub shim.ChaincodeStubInterface, thirdParty ThirdParty) (ThirdParty, error) {
+	return GetRepositories(stub).ThirdParties.Save(stub, thirdParty)
+}
 
-	return claims
+func RetrieveThirdParty(stub shim.ChaincodeStubInterface, id string) (ThirdParty, error){
+	return GetRepositories(stub).ThirdParties.Retrieve(stub, id)
 }
 
 func SaveVehicle(stub shim.ChaincodeStubInterface, vehicle Vehicle) (Vehicle, error) {
-	if vehicle.Id == "" {
-		vehicle.Id = vehicle.Details.Registration
-	}
-
-	err := saveObject(stub, vehicle.Id, vehicle)
-
-	return vehicle, err
+	return GetRepositories(stub).Vehicles.Save(stub, vehicle)
 }
 
 func RetrieveVehicle(stub shim.ChaincodeStubInterface, id string) (Vehicle, error){
-	var vehicle Vehicle
-
-	err := retrieveObject(stub, id, &vehicle)
-
-	return vehicle, err
+	return GetRepositories(stub).Vehicles.Retrieve(stub, id)
 }
 
 func SaveUser(stub shim.ChaincodeStubInterface, user User) (User, error) {
-	if user.Id == "" {
-		userId := getNextUserId(stub)
-
-		user.Id = userId
-	}
-
-	err := saveObject(stub, user.Id, user)
-
-	return user, err
+	return GetRepositories(stub).Users.Save(stub, user)
 }
 
 func RetrieveUser(stub shim.ChaincodeStubInterface, id string) (User, error){
-	var user User
-
-	err := retrieveObject(stub, id, &user)
-
-	return user, err
+	return GetRepositories(stub).Users.Retrieve(stub, id)
 }
 
-func SaveApprovedGarages(stub shim.ChaincodeStubInterface, approvedGarages ApprovedGarages) (ApprovedGarages, error) {
-	err := saveObject(stub, APPROVED_GARAGES_KEY, approvedGarages)
-
-	return approvedGarages, err
+func SaveGarage(stub shim.ChaincodeStubInterface, garage Garage) (Garage, error) {
+	return GetRepositories(stub).Garages.Save(stub, garage)
 }
 
-func RetrieveApprovedGarages(stub shim.ChaincodeStubInterface) (ApprovedGarages, error){
-	var approvedGarages ApprovedGarages
-
-	err := retrieveObject(stub, APPROVED_GARAGES_KEY, &approvedGarages)
-
-	return approvedGarages, err
+func RetrieveGarage(stub shim.ChaincodeStubInterface, id string) (Garage, error){
+	return GetRepositories(stub).Garages.Retrieve(stub, id)
 }
 
-//=================================================================================================================================
-//	 AppendApprovedGarages		-	Appends approved garages to the list of approved garages in the ledger
-//=================================================================================================================================
-func AppendApprovedGarages(stub shim.ChaincodeStubInterface, newGarages []string) (error){
-	approvedGarages, err := RetrieveApprovedGarages(stub)
-	if (err != nil) { return err }
-
-	approvedGarages.Garages = append(approvedGarages.Garages, newGarages...)
-
-	_, err = SaveApprovedGarages(stub, approvedGarages)
-
-	return err
+func RetrieveAllGarages(stub shim.ChaincodeStubInterface) ([]Garage){
+	return GetRepositories(stub).Garages.RetrieveAll(stub)
 }
 
-func retrieve(stub shim.ChaincodeStubInterface, id string) ([]byte, error) {
-	return query(stub, getCrudChaincodeId(stub), RETRIEVE_FUNCTION, []string{id})
+func DeleteGarage(stub shim.ChaincodeStubInterface, id string) (error){
+	return GetRepositories(stub).Garages.Delete(stub, id)
 }
 
-func retrieveObject(stub shim.ChaincodeStubInterface, id string, toStoreObject interface{}) (error){
-	bytes, err := retrieve(stub, id)
-
-	if err != nil {	fmt.Printf("RetrieveObject: Cannot retrieve object with id: " + id + " : %s", err); return err}
-
-	err = unmarshal(bytes, toStoreObject)
+func SavePermissions(stub shim.ChaincodeStubInterface, permissions Permissions) (Permissions, error) {
+	return GetRepositories(stub).Permissions.Save(stub, permissions)
+}
 
-	if err != nil {	fmt.Printf("RetrieveObject: Cannot unmarshall object with id: " + id + " : %s", err); return err}
+func RetrievePermissions(stub shim.ChaincodeStubInterface) (Permissions, error){
+	return GetRepositories(stub).Permissions.Retrieve(stub)
+}
 
-	return nil
+func marshall(toMarshall interface{}) ([]byte, error) {
+	return json.Marshal(toMarshall)
 }
 
-func saveObject(stub shim.ChaincodeStubInterface, id string, object interface{}) (error){
-	bytes, err := marshall(object)
+func unmarshal(data []byte, object interface{}) (error){
+	return json.Unmarshal(data, object)
+}
 
-	if err != nil {fmt.Printf("\nUnable to marshall object with id: " + id + " : %s", err); return err}
+//==============================================================================================================================
+//	 ID Functions - Ids are derived from the transaction id, so every peer creates the same id for a new object without
+//	 reading or updating a counter.  Each id created in a transaction takes the next number of the transaction's sequence.
+//   A prefix is added to the id's to differentiate between policies, claims, users and third parties
+//==============================================================================================================================
+func getNextPolicyId(stub shim.ChaincodeStubInterface) (string, error) {
 
-	err = save(stub, id, bytes)
+	return getNextId(stub, POLICY_ID_PREFIX);
+}
 
-	if err != nil {fmt.Printf("Unable to save policy: %s",  err); return err}
+func getNextUserId(stub shim.ChaincodeStubInterface) (string, error) {
 
-	return nil
+	return getNextId(stub, USER_ID_PREFIX);
 }
 
-func save(stub shim.ChaincodeStubInterface, id string, toSave []byte) (error){
-	return invoke(stub, getCrudChaincodeId(stub), SAVE_FUNCTION, []string{id, string(toSave)})
+func getNextClaimId(stub shim.ChaincodeStubInterface) (string, error) {
+
+	return getNextId(stub, CLAIM_ID_PREFIX);
 }
 
-func invoke(stub shim.ChaincodeStubInterface, chaincodeId, functionName string, args []string) (error){
-	_, error := stub.InvokeChaincode(chaincodeId, createArgs(functionName, stub.GetTxID(), args))
+func getNextThirdPartyId(stub shim.ChaincodeStubInterface) (string, error) {
 
-	return error
+	return getNextId(stub, THIRD_PARTY_ID_PREFIX);
 }
 
-func query(stub shim.ChaincodeStubInterface, chaincodeId, functionName string, args []string) ([]byte, error){
-	return stub.QueryChaincode(chaincodeId, createArgs(functionName, stub.GetTxID(), args))
-}
+func getNextId(stub shim.ChaincodeStubInterface, idPrefix string) (string, error) {
 
-func createArgs(functionName string, txId string, args[]string) ([][]byte){
-	funcAndArgs := append([]string{functionName}, txId)
-	funcAndArgs = append(funcAndArgs, args...)
-	return util.ArrayToChaincodeArgs(funcAndArgs)
-}
+	context := GetTxContext(stub)
 
-func marshall(toMarshall interface{}) ([]byte, error) {
-	return json.Marshal(toMarshall)
-}
+	//The sequence is held in the transaction context, so ids can only be created within an invoke
+	if !context.IsOpen { return "", errors.New("Unable to create an id outside of a transaction") }
 
-func unmarshal(data []byte, object interface{}) (error){
-	return json.Unmarshal(data, object)
-}
+	txHash := sha256.Sum256([]byte(stub.GetTxID()))
 
-func getCrudChaincodeId(stub shim.ChaincodeStubInterface) (string) {
-	bytes, _ := stub.GetState(CRUD_CHAINCODE_ID_KEY)
-	return string(bytes)
+	return idPrefix + hex.EncodeToString(txHash[:])[:ID_TX_HASH_LENGTH] + "-" + strconv.Itoa(context.NextIdSequence()), nil
 }
 
 //==============================================================================================================================
-//	 ID Functions - The current id of both policies and claims are stored in blockchain state.
-//   This value is incremented when a new policy or claim is created.
-//   A prefix is added to the id's to differentiate between policies and claims
+//	 migrateApprovedGarages - Creates an approved garage profile, with only a name, for each garage in the list of approved
+//	 garages saved before garage profiles were introduced, then removes the list
 //==============================================================================================================================
-func getCurrentPolicyIdNumber(stub shim.ChaincodeStubInterface) (int) {
-	return getCurrentIdNumber(stub, CURRENT_POLICY_ID_KEY);
-}
-
-func getNextPolicyId(stub shim.ChaincodeStubInterface) (string) {
+func migrateApprovedGarages(stub shim.ChaincodeStubInterface) {
+	storage := GetStorage(stub)
 
-	return getNextId(stub, CURRENT_POLICY_ID_KEY, POLICY_ID_PREFIX);
-}
+	var approvedGarages ApprovedGarages
 
-func getNextUserId(stub shim.ChaincodeStubInterface) (string) {
+	err := retrieveObject(stub, storage, APPROVED_GARAGES_KEY, &approvedGarages)
+	if err != nil { return }
 
-	return getNextId(stub, CURRENT_USER_ID_KEY, USER_ID_PREFIX);
-}
+	for _, garageId := range approvedGarages.Garages {
+		_, err = RetrieveGarage(stub, garageId)
+		if !IsNotFoundError(err) { continue }
 
-func getCurrentClaimIdNumber(stub shim.ChaincodeStubInterface) (int) {
-	return getCurrentIdNumber(stub, CURRENT_CLAIM_ID_KEY);
-}
+		fmt.Println("Migrating approved garage " + garageId + " to a garage profile")
 
-func getNextClaimId(stub shim.ChaincodeStubInterface) (string) {
+		SaveGarage(stub, NewGarage(garageId, garageId, "", Coordinates{}, "", []string{}))
+	}
 
-	return getNextId(stub, CURRENT_CLAIM_ID_KEY, CLAIM_ID_PREFIX);
+	storage.Delete(stub, APPROVED_GARAGES_KEY)
 }
 
-func getNextId(stub shim.ChaincodeStubInterface, idKey string, idPrefix string) (string) {
-
-	currentId := getCurrentIdNumber(stub, idKey)
-
-	nextIdNum := strconv.Itoa(currentId + 1)
+//==============================================================================================================================
+//	 migrateKeyNamespaces - Moves objects saved before key namespaces were introduced to the key for their type, and
+//	 removes the id counters, which are no longer used.  Objects already under a namespace are left as they are.  Once
+//	 done the schema version is saved, so that later Inits don't list every key again.
+//==============================================================================================================================
+func migrateKeyNamespaces(stub shim.ChaincodeStubInterface) {
+	storage := GetStorage(stub)
 
-	save(stub, idKey, []byte(nextIdNum))
-	//Comment below regarding why we need to store this locally
-	stub.PutState(idKey, []byte(nextIdNum))
+	version, err := storage.Retrieve(stub, SCHEMA_VERSION_KEY)
+	if err != nil { fmt.Printf("migrateKeyNamespaces: Unable to retrieve the schema version: %s", err); return }
 
-	return idPrefix + nextIdNum
-}
+	if string(version) == SCHEMA_VERSION { return }
 
-func getCurrentIdNumber(stub shim.ChaincodeStubInterface, idKey string) (int) {
-	bytesFromCrud, err := retrieve(stub, idKey)
+	entries, err := storage.List(stub, "")
+	if err != nil { fmt.Printf("migrateKeyNamespaces: Unable to list objects: %s", err); return }
 
-	if err != nil { fmt.Printf("getCurrentIdNumber Error getting id %s", err); return -1}
+	for _, entry := range entries {
+		if indexOf(LEGACY_ID_COUNTER_KEYS, entry.Key) >= 0 {
+			storage.Delete(stub, entry.Key)
+			continue
+		}
 
-	currentIdFromCrud, _ := strconv.Atoi(string(bytesFromCrud))
+		key := namespacedKey(entry)
+		if key == "" { continue }
 
-	//There seems to be a bug in hyperledger where the state within a transaction is not correct within a different chaincode
-	//when invoking that chaincode multiple times within the same transaction, so we need to keep track of the id state locally
-	bytesFromLocal, _ := stub.GetState(idKey)
-	currentIdLocal, _ := strconv.Atoi(string(bytesFromLocal))
+		fmt.Println("Migrating key " + entry.Key + " to " + key)
 
-	//Check which is higher and use that
-	if (currentIdLocal > currentIdFromCrud) { return currentIdLocal }
+		storage.Save(stub, key, []byte(entry.Value))
+		storage.Delete(stub, entry.Key)
+	}
 
-	return currentIdFromCrud
+	storage.Save(stub, SCHEMA_VERSION_KEY, []byte(SCHEMA_VERSION))
 }
 
-func configureIdState(stub shim.ChaincodeStubInterface) {
-	bytes, err := retrieve(stub, CURRENT_POLICY_ID_KEY)
-	if (err != nil || len(bytes) == 0) {
-		fmt.Println("No existing policy id key, adding all....")
-		save(stub, CURRENT_POLICY_ID_KEY, []byte("2"))
-		save(stub, CURRENT_CLAIM_ID_KEY, []byte("0"))
-		save(stub, CURRENT_USER_ID_KEY, []byte("2"))
+//==============================================================================================================================
+//	 namespacedKey - Gets the namespaced key for an object saved under a legacy key, or an empty string if the key
+//	 doesn't need to be migrated
+//==============================================================================================================================
+func namespacedKey(entry KeyValue) (string) {
+	if entry.Key == LEGACY_APPROVED_GARAGES_KEY { return APPROVED_GARAGES_KEY }
+
+	//The namespace is the type of the object, which is also saved in the object
+	var object struct {
+		Type		string		`json:"type"`
+		ClaimId		string		`json:"claimId"`
 	}
 
-	//There seems to be a bug in hyperledger where the state within a transaction is not correct within a different chaincode
-	//when invoking that chaincode multiple times within the same transaction, so we need to keep track of the id state locally
-	stub.PutState(CURRENT_POLICY_ID_KEY, []byte("2"))
-	stub.PutState(CURRENT_CLAIM_ID_KEY, []byte("0"))
-	stub.PutState(CURRENT_USER_ID_KEY, []byte("2"))
-}
+	err := unmarshal([]byte(entry.Value), &object)
+	if err != nil || object.Type == "" || strings.HasPrefix(entry.Key, object.Type + ":") { return "" }
 
-func configureApprovedGaragesState(stub shim.ChaincodeStubInterface) {
-	bytes, err := retrieve(stub, APPROVED_GARAGES_KEY)
-	if (err != nil || len(bytes) == 0) {
-		fmt.Println("No existing approved garages entry exists, adding empty approved garages")
-		var approvedGarages ApprovedGarages
-		approvedGarages.Garages = []string{}
+	namespace := object.Type + ":"
 
-		saveObject(stub, APPROVED_GARAGES_KEY, approvedGarages)
+	if namespace == CLAIM_HISTORY_NAMESPACE {
+		return CLAIM_HISTORY_NAMESPACE + object.ClaimId
+	} else if indexOf([]string{POLICY_NAMESPACE, CLAIM_NAMESPACE, THIRD_PARTY_NAMESPACE, VEHICLE_NAMESPACE, USER_NAMESPACE}, namespace) >= 0 {
+		return namespace + entry.Key
 	}
+
+	return ""
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+//==============================================================================================================================
+//	NotFoundError - Returned when an object does not exist in the ledger
+//==============================================================================================================================
+type NotFoundError struct {
+	Id		string
+}
+
+func (e NotFoundError) Error() (string) {
+	return "NOT_FOUND: No object exists with id: " + e.Id
+}
+
+//==============================================================================================================================
+//	NotAuthorisedError - Returned when an object exists but the caller is not allowed to see it
+//==============================================================================================================================
+type NotAuthorisedError struct {
+	Id		string
+	Caller	string
+}
+
+func (e NotAuthorisedError) Error() (string) {
+	return "NOT_AUTHORISED: " + e.Caller + " is not authorised to access object with id: " + e.Id
+}
+
+//=================================================================================================================================
+//	 IsNotFoundError	-	Checks if an error was caused by an object not existing in the ledger
+//=================================================================================================================================
+func IsNotFoundError(err error) (bool) {
+	_, notFound := err.(NotFoundError)
+	return notFound
+}
+
+//==============================================================================================================================
+//	VersionConflictError - Returned when an object has been changed by another transaction since it was retrieved
+//==============================================================================================================================
+type VersionConflictError struct {
+	Key					string		`json:"key"`
+	ExpectedVersion		int			`json:"expectedVersion"`
+	ActualVersion		int			`json:"actualVersion"`
+}
+
+func (e VersionConflictError) Error() (string) {
+	return "VERSION_CONFLICT: Object with id: " + e.Key + " was expected at version " + strconv.Itoa(e.ExpectedVersion) +
+		" but is at version " + strconv.Itoa(e.ActualVersion)
+}
+
+//Prefix of the version conflict errors returned from the crud chaincode, which are followed by the details as JSON
+const CRUD_VERSION_CONFLICT_PREFIX = "VERSION_CONFLICT: "
+
+//=================================================================================================================================
+//	 IsVersionConflictError	-	Checks if an error was caused by an object being changed by another transaction
+//=================================================================================================================================
+func IsVersionConflictError(err error) (bool) {
+	_, conflict := err.(VersionConflictError)
+	return conflict
+}
+
+//=================================================================================================================================
+//	 toVersionConflictError	-	Converts a version conflict error returned from the crud chaincode into a VersionConflictError.
+//								Any other error is returned unchanged.
+//=================================================================================================================================
+func toVersionConflictError(err error) (error) {
+	if err == nil { return nil }
+
+	index := strings.Index(err.Error(), CRUD_VERSION_CONFLICT_PREFIX)
+	if index < 0 { return err }
+
+	var conflict VersionConflictError
+
+	//The error may have been wrapped, so only decode the JSON and ignore anything after it
+	decoder := json.NewDecoder(strings.NewReader(err.Error()[index + len(CRUD_VERSION_CONFLICT_PREFIX):]))
+	if decoder.Decode(&conflict) != nil { return err }
+
+	return conflict
+}
@@ -7,7 +7,7 @@ type ClaimSettledEvent struct {
 	Type			string				`json:"eventType"`
 	ClaimId			string				`json:"claimId"`
 	PolicyId		string				`json:"policyId"`
-	LinkedClaimId	string				`json:"linkedClaimId"`
+	LinkedClaimIds	[]string			`json:"linkedClaimIds"`
 }
 
 //==============================================================================================================================
@@ -19,22 +19,34 @@ type InsurerPaymentEvent struct {
 	PolicyId	string				`json:"policyId"`
 }
 
+//==============================================================================================================================
+//	PermissionsChangedEvent - Defines the structure for a permissions changed event.
+//==============================================================================================================================
+type PermissionsChangedEvent struct {
+	Type		string				`json:"eventType"`
+	Function	string				`json:"function"`
+	Roles		[]string			`json:"roles"`
+	Ownership	string				`json:"ownership"`
+	ChangedBy	string				`json:"changedBy"`
+}
+
 //==============================================================================================================================
 //	 Event type codes
 //==============================================================================================================================
 const EVENT_TYPE_CLAIM_SETTLED = "ClaimSettled";
 const EVENT_TYPE_INSURER_PAYMENT_PAID = "InsurerPaymentPaid";
+const EVENT_TYPE_PERMISSIONS_CHANGED = "PermissionsChanged";
 
 //=================================================================================================================================
 //	 NewClaimSettledEvent	-	Constructs a new ClaimSettledEvent
 //=================================================================================================================================
-func NewClaimSettledEvent(claimId string, policyId string, linkedClaimId string) (ClaimSettledEvent) {
+func NewClaimSettledEvent(claimId string, policyId string, linkedClaimIds []string) (ClaimSettledEvent) {
 	var event ClaimSettledEvent
 
 	event.Type = EVENT_TYPE_CLAIM_SETTLED
 	event.ClaimId = claimId
 	event.PolicyId = policyId
-	event.LinkedClaimId = linkedClaimId
+	event.LinkedClaimIds = linkedClaimIds
 
 	return event
 }
@@ -51,3 +63,18 @@ func NewInsurerPaymentPaidEvent(claimId string, policyId string) (InsurerPayment
 
 	return event
 }
+
+//=================================================================================================================================
+//	 NewPermissionsChangedEvent	-	Constructs a new PermissionsChangedEvent
+//=================================================================================================================================
+func NewPermissionsChangedEvent(permission Permission, changedBy string) (PermissionsChangedEvent) {
+	var event PermissionsChangedEvent
+
+	event.Type = EVENT_TYPE_PERMISSIONS_CHANGED
+	event.Function = permission.Function
+	event.Roles = permission.Roles
+	event.Ownership = permission.Ownership
+	event.ChangedBy = changedBy
+
+	return event
+}
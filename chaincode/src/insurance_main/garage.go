@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+//==============================================================================================================================
+//	Garage - Defines the structure for a Garage object.  The id is the username of the garage.
+//==============================================================================================================================
+type Garage struct {
+	Id			string			`json:"id"`
+	Type		string			`json:"type"`
+	Details		GarageDetails	`json:"details"`
+}
+
+//==============================================================================================================================
+//	GarageDetails - Defines the structure for a GarageDetails object.
+//		The location is given as longitude (x) and latitude (y).  An empty accreditation expiry means the
+//		accreditation doesn't expire.
+//==============================================================================================================================
+type GarageDetails struct {
+	Name					string		`json:"name"`
+	Address					string		`json:"address"`
+	Location				Coordinates	`json:"coordinates"`
+	AccreditationExpiry		string		`json:"accreditationExpiry"`
+	Specialisms				[]string	`json:"specialisms"`
+	Status					string		`json:"status"`
+	SuspensionReason		string		`json:"suspensionReason"`
+}
+
+//==============================================================================================================================
+//	 Garage Status types
+//==============================================================================================================================
+const   GARAGE_STATUS_APPROVED		= "approved"
+const   GARAGE_STATUS_SUSPENDED		= "suspended"
+
+//Accreditation expiry dates are given as yyyy-mm-dd
+const   ACCREDITATION_DATE_FORMAT	= "2006-01-02"
+
+//=================================================================================================================================
+//	 NewGarage	-	Constructs a new, approved garage
+//=================================================================================================================================
+func NewGarage(id string, name string, address string, location Coordinates, accreditationExpiry string, specialisms []string) (Garage) {
+	var garage Garage
+
+	garage.Id = id
+	garage.Type = "garage"
+
+	garage.Details.Name = name
+	garage.Details.Address = address
+	garage.Details.Location = location
+	garage.Details.AccreditationExpiry = accreditationExpiry
+	garage.Details.Specialisms = specialisms
+	garage.Details.Status = GARAGE_STATUS_APPROVED
+
+	return garage
+}
+
+//=================================================================================================================================
+//	 IsApproved	-	Checks that the garage isn't suspended and that its accreditation hasn't expired at the given time.
+//					The accreditation is valid until the end of the expiry date.
+//=================================================================================================================================
+func (t *Garage) IsApproved(at time.Time) (bool) {
+	if t.Details.Status != GARAGE_STATUS_APPROVED { return false }
+
+	if t.Details.AccreditationExpiry == "" { return true }
+
+	expiry, err := time.Parse(ACCREDITATION_DATE_FORMAT, t.Details.AccreditationExpiry)
+	if err != nil { return false }
+
+	return at.Before(expiry.AddDate(0, 0, 1))
+}
+
+//=================================================================================================================================
+//	 HasSpecialism	-	Checks if the garage has the specialism, ignoring case
+//=================================================================================================================================
+func (t *Garage) HasSpecialism(specialism string) (bool) {
+	for _, aSpecialism := range t.Details.Specialisms {
+		if strings.EqualFold(aSpecialism, specialism) { return true }
+	}
+
+	return false
+}
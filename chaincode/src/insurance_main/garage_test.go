@@ -0,0 +1,69 @@
+//go:build identityinjector && memorystorage
+// +build identityinjector,memorystorage
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	garageOutageStorage - Keeps objects in memory, but fails to retrieve garages
+//==============================================================================================================================
+type garageOutageStorage struct {
+	MemoryStorage
+}
+
+func (t garageOutageStorage) Retrieve(stub shim.ChaincodeStubInterface, key string) ([]byte, error) {
+	if strings.HasPrefix(key, GARAGE_NAMESPACE) { return nil, errors.New("Storage unavailable") }
+
+	return t.MemoryStorage.Retrieve(stub, key)
+}
+
+func TestAddGarage(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_MEMORY)
+	InjectRole("insurer1", ROLE_INSURER)
+
+	stub.nextTx()
+
+	_, err := cc.Invoke(stub, "addGarage", []string{"garage9", "Garage 9", "9 High Street", "-1.5", "52.4", "2030-01-01", "bodywork, electrical"})
+	if err != nil { t.Fatal(err) }
+
+	garage, err := RetrieveGarage(stub, "garage9")
+	if err != nil { t.Fatal(err) }
+
+	if !garage.HasSpecialism("bodywork") || !garage.HasSpecialism("electrical") || len(garage.Details.Specialisms) != 2 {
+		t.Fatalf("Unexpected specialisms: %q", garage.Details.Specialisms)
+	}
+
+	//An existing garage isn't overwritten
+	stub.nextTx()
+
+	_, err = cc.Invoke(stub, "addGarage", []string{"garage9", "Another garage", "1 Low Street", "0", "0", "", ""})
+	if err == nil { t.Fatal("Expected an existing garage to be rejected") }
+
+	garage, _ = RetrieveGarage(stub, "garage9")
+	if garage.Details.Name != "Garage 9" { t.Fatalf("The garage was overwritten: %+v", garage) }
+}
+
+func TestAddGarageFailsWhenExistingGarageCantBeChecked(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_MEMORY)
+	InjectRole("insurer1", ROLE_INSURER)
+
+	storageBackends["garageOutage"] = garageOutageStorage{memoryStorage}
+	t.Cleanup(func() { delete(storageBackends, "garageOutage") })
+
+	stub.PutState(STORAGE_BACKEND_KEY, []byte("garageOutage"))
+	stub.nextTx()
+
+	_, err := cc.Invoke(stub, "addGarage", []string{"garage1", "Replacement", "1 Low Street", "0", "0", "", ""})
+	if err == nil || err.Error() != "Storage unavailable" { t.Fatalf("Expected the storage error, got: %v", err) }
+
+	if garage, _ := memoryStorage.Retrieve(stub, GARAGE_NAMESPACE + "garage1"); strings.Contains(string(garage), "Replacement") {
+		t.Fatal("garage1 was overwritten")
+	}
+}
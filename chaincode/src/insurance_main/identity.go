@@ -0,0 +1,79 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"errors"
+	"fmt"
+)
+
+//==============================================================================================================================
+//	Caller - The identity of the user who invoked or queried the chaincode, read from the attributes of their certificate
+//==============================================================================================================================
+type Caller struct {
+	Username		string
+	Role			string
+	Organisation	string
+	Attributes		map[string]string
+}
+
+//==============================================================================================================================
+//	 Certificate attributes - Every caller must have a username and role.  The organisation is optional.
+//==============================================================================================================================
+const   ATTRIBUTE_USERNAME		= "username"
+const   ATTRIBUTE_ROLE			= "role"
+const   ATTRIBUTE_ORGANISATION	= "organisation"
+
+var REQUIRED_CALLER_ATTRIBUTES = []string{ATTRIBUTE_USERNAME, ATTRIBUTE_ROLE}
+var OPTIONAL_CALLER_ATTRIBUTES = []string{ATTRIBUTE_ORGANISATION}
+
+//Reads the identity of the caller.  Tests built with the identityinjector build tag can replace this to impersonate callers.
+var identityProvider = callerFromCertificate
+
+//=================================================================================================================================
+//	 GetCaller	-	Gets the identity of the caller, or an error if the caller is missing a username or role.  Checked for
+//					every identity provider, so that an injected caller can't skip the required attributes.
+//=================================================================================================================================
+func GetCaller(stub shim.ChaincodeStubInterface) (Caller, error) {
+	caller, err := identityProvider(stub)
+	if err != nil { return Caller{}, err }
+
+	if caller.Username == "" { return Caller{}, unidentifiedCaller(ATTRIBUTE_USERNAME) }
+	if caller.Role == "" { return Caller{}, unidentifiedCaller(ATTRIBUTE_ROLE) }
+
+	return caller, nil
+}
+
+func unidentifiedCaller(attribute string) (error) {
+	return errors.New("UNIDENTIFIED_CALLER: Caller certificate is missing the attribute '" + attribute + "'")
+}
+
+func callerFromCertificate(stub shim.ChaincodeStubInterface) (Caller, error) {
+	var caller Caller
+
+	caller.Attributes = map[string]string{}
+
+	for _, name := range REQUIRED_CALLER_ATTRIBUTES {
+		value, err := stub.ReadCertAttribute(name)
+
+		if err != nil || len(value) == 0 {
+			fmt.Printf("GetCaller: Couldn't get attribute '%s'. Error: %s", name, err)
+			return Caller{}, unidentifiedCaller(name)
+		}
+
+		caller.Attributes[name] = string(value)
+	}
+
+	for _, name := range OPTIONAL_CALLER_ATTRIBUTES {
+		value, err := stub.ReadCertAttribute(name)
+
+		if err == nil && len(value) > 0 { caller.Attributes[name] = string(value) }
+	}
+
+	caller.Username = caller.Attributes[ATTRIBUTE_USERNAME]
+	caller.Role = caller.Attributes[ATTRIBUTE_ROLE]
+	caller.Organisation = caller.Attributes[ATTRIBUTE_ORGANISATION]
+
+	fmt.Printf("caller_data: %s %s", caller.Username, caller.Role)
+
+	return caller, nil
+}
@@ -0,0 +1,40 @@
+//go:build identityinjector
+// +build identityinjector
+
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Identity injector - Only built with the identityinjector build tag, so that tests can impersonate any caller without
+//	 a certificate.  Must never be included in a deployed chaincode.
+//==============================================================================================================================
+
+//=================================================================================================================================
+//	 InjectCaller	-	Makes every following invoke and query run as the given caller
+//=================================================================================================================================
+func InjectCaller(caller Caller) {
+	if caller.Attributes == nil {
+		caller.Attributes = map[string]string{ATTRIBUTE_USERNAME: caller.Username, ATTRIBUTE_ROLE: caller.Role}
+	}
+
+	identityProvider = func(stub shim.ChaincodeStubInterface) (Caller, error) {
+		return caller, nil
+	}
+}
+
+//=================================================================================================================================
+//	 InjectRole	-	Makes every following invoke and query run as a caller with the given username and role
+//=================================================================================================================================
+func InjectRole(username string, role string) {
+	InjectCaller(Caller{Username: username, Role: role})
+}
+
+//=================================================================================================================================
+//	 ResetCaller	-	Reads the caller from the certificate again
+//=================================================================================================================================
+func ResetCaller() {
+	identityProvider = callerFromCertificate
+}
@@ -0,0 +1,96 @@
+//go:build identityinjector
+// +build identityinjector
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func init() {
+	testGlobalResets = append(testGlobalResets, ResetCaller)
+}
+
+func countPolicies(stub *testStub) (int) {
+	count := 0
+
+	for key := range stub.state {
+		if strings.HasPrefix(key, POLICY_NAMESPACE) { count++ }
+	}
+
+	return count
+}
+
+func TestCallersMissingUsernameOrRoleAreRejected(t *testing.T) {
+	tests := []struct {
+		name			string
+		inject			*Caller
+		attributes		map[string]string
+	}{
+		{"injected caller without a username",		&Caller{Role: ROLE_INSURER},			nil},
+		{"injected caller without a role",			&Caller{Username: "insurer1"},		nil},
+		{"certificate without a username",			nil,	map[string]string{ATTRIBUTE_ROLE: ROLE_INSURER}},
+		{"certificate without a role",				nil,	map[string]string{ATTRIBUTE_USERNAME: "insurer1"}},
+		{"certificate with an empty role",			nil,	map[string]string{ATTRIBUTE_USERNAME: "insurer1", ATTRIBUTE_ROLE: ""}},
+		{"certificate without any attributes",		nil,	map[string]string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cc, stub := newTestChaincode(t, STORAGE_BACKEND_STATE)
+
+			if test.inject != nil { InjectCaller(*test.inject) }
+			stub.attributes = test.attributes
+
+			policies := countPolicies(stub)
+
+			stub.nextTx()
+
+			_, err := cc.Invoke(stub, "addPolicy", []string{"claimant1", "2017-01-01", "2018-01-01", "100", "BP08BRV"})
+			if err == nil || !strings.HasPrefix(err.Error(), "UNIDENTIFIED_CALLER") { t.Fatalf("Expected the invoke to be rejected, got: %v", err) }
+
+			if countPolicies(stub) != policies { t.Fatal("A policy was added by an unidentified caller") }
+
+			_, err = cc.Query(stub, "retrievePolicy", []string{"P1"})
+			if err == nil || !strings.HasPrefix(err.Error(), "UNIDENTIFIED_CALLER") { t.Fatalf("Expected the query to be rejected, got: %v", err) }
+		})
+	}
+}
+
+func TestCertificateCallerIsAccepted(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_STATE)
+
+	stub.attributes = map[string]string{ATTRIBUTE_USERNAME: "insurer1", ATTRIBUTE_ROLE: ROLE_INSURER}
+
+	_, err := cc.Query(stub, "retrievePolicy", []string{"P1"})
+	if err != nil { t.Fatal(err) }
+}
+
+func TestInjectedRoleReachesCheckPermission(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_STATE)
+
+	//The certificate would allow the invoke, so only the injected role can cause it to be refused
+	stub.attributes = map[string]string{ATTRIBUTE_USERNAME: "insurer1", ATTRIBUTE_ROLE: ROLE_INSURER}
+
+	InjectRole("insurer1", ROLE_POLICY_HOLDER)
+
+	_, err := cc.Invoke(stub, "addPolicy", []string{"claimant1", "2017-01-01", "2018-01-01", "100", "BP08BRV"})
+	if err == nil || !strings.Contains(err.Error(), "NOT_PERMITTED: addPolicy is not permitted for role " + ROLE_POLICY_HOLDER) {
+		t.Fatalf("Expected addPolicy to be refused for the injected role, got: %v", err)
+	}
+
+	_, err = cc.Query(stub, "retrievePolicySummaryByRegistration", []string{"BP08BRV"})
+	if err == nil || !strings.Contains(err.Error(), "NOT_PERMITTED") { t.Fatalf("Expected the query to be refused for the injected role, got: %v", err) }
+
+	//The certificate is ignored in favour of the injected caller, so a certificate without attributes makes no difference
+	stub.attributes = map[string]string{}
+	InjectRole("insurer1", ROLE_INSURER)
+	stub.nextTx()
+
+	_, err = cc.Invoke(stub, "addPolicy", []string{"claimant1", "2017-01-01", "2018-01-01", "100", "BP08BRV"})
+	if err != nil { t.Fatal(err) }
+
+	_, err = cc.Query(stub, "retrievePolicySummaryByRegistration", []string{"BP08BRV"})
+	if err != nil { t.Fatal(err) }
+}
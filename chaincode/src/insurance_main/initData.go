@@ -4,25 +4,64 @@ import (
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 )
 
+//=================================================================================================================================
+//	 InitReferenceData	-	Adds the reference policies, vehicles, users and garages.  Each may have been changed since the
+//							chaincode was first initialised, such as claims being added to a policy or a garage being
+//							suspended, so only missing objects are added.
+//=================================================================================================================================
 func InitReferenceData(stub shim.ChaincodeStubInterface) (error) {
-	_, err := SavePolicy(stub, NewPolicy("P1", "claimant1", "insurer1", "31/01/17", "30/01/18", 300, "BP08BRV"))
-	if err != nil{ return err }
-	_, err = SavePolicy(stub, NewPolicy("P2", "claimant2", "insurer2", "11/01/17", "10/01/18", 250, "DZ14TYV"))
-	if err != nil{ return err }
-
-	_, err = SaveVehicle(stub, NewVehicle("BP08BRV", "Ford", "Focus", "2008", 55000, "100924404"))
-	if err != nil{ return err }
-	_, err = SaveVehicle(stub, NewVehicle("DZ14TYV", "Audi", "TT", "2014", 20000, "200481078"))
-	if err != nil{ return err }
-
-	_, err = SaveUser(stub, NewUser("U1", "John", "Hancock", "john.hancock@outlook.com", "P1"))
-	if err != nil{ return err }
-	_, err = SaveUser(stub, NewUser("U2", "Jane", "Doe", "jane.doe@outlook.com", "P2"))
-	if err != nil{ return err }
-
-	var approvedGarages ApprovedGarages
-	approvedGarages.Garages = []string{"garage1", "garage2", "garage3"}
-	_, err = SaveApprovedGarages(stub, approvedGarages)
-
-	return err
+	policies := []Policy{
+		NewPolicy("P1", "claimant1", "insurer1", "31/01/17", "30/01/18", 300, "BP08BRV"),
+		NewPolicy("P2", "claimant2", "insurer2", "11/01/17", "10/01/18", 250, "DZ14TYV"),
+	}
+
+	for _, policy := range policies {
+		_, err := RetrievePolicy(stub, policy.Id)
+		if !IsNotFoundError(err) { continue }
+
+		_, err = SavePolicy(stub, policy)
+		if err != nil{ return err }
+	}
+
+	vehicles := []Vehicle{
+		NewVehicle("BP08BRV", "Ford", "Focus", "2008", 55000, "100924404"),
+		NewVehicle("DZ14TYV", "Audi", "TT", "2014", 20000, "200481078"),
+	}
+
+	for _, vehicle := range vehicles {
+		_, err := RetrieveVehicle(stub, vehicle.Id)
+		if !IsNotFoundError(err) { continue }
+
+		_, err = SaveVehicle(stub, vehicle)
+		if err != nil{ return err }
+	}
+
+	users := []User{
+		NewUser("U1", "John", "Hancock", "john.hancock@outlook.com", "P1"),
+		NewUser("U2", "Jane", "Doe", "jane.doe@outlook.com", "P2"),
+	}
+
+	for _, user := range users {
+		_, err := RetrieveUser(stub, user.Id)
+		if !IsNotFoundError(err) { continue }
+
+		_, err = SaveUser(stub, user)
+		if err != nil{ return err }
+	}
+
+	garages := []Garage{
+		NewGarage("garage1", "Harbourside Motors", "12 Quay Street, Bristol", Coordinates{-2.5879, 51.4545}, "", []string{"bodywork", "mechanical"}),
+		NewGarage("garage2", "Northgate Autos", "4 Northgate, Chester", Coordinates{-2.8931, 53.1934}, "", []string{"mechanical", "electrical"}),
+		NewGarage("garage3", "Riverside Repairs", "88 River Road, Leeds", Coordinates{-1.5491, 53.8008}, "", []string{"bodywork", "glass"}),
+	}
+
+	for _, garage := range garages {
+		_, err := RetrieveGarage(stub, garage.Id)
+		if !IsNotFoundError(err) { continue }
+
+		_, err = SaveGarage(stub, garage)
+		if err != nil{ return err }
+	}
+
+	return nil
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestInitKeepsChangedReferenceData(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_STATE)
+
+	//Change a policy, vehicle and user as later invokes could
+	stub.nextTx()
+	OpenTxContext(stub, "test", "insurer1", ROLE_INSURER)
+
+	policy, _ := RetrievePolicy(stub, "P1")
+	policy.Relations.Claims = append(policy.Relations.Claims, "C1")
+	SavePolicy(stub, policy)
+
+	vehicle, _ := RetrieveVehicle(stub, "BP08BRV")
+	vehicle.Details.Mileage = 60000
+	SaveVehicle(stub, vehicle)
+
+	user, _ := RetrieveUser(stub, "U1")
+	user.Details.Email = "john.hancock@example.com"
+	SaveUser(stub, user)
+
+	CloseTxContext(stub)
+
+	stub.nextTx()
+
+	_, err := cc.Init(stub, "init", []string{})
+	if err != nil { t.Fatal(err) }
+
+	policy, err = RetrievePolicy(stub, "P1")
+	if err != nil { t.Fatal(err) }
+
+	if len(policy.Relations.Claims) != 1 { t.Fatalf("The claims of policy P1 were lost on Init: %+v", policy.Relations) }
+
+	vehicle, _ = RetrieveVehicle(stub, "BP08BRV")
+	if vehicle.Details.Mileage != 60000 { t.Fatalf("Vehicle BP08BRV was overwritten on Init: %+v", vehicle.Details) }
+
+	user, _ = RetrieveUser(stub, "U1")
+	if user.Details.Email != "john.hancock@example.com" { t.Fatalf("User U1 was overwritten on Init: %+v", user.Details) }
+
+	//Missing reference data is still added
+	_, err = RetrievePolicy(stub, "P2")
+	if err != nil { t.Fatal(err) }
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"strconv"
+	"strings"
+	"time"
 	"encoding/json"
 )
 
@@ -12,11 +14,13 @@ import (
 type InsuranceChaincode struct {}
 
 //==============================================================================================================================
-//	PoliceReport - Defines the structure for a PoliceReport object.
+//	PoliceReport - Defines the structure for a PoliceReport object.  The report names the registrations of the vehicles
+//		whose drivers were at fault.  DriverAtFault is set for the claim the report is held against.
 //==============================================================================================================================
 type PoliceReport struct {
 	Description         string          `json:"description"`
 	Location            Coordinates     `json:"coordinates"`
+	AtFaultRegs         []string        `json:"at_fault_regs"`
 	DriverAtFault       bool            `json:"driver_at_fault"`
 }
 
@@ -24,8 +28,62 @@ type PoliceReport struct {
 //	Coordinates - Defines the structure for a Coordinates object.
 //==============================================================================================================================
 type Coordinates struct {
-	x float32 `json:"x"`
-	y float32 `json:"y"`
+	X float32 `json:"x"`
+	Y float32 `json:"y"`
+}
+
+//================================================================================================================================================
+//  NewPoliceReport - Creates a new PoliceReport
+//================================================================================================================================================
+func NewPoliceReport(description string, xStr string, yStr string, atFaultRegs []string) (PoliceReport, error) {
+
+	var report PoliceReport
+
+	x, err := strconv.ParseFloat(xStr, 32)
+	if err != nil {fmt.Printf("\nNewPoliceReport Error: invalid value passed for x coordinate: %s", err); return report, errors.New("Invalid value passed for x coordinate")}
+
+	y, err := strconv.ParseFloat(yStr, 32)
+	if err != nil {fmt.Printf("\nNewPoliceReport Error: invalid value passed for y coordinate: %s", err); return report, errors.New("Invalid value passed for y coordinate")}
+
+	report.Description = description
+	report.Location.X = float32(x)
+	report.Location.Y = float32(y)
+	report.AtFaultRegs = atFaultRegs
+
+	return report, nil
+}
+
+//================================================================================================================================================
+//  LiabilityShares - Splits liability equally between the parties whose registrations the report names as at fault.
+//		partyRegs holds the registration of each party to the claim, and a share is returned for each of them.  Every
+//		registration named must be one of the parties.  Only a single party claim can have no driver at fault, otherwise the
+//		shares would not add up to 100%.
+//================================================================================================================================================
+func (t *PoliceReport) LiabilityShares(partyRegs []string) ([]int, error) {
+	for _, atFaultReg := range t.AtFaultRegs {
+		if indexOf(partyRegs, atFaultReg) < 0 { return nil, errors.New("The police report names a vehicle that is not party to the claim: " + atFaultReg) }
+	}
+
+	var atFaultParties []int
+	for index, partyReg := range partyRegs {
+		if indexOf(t.AtFaultRegs, partyReg) >= 0 { atFaultParties = append(atFaultParties, index) }
+	}
+
+	shares := make([]int, len(partyRegs))
+
+	if len(atFaultParties) == 0 {
+		if len(partyRegs) > 1 { return nil, errors.New("The police report must name the registration of at least one driver at fault") }
+
+		return shares, nil
+	}
+
+	atFaultShares := splitLiabilityShare(FULL_LIABILITY, len(atFaultParties))
+
+	for index, party := range atFaultParties {
+		shares[party] = atFaultShares[index]
+	}
+
+	return shares, nil
 }
 
 //==============================================================================================================================
@@ -36,6 +94,7 @@ const	ROLE_GARAGE			= "garage"
 const   ROLE_INSURER		= "insurer"
 const   ROLE_SUPER_USER		= "superuser"
 const   ROLE_ORACLE			= "oracle"
+const   ROLE_POLICE			= "police"
 
 func main() {
 	err := shim.Start(new(InsuranceChaincode))
@@ -46,43 +105,112 @@ func main() {
 
 func (t *InsuranceChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 
-	InitDao(stub, args[0])
+	//The CRUD chaincodeId is given as the first argument, the token this chaincode was registered with in the CRUD chaincode
+	//as the third, and the storage backend as the fourth.  Any that are missing are kept from the last Init.
+	crudChaincodeId := ""
+	if len(args) >= 1 { crudChaincodeId = args[0] }
+
+	crudToken := ""
+	if len(args) >= 3 { crudToken = args[2] }
+
+	storageBackend := ""
+	if len(args) >= 4 { storageBackend = args[3] }
+
+	//Hold all writes until the end of the transaction as Invoke does, unless Init is called by the init invoke, which
+	//already has a transaction context and flushes the writes itself
+	ownsTxContext := !GetTxContext(stub).IsOpen
+
+	if ownsTxContext {
+		OpenTxContext(stub, function, "", "")
+		defer CloseTxContext(stub)
+	}
+
+	err := InitDao(stub, crudChaincodeId, crudToken, storageBackend)
+	if err != nil { return nil, err }
+
+	err = InitPermissions(stub)
+	if err != nil { return nil, err }
+
 	InitOracleService(stub, args)
 	InitReferenceData(stub)
 
-	return nil, nil
+	if !ownsTxContext { return nil, nil }
+
+	return nil, FlushPendingWrites(stub)
 }
 
 // Invoke is the entry point to invoke a chaincode function
 func (t *InsuranceChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	fmt.Println("invoke is running " + function)
 
-	caller, caller_affiliation, _ := t.get_caller_data(stub)
+	caller, caller_affiliation, err := t.get_caller_data(stub)
+	if err != nil { fmt.Printf("Rejecting %s: %s", function, err); return nil, err }
+
+	//Make the caller available to the claim history, hold all writes until the end of the transaction and cache reads
+	OpenTxContext(stub, function, caller, caller_affiliation)
+	defer CloseTxContext(stub)
+
+	err = t.CheckPermission(stub, function, caller, caller_affiliation, args)
+	if err != nil { return nil, err }
+
+	result, err := t.invokeFunction(stub, function, caller, caller_affiliation, args)
+	if err != nil { return nil, err }
+
+	//Send everything written during the transaction to the crud chaincode in one batch
+	err = FlushPendingWrites(stub)
+
+	return result, err
+}
+
+func (t *InsuranceChaincode) invokeFunction(stub shim.ChaincodeStubInterface, function string, caller string, caller_affiliation string, args []string) ([]byte, error) {
 
 	// Handle different functions
 	if function == "init" {
 		return t.Init(stub, "init", args)
 	} else if function == "addPolicy" {
 		return t.addPolicy(stub, caller, caller_affiliation, args)
+	} else if function == "addVehicle" {
+		return t.addVehicle(stub, caller, caller_affiliation, args)
+	} else if function == "addUser" {
+		return t.addUser(stub, caller, caller_affiliation, args)
 	} else if function == "createClaim" {
 		return t.createClaim(stub, caller, caller_affiliation, args)
 	} else if function == "declareLiability" {
 		return t.declareLiability(stub, caller, caller_affiliation, args)
+	} else if function == "submitLiabilityEvidence" {
+		return t.submitLiabilityEvidence(stub, caller, caller_affiliation, args)
+	} else if function == "resolveLiabilityDispute" {
+		return t.resolveLiabilityDispute(stub, caller, caller_affiliation, args)
+	} else if function == "updateThirdParty" {
+		return t.updateThirdParty(stub, caller, caller_affiliation, args)
 	} else if function == "addPoliceReport" {
-		//TODO
+		return t.addPoliceReport(stub, caller, caller_affiliation, args)
+	} else if function == ACTION_ASSIGN_GARAGE {
+		return t.assignGarage(stub, caller, caller_affiliation, args)
 	} else if function == "addGarageReport" {
 		return t.addGarageReport(stub, caller, caller_affiliation, args)
+	} else if function == "orderGarageWork" {
+		return t.orderGarageWork(stub, caller, caller_affiliation, args)
 	} else if function == "confirmWork" {
-		//TODO
+		return t.confirmWork(stub, caller, caller_affiliation, args)
 	} else if function == "agreePayoutAmount" {
 		return t.agreePayoutAmount(stub, caller, caller_affiliation, args)
 	} else if function == "vehicleValueOracleCallback" {
-		fmt.Printf("vehicleValueOracleCallback: ReqeustId / Value" + args[0] + " / " + args[1]);
 		return t.vehicleValueOracleCallback(stub, caller, caller_affiliation, args)
 	} else if function == "confirmPaidOut" {
 		return t.confirmPaidOut(stub, caller, caller_affiliation, args)
 	} else if function == "closeClaim" {
 		return t.closeClaim(stub, caller, caller_affiliation, args)
+	} else if function == "addGarage" {
+		return t.addGarage(stub, caller, caller_affiliation, args)
+	} else if function == "suspendGarage" {
+		return t.suspendGarage(stub, caller, caller_affiliation, args)
+	} else if function == "reinstateGarage" {
+		return t.reinstateGarage(stub, caller, caller_affiliation, args)
+	} else if function == "removeGarage" {
+		return t.removeGarage(stub, caller, caller_affiliation, args)
+	} else if function == FUNCTION_SET_PERMISSION {
+		return t.setPermission(stub, caller, caller_affiliation, args)
 	}
 	fmt.Println("invoke did not find func: " + function)
 
@@ -93,13 +221,41 @@ func (t *InsuranceChaincode) Invoke(stub shim.ChaincodeStubInterface, function s
 func (t *InsuranceChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	fmt.Println("query is running " + function)
 
-	caller, caller_affiliation, _ := t.get_caller_data(stub)
+	caller, caller_affiliation, err := t.get_caller_data(stub)
+	if err != nil { fmt.Printf("Rejecting %s: %s", function, err); return nil, err }
+
+	//Cache everything read during the query, so that objects needed more than once are only retrieved once
+	OpenTxContext(stub, function, caller, caller_affiliation)
+	defer CloseTxContext(stub)
+
+	err = t.CheckPermission(stub, function, caller, caller_affiliation, args)
+	if err != nil { return nil, err }
 
 	// Handle different functions
 	if function == "retrieveAllPolicies" {
 		return t.retrieveAllPoliciesJSON(stub, caller, caller_affiliation)
 	} else if function == "retrieveAllClaims" {
 		return t.retrieveAllClaimsJSON(stub, caller, caller_affiliation)
+	} else if function == "retrieveClaim" {
+		return t.retrieveClaimJSON(stub, caller, caller_affiliation, args)
+	} else if function == "retrievePolicy" {
+		return t.retrievePolicyJSON(stub, caller, caller_affiliation, args)
+	} else if function == "retrievePolicySummaryByRegistration" {
+		return t.retrievePolicySummaryByRegistrationJSON(stub, args)
+	} else if function == "retrieveVehicle" {
+		return t.retrieveVehicleJSON(stub, caller, caller_affiliation, args)
+	} else if function == "retrieveUser" {
+		return t.retrieveUserJSON(stub, caller, caller_affiliation, args)
+	} else if function == "retrieveClaimHistory" {
+		return t.retrieveClaimHistoryJSON(stub, caller, caller_affiliation, args)
+	} else if function == "retrieveClaimStateMachine" {
+		return t.retrieveClaimStateMachineJSON(stub)
+	} else if function == "retrieveAllowedClaimActions" {
+		return t.retrieveAllowedClaimActionsJSON(stub, caller, caller_affiliation, args)
+	} else if function == "retrieveGarages" {
+		return t.retrieveGaragesJSON(stub, caller, caller_affiliation, args)
+	} else if function == FUNCTION_RETRIEVE_PERMISSIONS {
+		return t.retrievePermissionsJSON(stub)
 	}
 	fmt.Println("query did not find func: " + function)
 
@@ -118,14 +274,16 @@ func (t *InsuranceChaincode) addPolicy(stub shim.ChaincodeStubInterface, caller
 		return nil, errors.New("Incorrect number of arguments. Expecting 5 (Owner,ActivationDate,ExpiryDate,Excess,VehicleReg)")
 	}
 
-	if caller_affiliation != ROLE_INSURER {
-		return nil, errors.New("Only an insurer can add a new policy")
-	}
+	excess, err := strconv.Atoi(args[3])
+	if err != nil {fmt.Printf("addPolicy: Invalid excess: %s", err); return nil, errors.New("Invalid value passed for excess")}
+
+	//The vehicle must be added before it can be insured
+	_, err = RetrieveVehicle(stub, args[4])
+	if err != nil {fmt.Printf("addPolicy: Unable to retrieve vehicle: %s", err); return nil, errors.New("Vehicle does not exist: " + args[4])}
 
-	excess, _ := strconv.Atoi(args[3])
 	policy := NewPolicy("", args[0], caller, args[1], args[2], excess, args[4])
 
-	_, err := SavePolicy(stub, policy)
+	_, err = SavePolicy(stub, policy)
 
 	return nil, err
 }
@@ -142,10 +300,21 @@ func (t *InsuranceChaincode) addVehicle(stub shim.ChaincodeStubInterface, caller
 		return nil, errors.New("Incorrect number of arguments. Expecting 6 (Make, Model, Registration, Year, Mileage, StyleId)")
 	}
 
-	mileage, _ := strconv.Atoi(args[4])
+	if args[2] == "" || args[2] == UNKNOWN_REGISTRATION {
+		return nil, errors.New("Invalid registration: " + args[2])
+	}
+
+	//Vehicles are keyed by registration, so don't overwrite an existing vehicle
+	_, err := RetrieveVehicle(stub, args[2])
+	if err == nil { return nil, errors.New("Vehicle already exists: " + args[2]) }
+	if !IsNotFoundError(err) { fmt.Printf("addVehicle: Unable to check for an existing vehicle: %s", err); return nil, err }
+
+	mileage, err := strconv.Atoi(args[4])
+	if err != nil {fmt.Printf("addVehicle: Invalid mileage: %s", err); return nil, errors.New("Invalid value passed for mileage")}
+
 	vehicle := NewVehicle(args[2], args[0], args[1], args[3], mileage, args[5])
 
-	_, err := SaveVehicle(stub, vehicle)
+	_, err = SaveVehicle(stub, vehicle)
 
 	return nil, err
 }
@@ -162,16 +331,27 @@ func (t *InsuranceChaincode) addUser(stub shim.ChaincodeStubInterface, caller st
 		return nil, errors.New("Incorrect number of arguments. Expecting 4 (Forename, Surname, Email, RelatedPolicy)")
 	}
 
+	//Users are linked to a policy, which must be held by the insurer adding the user
+	policy, err := RetrievePolicy(stub, args[3])
+	if err != nil {fmt.Printf("addUser: Unable to retrieve policy: %s", err); return nil, errors.New("Policy does not exist: " + args[3])}
+
+	if caller_affiliation == ROLE_INSURER && policy.Relations.Insurer != caller {
+		return nil, errors.New("Caller is not the insurer of the policy: " + args[3])
+	}
+
 	user := NewUser("", args[0], args[1], args[2], args[3])
 
-	_, err := SaveUser(stub, user)
+	_, err = SaveUser(stub, user)
 
 	return nil, err
 }
 
 //=================================================================================================================================
 //	 createClaim - Creates a Claim object and then saves it to the ledger.
-//          args - RelatedPolicy,Description,Date,IncidentType,OtherPartyReg(Optional),liable(Optional)
+//          args - RelatedPolicy,Description,Date,IncidentType,OtherPartyRegs(Multiple parties only),liable(Multiple parties only),
+//                 policeReportRequired(Optional)
+//          OtherPartyRegs - comma separated list of the registrations of the other vehicles involved
+//          liable - true/false, or the percentage of liability accepted by the claimant
 //=================================================================================================================================
 func (t *InsuranceChaincode) createClaim(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
 
@@ -190,71 +370,268 @@ func (t *InsuranceChaincode) createClaim(stub shim.ChaincodeStubInterface, calle
 		return nil, errors.New("Claim is invalid");
 	}
 
+	//The police report flag follows the incident type specific arguments
+	policeReportArgIndex := 4
+	if (claim.Details.Incident.Type == MULTIPLE_PARTIES) { policeReportArgIndex = 6 }
+
+	if len(args) > policeReportArgIndex {
+		policeReportRequired, err := strconv.ParseBool(args[policeReportArgIndex])
+		if err != nil {fmt.Printf("createClaim: Unable to parse boolean policeReportRequired: %s", err); return nil, errors.New("Invalid value passed for policeReportRequired")}
+
+		claim.Details.Incident.PoliceReportRequired = policeReportRequired
+	}
+
 	if (claim.Details.Incident.Type == SINGLE_PARTY) {
 		claim.Details.Status = STATE_AWAITING_GARAGE_REPORT
+		if claim.Details.Incident.PoliceReportRequired { claim.Details.Status = STATE_AWAITING_POLICE_REPORT }
+
 		_, err = SaveClaim(stub, claim)
 
 		return nil, err
 	} else if (claim.Details.Incident.Type == MULTIPLE_PARTIES) {
-		isLiable, err := strconv.ParseBool(args[5])
+		if len(args) < 6 {
+			return nil, errors.New("Incorrect number of arguments. Expecting (RelatedPolicy,Description, Date, IncidentType, OtherPartyRegs, liable) for a multiple party claim")
+		}
+
+		liabilityShare, err := ParseLiabilityShare(args[5])
 		if err != nil { return nil, err }
-		return t.processMultiplePartyClaimCreation(stub, claim, args[4], isLiable)
+		return t.processMultiplePartyClaimCreation(stub, claim, parseCommaList(args[4]), liabilityShare)
 	} else {
 		return nil, errors.New("Unsupported claim type: " + claim.Details.Incident.Type)
 	}
 }
 
 //=================================================================================================================================
-//	 processMultiplePartyClaimCreation - Performs additional processing required when creating a multiple party claim
+//	 processMultiplePartyClaimCreation - Performs additional processing required when creating a multiple party claim.
+//   A claim is created for each of the other parties with a policy on the ledger, and a third party record is created for
+//   any other vehicle.  All of the claims and third parties are linked with each other.
 //=================================================================================================================================
-func (t *InsuranceChaincode) processMultiplePartyClaimCreation(stub shim.ChaincodeStubInterface, claim Claim, otherPartyReg string, liable bool) ([]byte, error) {
-	//Set reg and liable
-	claim.Relations.OtherPartyReg = otherPartyReg
-	claim.Details.IsLiable = liable
+func (t *InsuranceChaincode) processMultiplePartyClaimCreation(stub shim.ChaincodeStubInterface, claim Claim, otherPartyRegs []string, liabilityShare int) ([]byte, error) {
+
+	policy, err := RetrievePolicy(stub, claim.Relations.RelatedPolicy)
+	if err != nil { return nil, err }
+
+	if len(otherPartyRegs) == 0 { return nil, errors.New("At least one other party registration is required for a multiple party claim") }
+
+	//Get the other party policies before saving anything, so that nothing is saved if the claim is invalid
+	var otherPartyPolicies []Policy
+	var thirdPartyRegs []string
+	for index, otherPartyReg := range otherPartyRegs {
+		if otherPartyReg == UNKNOWN_REGISTRATION {
+			thirdPartyRegs = append(thirdPartyRegs, otherPartyReg)
+			continue
+		}
+
+		if otherPartyReg == policy.Relations.Vehicle || indexOf(otherPartyRegs, otherPartyReg) != index {
+			return nil, errors.New("Duplicate registration for multiple party claim: " + otherPartyReg)
+		}
+
+		otherPartyPolicy, err := t.findPolicyWithVehicleReg(stub, otherPartyReg)
+
+		if err != nil {
+			//Not on the ledger, so the vehicle will be recorded as a third party
+			fmt.Println("No policy for registration, adding as third party: " + otherPartyReg)
+			thirdPartyRegs = append(thirdPartyRegs, otherPartyReg)
+		} else {
+			otherPartyPolicies = append(otherPartyPolicies, otherPartyPolicy)
+		}
+	}
 
-	var status = STATE_AWAITING_LIABILITY_ACCEPTANCE
+	//Start with the remaining liability split between the other parties, subject to dispute
+	otherPartyShares := splitLiabilityShare(FULL_LIABILITY - liabilityShare, len(otherPartyPolicies) + len(thirdPartyRegs))
 
-	//If raising party accepts they're liable initially, then skip the acceptance step
-	if liable {
-		status = STATE_AWAITING_GARAGE_REPORT
+	var status = STATE_AWAITING_GARAGE_REPORT
+
+	//Any other party with a claim on the ledger needs to accept their share of the liability. Third parties can't,
+	//so the claimant's insurer will pursue them for their share
+	for index := range otherPartyPolicies {
+		if otherPartyShares[index] > 0 { status = STATE_AWAITING_LIABILITY_ACCEPTANCE }
+	}
+
+	//Liability will be taken from the police report once it arrives
+	if claim.Details.Incident.PoliceReportRequired {
+		status = STATE_AWAITING_POLICE_REPORT
 	}
 
+	//Set regs and liability
+	claim.Relations.OtherPartyRegs = otherPartyRegs
+	claim.Details.LiabilityShare = liabilityShare
+	claim.Details.LiabilityAccepted = true
 	claim.Details.Status = status;
 
 	//Save claim so that id is generated
-	claim, err := SaveClaim(stub, claim)
+	claim, err = SaveClaim(stub, claim)
 	if err != nil { return nil, err}
 
-	policy, _ := RetrievePolicy(stub, claim.Relations.RelatedPolicy)
-	//Get the other party policy
-	otherPartyPolicy, err := t.findPolicyWithVehicleReg(stub, otherPartyReg)
+	claims := []Claim{claim}
 
-	if err != nil {
-		//TODO: what should we do here???
-		return nil, err
+	//Create claim for each other party
+	for index, otherPartyPolicy := range otherPartyPolicies {
+		otherClaim := NewClaim("", otherPartyPolicy.Id, claim.Details.Description, claim.Details.Incident.Date, MULTIPLE_PARTIES)
+		otherClaim.Details.Status = status
+		otherClaim.Details.Incident.PoliceReportRequired = claim.Details.Incident.PoliceReportRequired
+		otherClaim.Details.LiabilityShare = otherPartyShares[index]
+
+		//Parties without any share of the liability have nothing to accept
+		otherClaim.Details.LiabilityAccepted = otherClaim.Details.LiabilityShare == 0
+
+		//The other party regs are every other vehicle in the accident
+		otherClaim.Relations.OtherPartyRegs = []string{policy.Relations.Vehicle}
+		for _, otherPartyReg := range otherPartyRegs {
+			if otherPartyReg != otherPartyPolicy.Relations.Vehicle {
+				otherClaim.Relations.OtherPartyRegs = append(otherClaim.Relations.OtherPartyRegs, otherPartyReg)
+			}
+		}
+
+		savedClaim, err := SaveClaim(stub, otherClaim)
+		if err != nil { return nil, err}
+
+		claims = append(claims, savedClaim)
 	}
 
-	//Create claim for other party
-	otherClaim := NewClaim("", otherPartyPolicy.Id, claim.Details.Description, claim.Details.Incident.Date, MULTIPLE_PARTIES)
-	otherClaim.Details.Status = status
-	otherClaim.Relations.OtherPartyReg = policy.Relations.Vehicle
+	var claimIds []string
+	for _, aClaim := range claims { claimIds = append(claimIds, aClaim.Id) }
 
-	//Start as the opposite of the initial claim, subject to dispute
-	otherClaim.Details.IsLiable = !liable
+	//Create a third party for each vehicle not on the ledger
+	var thirdPartyIds []string
+	for index, thirdPartyReg := range thirdPartyRegs {
+		category := THIRD_PARTY_UNINSURED
+		if thirdPartyReg == UNKNOWN_REGISTRATION { category = THIRD_PARTY_HIT_AND_RUN }
 
-	//Link with initial claim
-	otherClaim.Relations.LinkedClaims = append(otherClaim.Relations.LinkedClaims, claim.Id)
-	savedClaim, err := SaveClaim(stub, otherClaim)
-	if err != nil { return nil, err}
+		thirdParty := NewThirdParty("", thirdPartyReg, category, "")
+		thirdParty.Details.LiabilityShare = otherPartyShares[len(otherPartyPolicies) + index]
+		thirdParty.Relations.LinkedClaims = claimIds
+
+		savedThirdParty, err := SaveThirdParty(stub, thirdParty)
+		if err != nil { return nil, err}
+
+		thirdPartyIds = append(thirdPartyIds, savedThirdParty.Id)
+	}
+
+	//Finally link every claim with all of the other claims and third parties and save again
+	for _, aClaim := range claims {
+		for _, otherClaim := range claims {
+			if otherClaim.Id != aClaim.Id {
+				aClaim.Relations.LinkedClaims = append(aClaim.Relations.LinkedClaims, otherClaim.Id)
+			}
+		}
+
+		aClaim.Relations.ThirdParties = append(aClaim.Relations.ThirdParties, thirdPartyIds...)
+
+		_, err = SaveClaim(stub, aClaim)
+		if err != nil { return nil, err}
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 updateThirdParty - Called by an insurer of one of the linked claims to correct the details of a third party, for example
+//   when the third party turns out to be insured by an insurer that isn't on the ledger
+//          args - thirdPartyId, category, externalInsurer
+//=================================================================================================================================
+func (t *InsuranceChaincode) updateThirdParty(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
 
-	//Finally link saved 'other party' claim with the original claim and save again
-	claim.Relations.LinkedClaims = append(claim.Relations.LinkedClaims, savedClaim.Id)
+	fmt.Println("running updateThirdParty()")
 
-	_, err = SaveClaim(stub, claim)
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 (ThirdPartyId, Category, ExternalInsurer)")
+	}
+
+	if !IsValidThirdPartyCategory(args[1]) {
+		return nil, errors.New("Invalid third party category: " + args[1])
+	}
+
+	if args[1] == THIRD_PARTY_OTHER_INSURER && args[2] == "" {
+		return nil, errors.New("An external insurer is required for category: " + args[1])
+	}
+
+	thirdParty, err := RetrieveThirdParty(stub, args[0])
+	if err != nil {fmt.Printf("updateThirdParty: Unable to retrieve third party: %s", err); return nil, errors.New("Unable to retrieve third party with id: " + args[0])}
+
+	if caller_affiliation == ROLE_INSURER && !t.isThirdPartyRelevantToInsurer(stub, thirdParty, caller) {
+		return nil, errors.New("Caller is not the insurer of a claim linked to the third party")
+	}
+
+	thirdParty.Details.Category = args[1]
+	thirdParty.Details.ExternalInsurer = args[2]
+
+	_, err = SaveThirdParty(stub, thirdParty)
 
 	return nil, err
 }
 
+//=================================================================================================================================
+//	 isThirdPartyRelevantToInsurer - Checks if the insurer insures any of the claims linked to the third party
+//=================================================================================================================================
+func (t *InsuranceChaincode) isThirdPartyRelevantToInsurer(stub shim.ChaincodeStubInterface, thirdParty ThirdParty, insurer string) (bool) {
+	for _, claimId := range thirdParty.Relations.LinkedClaims {
+		claim, err := RetrieveClaim(stub, claimId)
+		if err != nil { continue }
+
+		policy, err := RetrievePolicy(stub, claim.Relations.RelatedPolicy)
+		if err == nil && policy.Relations.Insurer == insurer { return true }
+	}
+
+	return false
+}
+
+//=================================================================================================================================
+//	 retrieveThirdParties - Retrieves the third parties linked to a claim
+//=================================================================================================================================
+func (t *InsuranceChaincode) retrieveThirdParties(stub shim.ChaincodeStubInterface, claim Claim) ([]ThirdParty, error) {
+	thirdParties := []ThirdParty{}
+
+	for _, thirdPartyId := range claim.Relations.ThirdParties {
+		thirdParty, err := RetrieveThirdParty(stub, thirdPartyId)
+		if err != nil {fmt.Printf("\nretrieveThirdParties: Unable to retrieve third party with id: " + thirdPartyId + ": %s", err); return thirdParties, err}
+
+		thirdParties = append(thirdParties, thirdParty)
+	}
+
+	return thirdParties, nil
+}
+
+//=================================================================================================================================
+//	 parseCommaList - Parses a comma separated list, such as vehicle registrations or garage specialisms, ignoring
+//					  whitespace around each value and empty values
+//=================================================================================================================================
+func parseCommaList(listStr string) ([]string) {
+	values := []string{}
+
+	for _, value := range strings.Split(listStr, ",") {
+		value = strings.TrimSpace(value)
+		if value != "" { values = append(values, value) }
+	}
+
+	return values
+}
+
+func indexOf(values []string, value string) (int) {
+	for index, aValue := range values {
+		if aValue == value { return index }
+	}
+
+	return -1
+}
+
+//=================================================================================================================================
+//	 splitLiabilityShare - Splits a liability share equally between a number of parties. Any remainder goes to the first party.
+//=================================================================================================================================
+func splitLiabilityShare(liabilityShare int, numberOfParties int) ([]int) {
+	shares := make([]int, numberOfParties)
+
+	if numberOfParties == 0 { return shares }
+
+	for index := range shares {
+		shares[index] = liabilityShare / numberOfParties
+	}
+
+	shares[0] += liabilityShare % numberOfParties
+
+	return shares
+}
+
 //=================================================================================================================================
 //	 findPolicyWithVehicleReg - Finds a policy based on the vehicle registration
 //=================================================================================================================================
@@ -320,6 +697,67 @@ func (t *InsuranceChaincode) retrieveAllPoliciesJSON(stub shim.ChaincodeStubInte
 	return []byte(result), nil
 }
 
+//==============================================================================================================================
+//	 retrievePolicyJSON - Returns a JSON representation of a single policy, if the policy is relevant to the caller
+//		args - policyId
+//==============================================================================================================================
+func (t *InsuranceChaincode) retrievePolicyJSON(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		fmt.Println("RETRIEVE_POLICY: Incorrect number of arguments. Expecting 1 (policyId)")
+		return nil, errors.New("RETRIEVE_POLICY: Incorrect number of arguments. Expecting 1 (policyId)")
+	}
+
+	policy, err := RetrievePolicy(stub, args[0])
+	if err != nil {	fmt.Printf("\nRETRIEVE_POLICY: Failed to retrieve policy Id: %s", err); return nil, err }
+
+	if !t.isPolicyRelevantToCaller(stub, policy, caller, caller_affiliation) {
+		return nil, NotAuthorisedError{policy.Id, caller}
+	}
+
+	return json.Marshal(policy)
+}
+
+//==============================================================================================================================
+//	 retrieveGaragesJSON - Returns a JSON representation of the garages, optionally only those with a specialism.
+//		Insurers and super users see every garage, everyone else only sees the garages that are currently approved.
+//		args - specialism (optional)
+//==============================================================================================================================
+func (t *InsuranceChaincode) retrieveGaragesJSON(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	if len(args) > 1 {
+		fmt.Println("RETRIEVE_GARAGES: Incorrect number of arguments. Expecting 0 or 1 (specialism)")
+		return nil, errors.New("RETRIEVE_GARAGES: Incorrect number of arguments. Expecting 0 or 1 (specialism)")
+	}
+
+	garages := []Garage{}
+
+	for _, garage := range RetrieveAllGarages(stub) {
+		if len(args) == 1 && args[0] != "" && !garage.HasSpecialism(args[0]) { continue }
+
+		if caller_affiliation != ROLE_INSURER && caller_affiliation != ROLE_SUPER_USER && !t.isApprovedGarage(stub, garage.Id) { continue }
+
+		garages = append(garages, garage)
+	}
+
+	return json.Marshal(garages)
+}
+
+//==============================================================================================================================
+//	 retrievePolicySummaryByRegistrationJSON - Returns the summary of the policy for a vehicle, so that an insurer can find
+//		the insurer of another vehicle involved in a multiple party claim without seeing the rest of the policy
+//		args - registration
+//==============================================================================================================================
+func (t *InsuranceChaincode) retrievePolicySummaryByRegistrationJSON(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		fmt.Println("RETRIEVE_POLICY_SUMMARY: Incorrect number of arguments. Expecting 1 (registration)")
+		return nil, errors.New("RETRIEVE_POLICY_SUMMARY: Incorrect number of arguments. Expecting 1 (registration)")
+	}
+
+	policy, err := t.findPolicyWithVehicleReg(stub, args[0])
+	if err != nil {	fmt.Printf("\nRETRIEVE_POLICY_SUMMARY: Failed to find policy: %s", err); return nil, err }
+
+	return json.Marshal(NewPolicySummary(policy))
+}
+
 //==============================================================================================================================
 //	 isPolicyRelevantToCaller - Checks if a policy is relevant to the caller
 //==============================================================================================================================
@@ -327,8 +765,8 @@ func (t *InsuranceChaincode) isPolicyRelevantToCaller(stub shim.ChaincodeStubInt
 	//Super user can see everything
 	if caller_affiliation == ROLE_SUPER_USER { return true }
 
-	//Should associate policy with insurer but for now, allow it
-	if caller_affiliation == ROLE_INSURER { return true }
+	//Insurers can only see the policies they hold
+	if caller_affiliation == ROLE_INSURER && caller == policy.Relations.Insurer {fmt.Println("Policy insurer and caller match, policy is relevant"); return true}
 
 	//Is policy owned by caller?
 	if caller == policy.Relations.Owner {fmt.Println("Policy owner and caller match, policy is relevant"); return true}
@@ -368,6 +806,163 @@ func (t *InsuranceChaincode) retrieveAllClaimsJSON(stub shim.ChaincodeStubInterf
 	return []byte(result), nil
 }
 
+//==============================================================================================================================
+//	 retrieveClaimHistoryJSON - Returns a JSON representation of the history of a claim, if the claim is relevant to the caller
+//		args - claimId
+//==============================================================================================================================
+func (t *InsuranceChaincode) retrieveClaimHistoryJSON(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		fmt.Println("RETRIEVE_CLAIM_HISTORY: Incorrect number of arguments. Expecting 1 (claimId)")
+		return nil, errors.New("RETRIEVE_CLAIM_HISTORY: Incorrect number of arguments. Expecting 1 (claimId)")
+	}
+
+	claim, err := RetrieveClaim(stub, args[0])
+	if err != nil {	fmt.Printf("\nRETRIEVE_CLAIM_HISTORY: Failed to retrieve claim Id: %s", err); return nil, errors.New("RETRIEVE_CLAIM_HISTORY: Error retrieving claim with claimId = " + args[0]) }
+
+	if !t.isClaimRelevantToCaller(stub, claim, caller, caller_affiliation) {
+		fmt.Println("RETRIEVE_CLAIM_HISTORY: Claim is not relevant to caller: " + caller)
+		return nil, errors.New("RETRIEVE_CLAIM_HISTORY: Claim is not relevant to caller")
+	}
+
+	history, err := RetrieveClaimHistory(stub, claim.Id)
+	if err != nil { return nil, err }
+
+	return json.Marshal(history)
+}
+
+//==============================================================================================================================
+//	 retrieveClaimStateMachineJSON - Returns a JSON representation of the claim state transition table
+//==============================================================================================================================
+func (t *InsuranceChaincode) retrieveClaimStateMachineJSON(stub shim.ChaincodeStubInterface) ([]byte, error) {
+	return json.Marshal(CLAIM_TRANSITIONS)
+}
+
+//==============================================================================================================================
+//	 retrieveAllowedClaimActionsJSON - Returns a JSON representation of the transitions that the caller can perform on a claim
+//		args - claimId
+//==============================================================================================================================
+func (t *InsuranceChaincode) retrieveAllowedClaimActionsJSON(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		fmt.Println("RETRIEVE_ALLOWED_CLAIM_ACTIONS: Incorrect number of arguments. Expecting 1 (claimId)")
+		return nil, errors.New("RETRIEVE_ALLOWED_CLAIM_ACTIONS: Incorrect number of arguments. Expecting 1 (claimId)")
+	}
+
+	claim, err := RetrieveClaim(stub, args[0])
+	if err != nil {	fmt.Printf("\nRETRIEVE_ALLOWED_CLAIM_ACTIONS: Failed to retrieve claim Id: %s", err); return nil, errors.New("RETRIEVE_ALLOWED_CLAIM_ACTIONS: Error retrieving claim with claimId = " + args[0]) }
+
+	//Don't reveal the state of claims that the caller can't see
+	if !t.isClaimRelevantToCaller(stub, claim, caller, caller_affiliation) {
+		return []byte("[]"), nil
+	}
+
+	//The caller can perform the actions that the permissions table allows for their role and ownership of the claim
+	allowed := []ClaimTransition{}
+
+	for _, transition := range GetClaimTransitions(claim) {
+		err = t.CheckPermission(stub, transition.Action, caller, caller_affiliation, []string{claim.Id})
+		if err != nil && !strings.HasPrefix(err.Error(), "NOT_PERMITTED") { return nil, err }
+
+		if err == nil { allowed = append(allowed, transition) }
+	}
+
+	return json.Marshal(allowed)
+}
+
+//==============================================================================================================================
+//	 retrieveClaimJSON - Returns a JSON representation of a single claim, if the claim is relevant to the caller
+//		args - claimId
+//==============================================================================================================================
+func (t *InsuranceChaincode) retrieveClaimJSON(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		fmt.Println("RETRIEVE_CLAIM: Incorrect number of arguments. Expecting 1 (claimId)")
+		return nil, errors.New("RETRIEVE_CLAIM: Incorrect number of arguments. Expecting 1 (claimId)")
+	}
+
+	claim, err := RetrieveClaim(stub, args[0])
+	if err != nil {	fmt.Printf("\nRETRIEVE_CLAIM: Failed to retrieve claim Id: %s", err); return nil, err }
+
+	if !t.isClaimRelevantToCaller(stub, claim, caller, caller_affiliation) {
+		return nil, NotAuthorisedError{claim.Id, caller}
+	}
+
+	return json.Marshal(claim)
+}
+
+//==============================================================================================================================
+//	 retrieveVehicleJSON - Returns a JSON representation of a single vehicle, if the vehicle is relevant to the caller
+//		args - registration
+//==============================================================================================================================
+func (t *InsuranceChaincode) retrieveVehicleJSON(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		fmt.Println("RETRIEVE_VEHICLE: Incorrect number of arguments. Expecting 1 (registration)")
+		return nil, errors.New("RETRIEVE_VEHICLE: Incorrect number of arguments. Expecting 1 (registration)")
+	}
+
+	vehicle, err := RetrieveVehicle(stub, args[0])
+	if err != nil {	fmt.Printf("\nRETRIEVE_VEHICLE: Failed to retrieve vehicle: %s", err); return nil, err }
+
+	if !t.isVehicleRelevantToCaller(stub, vehicle, caller, caller_affiliation) {
+		return nil, NotAuthorisedError{vehicle.Id, caller}
+	}
+
+	return json.Marshal(vehicle)
+}
+
+//==============================================================================================================================
+//	 isVehicleRelevantToCaller - Checks if a vehicle is relevant to the caller
+//==============================================================================================================================
+func (t *InsuranceChaincode) isVehicleRelevantToCaller(stub shim.ChaincodeStubInterface, vehicle Vehicle, caller string, caller_affiliation string) (bool){
+	//Super users and insurers add vehicles, so can see all of them
+	if caller_affiliation == ROLE_SUPER_USER || caller_affiliation == ROLE_INSURER { return true }
+
+	//Is the vehicle insured on a policy owned by the caller?
+	for _, policy := range RetrieveAllPolicies(stub) {
+		if policy.Relations.Vehicle == vehicle.Id && policy.Relations.Owner == caller { return true }
+	}
+
+	fmt.Println("Caller does not own a policy for the vehicle, vehicle is not relevant - " + caller + " : " + vehicle.Id)
+	return false
+}
+
+//==============================================================================================================================
+//	 retrieveUserJSON - Returns a JSON representation of a single user, if the user is relevant to the caller
+//		args - userId
+//==============================================================================================================================
+func (t *InsuranceChaincode) retrieveUserJSON(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		fmt.Println("RETRIEVE_USER: Incorrect number of arguments. Expecting 1 (userId)")
+		return nil, errors.New("RETRIEVE_USER: Incorrect number of arguments. Expecting 1 (userId)")
+	}
+
+	user, err := RetrieveUser(stub, args[0])
+	if err != nil {	fmt.Printf("\nRETRIEVE_USER: Failed to retrieve user Id: %s", err); return nil, err }
+
+	if !t.isUserRelevantToCaller(stub, user, caller, caller_affiliation) {
+		return nil, NotAuthorisedError{user.Id, caller}
+	}
+
+	return json.Marshal(user)
+}
+
+//==============================================================================================================================
+//	 isUserRelevantToCaller - Checks if a user is relevant to the caller. Users hold personal details, so are only visible
+//   to the owner and insurer of the related policy
+//==============================================================================================================================
+func (t *InsuranceChaincode) isUserRelevantToCaller(stub shim.ChaincodeStubInterface, user User, caller string, caller_affiliation string) (bool){
+	//Super user can see everything
+	if caller_affiliation == ROLE_SUPER_USER { return true }
+
+	policy, err := RetrievePolicy(stub, user.Relations.RelatedPolicy)
+	if err != nil {fmt.Println("Invalid policy, user is not relevant"); return false}
+
+	if caller_affiliation == ROLE_INSURER && policy.Relations.Insurer == caller { return true }
+
+	if caller == policy.Relations.Owner { return true }
+
+	fmt.Println("Caller is not the owner or insurer of the related policy, user is not relevant - " + caller + " : " + user.Id)
+	return false
+}
+
 //==============================================================================================================================
 //	 isClaimRelevantToCaller - Checks is a claim is relevant to the caller
 //==============================================================================================================================
@@ -385,16 +980,15 @@ func (t *InsuranceChaincode) isClaimRelevantToCaller(stub shim.ChaincodeStubInte
 
 	//Garage checks
 	if caller_affiliation == ROLE_GARAGE {
-		//Is claim awaiting a report?
-		if claim.Details.Status == STATE_AWAITING_GARAGE_REPORT {
-			{fmt.Printf("Awaiting garage report and the caller is a garage, claim is relevant"); return true}
+		//Has the garage been assigned to the claim?
+		if claim.Details.AssignedGarage == caller {
+			{fmt.Printf("Garage assigned to the claim, claim is relevant"); return true}
 		} else {
-			//Is claim awaiting garage work and the garage submitted the report?
-			if claim.Details.Status == STATE_AWAITING_GARAGE_WORK_CONFIRMATION &&
-				claim.Details.Repair.Garage == caller {
-				{fmt.Printf("Awaiting garage work and garage raised report, claim is relevant"); return true}
+			//Has the garage been given the repair work order for the claim?
+			if claim.Details.Repair.Garage == caller {
+				{fmt.Printf("Repair work ordered from the garage, claim is relevant"); return true}
 			} else {
-				{fmt.Printf("Garage has not link to claim or incorrect state, claim is not relevant"); return false}
+				{fmt.Printf("Garage has not link to claim, claim is not relevant"); return false}
 			}
 		}
 	}
@@ -412,16 +1006,19 @@ func (t *InsuranceChaincode) isClaimRelevantToCaller(stub shim.ChaincodeStubInte
 //==============================================================================================================================
 func (t *InsuranceChaincode) vehicleValueOracleCallback(stub shim.ChaincodeStubInterface, caller string, callerAffiliation string, args []string) ([]byte, error) {
 
-	if callerAffiliation != ROLE_ORACLE {
-		fmt.Printf("vehicleValueCallback: Called from non oracle user")
-		return nil, errors.New("vehicleValueCallback: Called from non oracle user")
+	if len(args) != 2 {
+		fmt.Println("vehicleValueCallback: Incorrect number of arguments. Expecting 2 (requestId, value)")
+		return nil, errors.New("vehicleValueCallback: Incorrect number of arguments. Expecting 2 (requestId, value)")
 	}
 
 	vehicleValue, err := strconv.Atoi(args[1])
 
 	if err != nil {	fmt.Printf("vehicleValueCallback: Cannot parse car value %s", err); return nil, errors.New("vehicleValueCallback: Cannot parse car value")}
 
-	bytes, err := stub.GetState(args[0])
+	bytes, err := stub.GetState(ORACLE_REQUEST_KEY_PREFIX + args[0])
+
+	//Requests made before the key was namespaced are stored under the bare request id
+	if err == nil && len(bytes) == 0 { bytes, err = stub.GetState(args[0]) }
 
 	if err != nil {	fmt.Printf("vehicleValueCallback: Cannot read claim id from callback state: %s", err); return nil, errors.New("vehicleValueCallback: Cannot read claim from callback state")}
 
@@ -429,69 +1026,44 @@ func (t *InsuranceChaincode) vehicleValueOracleCallback(stub shim.ChaincodeStubI
 
 	claim, err := RetrieveClaim(stub , claimId)
 
+	if err != nil {	fmt.Printf("vehicleValueCallback: Cannot retrieve claim with id %s: %s", claimId, err); return nil, errors.New("vehicleValueCallback: Cannot retrieve claim with id " + claimId)}
+
+	err = CheckClaimTransition(claim, ACTION_VEHICLE_VALUE_OBTAINED)
+	if err != nil { return nil, err }
+
 	return t.afterVehicleValueObtainedProcess(stub, claim, vehicleValue)
 }
 
-func (t *InsuranceChaincode) queryOracleForVehicleValue(stub shim.ChaincodeStubInterface, claim Claim, vehicle Vehicle) {
+func (t *InsuranceChaincode) queryOracleForVehicleValue(stub shim.ChaincodeStubInterface, claim Claim, vehicle Vehicle) (error) {
 	//Store claim id against transaction id so it can be retreived during callback
-	stub.PutState(stub.GetTxID(), []byte(claim.Id))
+	stub.PutState(ORACLE_REQUEST_KEY_PREFIX + stub.GetTxID(), []byte(claim.Id))
 	err := RequestVehicleValuationFromOracle(stub, stub.GetTxID(), vehicle, "vehicleValueOracleCallback")
 
 	if err != nil {
-		fmt.Println("Error querying oracle for vehicle value: %s", err);
+		fmt.Printf("Error querying oracle for vehicle value: %s\n", err);
 		fmt.Println("Processing with default value")
-		t.afterVehicleValueObtainedProcess(stub, claim, 5555)
+		_, err = t.afterVehicleValueObtainedProcess(stub, claim, 5555)
+		return err
 	}
+
+	return nil
 }
 
 //==============================================================================================================================
 //	 Security Functions
 //==============================================================================================================================
-//	 get_caller_data - Calls the get_ecert and check_role functions and returns the ecert and role for the
-//					 name passed.
+//	 get_caller_data - Gets the username and role of the caller from their certificate.  Returns an error if the
+//					 certificate is missing either attribute, so that nothing runs without a known caller.
 //==============================================================================================================================
 
 func (t *InsuranceChaincode)  get_caller_data(stub shim.ChaincodeStubInterface) (string, string, error){
 
-	user, err := t.get_username(stub)
+	caller, err := GetCaller(stub)
 
-	if err != nil { user = "dummy-user" }
+	if err != nil { return "", "", err }
 
-	affiliation, err := t.check_affiliation(stub);
-
-	if err != nil { affiliation = "dummy-affiliation" }
-
-	fmt.Printf("caller_data: %s %s", user, affiliation)
-
-	return user, affiliation, nil
-}
-
-//==============================================================================================================================
-//	 get_caller - Retrieves the username of the user who invoked the chaincode.
-//				  Returns the username as a string.
-//==============================================================================================================================
-
-func (t *InsuranceChaincode) get_username(stub shim.ChaincodeStubInterface) (string, error) {
-
-	username, err := stub.ReadCertAttribute("username");
-	if err != nil {
-		fmt.Printf("Couldn't get attribute 'username'. Error: %s", err)
-		return "", errors.New("Couldn't get attribute 'username'. Error: " + err.Error())
-	}
-
-	return string(username), nil
-}
-
-//==============================================================================================================================
-//	 check_affiliation - Takes an ecert as a string, decodes it to remove html encoding then parses it and checks the
-// 				  		certificates common name. The affiliation is stored as part of the common name.
-//==============================================================================================================================
-
-func (t *InsuranceChaincode) check_affiliation(stub shim.ChaincodeStubInterface) (string, error) {
-	affiliation, err := stub.ReadCertAttribute("role");
-	if err != nil { return "", errors.New("Couldn't get attribute 'role'. Error: " + err.Error()) }
-	return string(affiliation), nil
-}
+	return caller.Username, caller.Role, nil
+}
 
 //==============================================================================================================================
 //	 declareLiability - A function called by a claimant to accept or dispute liability in a multi party accident
@@ -516,6 +1088,9 @@ func (t *InsuranceChaincode) declareLiability(stub shim.ChaincodeStubInterface,
 		return nil, errors.New("addLiabilityDeclaration: Error retrieving claim with claimId = " + claimId)
 	}
 
+	err = CheckClaimTransition(claim, ACTION_DECLARE_LIABILITY)
+	if err != nil { return nil, err }
+
 	//Check everything is valid
 	if !t.shouldAcceptLiabilityDeclarationForClaim(stub, claim, caller) {
 		return nil, errors.New("addLiabilityDeclaration: Invalid. Caller: " + caller + ", status:" + claim.Details.Status)
@@ -533,34 +1108,268 @@ func (t *InsuranceChaincode) declareLiability(stub shim.ChaincodeStubInterface,
 func (t *InsuranceChaincode) processDeclareLiability(stub shim.ChaincodeStubInterface, claim Claim, acceptLiability bool) ([]byte, error) {
 	//Liability accepted
 	if (acceptLiability) {
-		status := STATE_AWAITING_GARAGE_REPORT
-		//Update this claims status
-		claim.Details.Status = status
-		_, err := SaveClaim(stub, claim)
-		if err != nil {fmt.Printf("\naddLiabilityDeclaration: Unable to save claim: %s", err); return nil, err}
-
-		//Update the linked claims status'
-		for _, claimId := range claim.Relations.LinkedClaims {
-			otherClaim, err := RetrieveClaim(stub, claimId)
-			if err != nil {fmt.Printf("\naddLiabilityDeclaration: Unable to retrieve claim with id: " + claimId + ": %s", err); return nil, err}
-
-			otherClaim.Details.Status = status
-			_, err = SaveClaim(stub, otherClaim)
+		claim.Details.LiabilityAccepted = true
+
+		claims, err := t.retrieveLinkedClaimSet(stub, claim)
+		if err != nil {fmt.Printf("\naddLiabilityDeclaration: Unable to retrieve linked claims: %s", err); return nil, err}
+
+		//Only move on once every party has accepted their share of the liability
+		for _, aClaim := range claims {
+			if !aClaim.Details.LiabilityAccepted {
+				_, err = SaveClaim(stub, claim)
+				if err != nil {fmt.Printf("\naddLiabilityDeclaration: Unable to save claim: %s", err); return nil, err}
+
+				return nil, nil
+			}
+		}
+
+		//Update the status of this claim and the linked claims
+		for _, aClaim := range claims {
+			err = aClaim.TransitionTo(ACTION_DECLARE_LIABILITY, STATE_AWAITING_GARAGE_REPORT)
+			if err != nil { return nil, err }
+
+			_, err = SaveClaim(stub, aClaim)
 			if err != nil {fmt.Printf("\naddLiabilityDeclaration: Unable to save claim: %s", err); return nil, err}
 		}
 
 	} else {
-		//TODO Some kind of dispute state
-		return nil, errors.New("Liability dispute is currently unsupported")
+		//Liability disputed, the insurers of each party now need to submit evidence
+		claims, err := t.retrieveLinkedClaimSet(stub, claim)
+		if err != nil {fmt.Printf("\naddLiabilityDeclaration: Unable to retrieve linked claims: %s", err); return nil, err}
+
+		for _, aClaim := range claims {
+			err = aClaim.TransitionTo(ACTION_DECLARE_LIABILITY, STATE_LIABILITY_DISPUTED)
+			if err != nil { return nil, err }
+
+			_, err = SaveClaim(stub, aClaim)
+			if err != nil {fmt.Printf("\naddLiabilityDeclaration: Unable to save claim: %s", err); return nil, err}
+		}
+	}
+	return nil, nil
+}
+
+//==============================================================================================================================
+//	 submitLiabilityEvidence - Called by the insurer of a party in a disputed claim to submit evidence along with the
+//   liability share they propose for each claim.  Once the insurers of all parties agree, the dispute is resolved.
+//   args{claimId, evidence, proposedLiabilityShares}
+//      proposedLiabilityShares - JSON object of claim or third party id to percentage liability, e.g. {"C1":70,"T1":30}
+//==============================================================================================================================
+func (t *InsuranceChaincode) submitLiabilityEvidence(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+
+	fmt.Println("running submitLiabilityEvidence()")
+
+	if len(args) != 3 {
+		fmt.Println("SUBMIT_LIABILITY_EVIDENCE: Incorrect number of arguments. Expecting 3 (claimId, evidence, proposedLiabilityShares)")
+		return nil, errors.New("SUBMIT_LIABILITY_EVIDENCE: Incorrect number of arguments. Expecting 3 (claimId, evidence, proposedLiabilityShares)")
+	}
+
+	claim, err := RetrieveClaim(stub, args[0])
+	if err != nil {	fmt.Printf("\nSUBMIT_LIABILITY_EVIDENCE: Failed to retrieve claim Id: %s", err); return nil, errors.New("SUBMIT_LIABILITY_EVIDENCE: Error retrieving claim with claimId = " + args[0]) }
+
+	err = CheckClaimTransition(claim, ACTION_SUBMIT_LIABILITY_EVIDENCE)
+	if err != nil { return nil, err }
+
+	policy, err := RetrievePolicy(stub, claim.Relations.RelatedPolicy)
+	if err != nil {fmt.Printf("SUBMIT_LIABILITY_EVIDENCE: Error getting policy with id %s", claim.Relations.RelatedPolicy); return nil, errors.New("Policy doesnt exist")}
+
+	if policy.Relations.Insurer != caller {
+		fmt.Println("SUBMIT_LIABILITY_EVIDENCE: Caller is not the insurer of the claim: " + caller + " : " + policy.Relations.Insurer)
+		return nil, errors.New("SUBMIT_LIABILITY_EVIDENCE: Caller is not the insurer of the claim")
+	}
+
+	claims, err := t.retrieveLinkedClaimSet(stub, claim)
+	if err != nil {fmt.Printf("\nSUBMIT_LIABILITY_EVIDENCE: Unable to retrieve linked claims: %s", err); return nil, err}
+
+	thirdParties, err := t.retrieveThirdParties(stub, claim)
+	if err != nil {fmt.Printf("\nSUBMIT_LIABILITY_EVIDENCE: Unable to retrieve third parties: %s", err); return nil, err}
+
+	proposedShares, err := t.parseLiabilityShares(claims, thirdParties, args[2])
+	if err != nil {fmt.Printf("\nSUBMIT_LIABILITY_EVIDENCE: Invalid liability shares: %s", err); return nil, err}
+
+	submission := NewClaimDetailsLiabilityDisputeSubmission(caller, claim.Id, args[1], proposedShares)
+
+	//Every party to the dispute holds all of the submissions
+	for i := range claims {
+		claims[i].AddLiabilityDisputeSubmission(submission)
+	}
+
+	if agreedShares, agreed := t.isLiabilityDisputeAgreed(claims); agreed {
+		return t.processLiabilityDisputeResolution(stub, ACTION_SUBMIT_LIABILITY_EVIDENCE, claims, thirdParties, agreedShares)
 	}
+
+	for _, aClaim := range claims {
+		_, err = SaveClaim(stub, aClaim)
+		if err != nil {fmt.Printf("\nSUBMIT_LIABILITY_EVIDENCE: Unable to save claim: %s", err); return nil, err}
+	}
+
 	return nil, nil
 }
+
+//==============================================================================================================================
+//	 isLiabilityDisputeAgreed - Checks if a submission has been made for every claim in the dispute, and that all of the
+//   submissions propose the same liability shares.  Returns the agreed liability shares.
+//==============================================================================================================================
+func (t *InsuranceChaincode) isLiabilityDisputeAgreed(claims []Claim) (map[string]int, bool) {
+	var agreedShares map[string]int
+
+	for _, aClaim := range claims {
+		submission, found := aClaim.GetLiabilityDisputeSubmission(aClaim.Id)
+		if !found { return nil, false }
+
+		if agreedShares != nil && !areLiabilitySharesEqual(agreedShares, submission.ProposedShares) { return nil, false }
+
+		agreedShares = submission.ProposedShares
+	}
+
+	return agreedShares, agreedShares != nil
+}
+
+//==============================================================================================================================
+//	 parseLiabilityShares - Parses a JSON object of claim or third party id to liability share, checking that there is a
+//   share for every claim and third party in the linked set and that the shares add up to 100%
+//==============================================================================================================================
+func (t *InsuranceChaincode) parseLiabilityShares(claims []Claim, thirdParties []ThirdParty, sharesJSON string) (map[string]int, error) {
+	var shares map[string]int
+
+	err := json.Unmarshal([]byte(sharesJSON), &shares)
+	if err != nil { return nil, errors.New("Unable to parse liability shares: " + err.Error()) }
+
+	if len(shares) != len(claims) + len(thirdParties) {
+		return nil, errors.New("A liability share must be given for each of the " + strconv.Itoa(len(claims) + len(thirdParties)) + " linked claims and third parties")
+	}
+
+	for id, share := range shares {
+		if !containsClaim(claims, id) && !containsThirdParty(thirdParties, id) { return nil, errors.New("Claim or third party is not linked: " + id) }
+
+		if share < 0 || share > FULL_LIABILITY { return nil, errors.New("Invalid liability share for: " + id) }
+	}
+
+	//Validate against copies so that the claims and third parties themselves are left unchanged
+	proposedClaims := make([]Claim, len(claims))
+	copy(proposedClaims, claims)
+
+	for i := range proposedClaims {
+		proposedClaims[i].Details.LiabilityShare = shares[proposedClaims[i].Id]
+	}
+
+	proposedThirdParties := make([]ThirdParty, len(thirdParties))
+	copy(proposedThirdParties, thirdParties)
+
+	for i := range proposedThirdParties {
+		proposedThirdParties[i].Details.LiabilityShare = shares[proposedThirdParties[i].Id]
+	}
+
+	err = ValidateLiabilityShares(proposedClaims, proposedThirdParties)
+
+	return shares, err
+}
+
+func areLiabilitySharesEqual(shares map[string]int, otherShares map[string]int) (bool) {
+	if len(shares) != len(otherShares) { return false }
+
+	for claimId, share := range shares {
+		otherShare, found := otherShares[claimId]
+		if !found || otherShare != share { return false }
+	}
+
+	return true
+}
+
+//==============================================================================================================================
+//	 resolveLiabilityDispute - Called by a super user to settle a dispute that the insurers could not agree on
+//   args{claimId, liabilityShares}
+//      liabilityShares - JSON object of claim or third party id to percentage liability, e.g. {"C1":70,"T1":30}
+//==============================================================================================================================
+func (t *InsuranceChaincode) resolveLiabilityDispute(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+
+	fmt.Println("running resolveLiabilityDispute()")
+
+	if len(args) != 2 {
+		fmt.Println("RESOLVE_LIABILITY_DISPUTE: Incorrect number of arguments. Expecting 2 (claimId, liabilityShares)")
+		return nil, errors.New("RESOLVE_LIABILITY_DISPUTE: Incorrect number of arguments. Expecting 2 (claimId, liabilityShares)")
+	}
+
+	claim, err := RetrieveClaim(stub, args[0])
+	if err != nil {	fmt.Printf("\nRESOLVE_LIABILITY_DISPUTE: Failed to retrieve claim Id: %s", err); return nil, errors.New("RESOLVE_LIABILITY_DISPUTE: Error retrieving claim with claimId = " + args[0]) }
+
+	err = CheckClaimTransition(claim, ACTION_RESOLVE_LIABILITY_DISPUTE)
+	if err != nil { return nil, err }
+
+	claims, err := t.retrieveLinkedClaimSet(stub, claim)
+	if err != nil {fmt.Printf("\nRESOLVE_LIABILITY_DISPUTE: Unable to retrieve linked claims: %s", err); return nil, err}
+
+	thirdParties, err := t.retrieveThirdParties(stub, claim)
+	if err != nil {fmt.Printf("\nRESOLVE_LIABILITY_DISPUTE: Unable to retrieve third parties: %s", err); return nil, err}
+
+	shares, err := t.parseLiabilityShares(claims, thirdParties, args[1])
+	if err != nil {fmt.Printf("\nRESOLVE_LIABILITY_DISPUTE: Invalid liability shares: %s", err); return nil, err}
+
+	return t.processLiabilityDisputeResolution(stub, ACTION_RESOLVE_LIABILITY_DISPUTE, claims, thirdParties, shares)
+}
+
+//==============================================================================================================================
+//	 processLiabilityDisputeResolution - Sets liability on each of the disputed claims and third parties and resumes the
+//   normal claim flow
+//==============================================================================================================================
+func (t *InsuranceChaincode) processLiabilityDisputeResolution(stub shim.ChaincodeStubInterface, action string, claims []Claim, thirdParties []ThirdParty, shares map[string]int) ([]byte, error) {
+	for _, aClaim := range claims {
+		aClaim.Details.LiabilityShare = shares[aClaim.Id]
+		aClaim.Details.LiabilityDispute.AgreedShares = shares
+
+		err := aClaim.TransitionTo(action, STATE_AWAITING_GARAGE_REPORT)
+		if err != nil { return nil, err }
+
+		_, err = SaveClaim(stub, aClaim)
+		if err != nil {fmt.Printf("\nprocessLiabilityDisputeResolution: Unable to save claim: %s", err); return nil, err}
+	}
+
+	for _, thirdParty := range thirdParties {
+		thirdParty.Details.LiabilityShare = shares[thirdParty.Id]
+
+		_, err := SaveThirdParty(stub, thirdParty)
+		if err != nil {fmt.Printf("\nprocessLiabilityDisputeResolution: Unable to save third party: %s", err); return nil, err}
+	}
+
+	return nil, nil
+}
+
+//==============================================================================================================================
+//	 retrieveLinkedClaimSet - Retrieves the linked claims of a claim, returning them along with the claim itself
+//==============================================================================================================================
+func (t *InsuranceChaincode) retrieveLinkedClaimSet(stub shim.ChaincodeStubInterface, claim Claim) ([]Claim, error) {
+	claims := []Claim{claim}
+
+	for _, claimId := range claim.Relations.LinkedClaims {
+		linkedClaim, err := RetrieveClaim(stub, claimId)
+		if err != nil {fmt.Printf("\nretrieveLinkedClaimSet: Unable to retrieve claim with id: " + claimId + ": %s", err); return claims, err}
+
+		claims = append(claims, linkedClaim)
+	}
+
+	return claims, nil
+}
+
+func containsThirdParty(thirdParties []ThirdParty, thirdPartyId string) (bool) {
+	for _, thirdParty := range thirdParties {
+		if thirdParty.Id == thirdPartyId { return true }
+	}
+
+	return false
+}
+
+func containsClaim(claims []Claim, claimId string) (bool) {
+	for _, aClaim := range claims {
+		if aClaim.Id == claimId { return true }
+	}
+
+	return false
+}
 //==============================================================================================================================
 //	 shouldAcceptLiabilityDeclarationForClaim - Checks if liability can be declared for the specified claim and caller
 //==============================================================================================================================
 func (t *InsuranceChaincode) shouldAcceptLiabilityDeclarationForClaim(stub shim.ChaincodeStubInterface, claim Claim, caller string) (bool){
-	if (claim.Details.Status != STATE_AWAITING_LIABILITY_ACCEPTANCE) {
-		fmt.Println("Claim in wrong state for liability declaration: " + claim.Id)
+	if (claim.Details.LiabilityAccepted) {
+		fmt.Println("Liability has already been accepted for claim: " + claim.Id)
 		return false
 	}
 
@@ -576,6 +1385,86 @@ func (t *InsuranceChaincode) shouldAcceptLiabilityDeclarationForClaim(stub shim.
 	return true
 }
 
+//==============================================================================================================================
+//	 addPoliceReport - Called by the police (or an oracle acting on their behalf) to attach a police report to a claim.
+//   The report determines liability for the claim and any linked claims and third parties.
+//   args{claimId, description, x, y, atFaultRegs}
+//      atFaultRegs - comma separated list of the registrations of the vehicles whose drivers were at fault
+//==============================================================================================================================
+func (t *InsuranceChaincode) addPoliceReport(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+
+	fmt.Println("running addPoliceReport()")
+
+	if len(args) != 5 {
+		fmt.Println("ADD_POLICE_REPORT: Incorrect number of arguments. Expecting 5 (claimId, description, x, y, atFaultRegs)")
+		return nil, errors.New("ADD_POLICE_REPORT: Incorrect number of arguments. Expecting 5 (claimId, description, x, y, atFaultRegs)")
+	}
+
+	var claimId string = args[0]
+
+	claim, err := RetrieveClaim(stub, claimId)
+
+	if err != nil {	fmt.Printf("\nADD_POLICE_REPORT: Failed to retrieve claim Id: %s", err); return nil, errors.New("ADD_POLICE_REPORT: Error retrieving claim with claimId = " + claimId) }
+
+	err = CheckClaimTransition(claim, ACTION_ADD_POLICE_REPORT)
+	if err != nil { return nil, err }
+
+	report, err := NewPoliceReport(args[1], args[2], args[3], parseCommaList(args[4]))
+	if err != nil { return nil, err }
+
+	return t.processPoliceReport(stub, claim, report)
+}
+
+//==============================================================================================================================
+//	 processPoliceReport - Stores the police report against the claim and its linked claims, setting the liability of each
+//   claim and third party from the drivers the report names as at fault, and moving each claim on to await a garage report
+//==============================================================================================================================
+func (t *InsuranceChaincode) processPoliceReport(stub shim.ChaincodeStubInterface, claim Claim, report PoliceReport) ([]byte, error) {
+	claims, err := t.retrieveLinkedClaimSet(stub, claim)
+	if err != nil {fmt.Printf("\nADD_POLICE_REPORT: Unable to retrieve linked claims: %s", err); return nil, err}
+
+	thirdParties, err := t.retrieveThirdParties(stub, claim)
+	if err != nil {fmt.Printf("\nADD_POLICE_REPORT: Unable to retrieve third parties: %s", err); return nil, err}
+
+	//The registration of every party, the claims followed by the third parties
+	var partyRegs []string
+	for _, aClaim := range claims {
+		policy, err := RetrievePolicy(stub, aClaim.Relations.RelatedPolicy)
+		if err != nil {fmt.Printf("\nADD_POLICE_REPORT: Unable to retrieve policy with id: " + aClaim.Relations.RelatedPolicy + ": %s", err); return nil, err}
+
+		partyRegs = append(partyRegs, policy.Relations.Vehicle)
+	}
+
+	for _, thirdParty := range thirdParties {
+		partyRegs = append(partyRegs, thirdParty.Details.Registration)
+	}
+
+	shares, err := report.LiabilityShares(partyRegs)
+	if err != nil {fmt.Printf("\nADD_POLICE_REPORT: Invalid police report: %s", err); return nil, err}
+
+	for index, aClaim := range claims {
+		//Each claim holds the same report, from its own driver's point of view
+		aClaim.Details.PoliceReport = report
+		aClaim.Details.PoliceReport.DriverAtFault = shares[index] > 0
+		aClaim.Details.LiabilityShare = shares[index]
+
+		err = aClaim.TransitionTo(ACTION_ADD_POLICE_REPORT, STATE_AWAITING_GARAGE_REPORT)
+		if err != nil { return nil, err }
+
+		_, err = SaveClaim(stub, aClaim)
+		if err != nil {fmt.Printf("\nADD_POLICE_REPORT: Unable to save claim: %s", err); return nil, err}
+	}
+
+	for index, thirdParty := range thirdParties {
+		thirdParty.Details.LiabilityShare = shares[len(claims) + index]
+
+		_, err = SaveThirdParty(stub, thirdParty)
+		if err != nil {fmt.Printf("\nADD_POLICE_REPORT: Unable to save third party: %s", err); return nil, err}
+	}
+
+	return nil, nil
+}
+
 //==============================================================================================================================
 //	 addGarageReport - This method adds the garage report's details into the claim
 //   args{claimId, garage, estimated_cost,  writeOff, Note}
@@ -597,6 +1486,9 @@ func (t *InsuranceChaincode) addGarageReport(stub shim.ChaincodeStubInterface, c
 
 	if err != nil {	fmt.Printf("\nADD_GARAGE_REPORT: Failed to retrieve claim Id: %s", err); return nil, errors.New("ADD_GARAGE_REPORT: Error retrieving claim with claimId = " + claimId) }
 
+	err = CheckClaimTransition(theClaim, ACTION_ADD_GARAGE_REPORT)
+	if err != nil { return nil, err }
+
 	if !t.shouldAcceptGarageReportForClaim(stub, theClaim, caller, caller_affiliation) {
 		return nil, errors.New("ADD_GARAGE_REPORT: Invalid. Affilliation: " + caller_affiliation + ", status:" + theClaim.Details.Status)
 	}
@@ -610,49 +1502,89 @@ func (t *InsuranceChaincode) addGarageReport(stub shim.ChaincodeStubInterface, c
 	report, err = NewGarageReport(caller, args[1], args[2], args[3])
 	theClaim.Details.Report = report
 
-	theClaim.Details.Status = STATE_PENDING_AFTER_REPORT_DECISION
-
-	SaveClaim(stub, theClaim)
+	err = theClaim.TransitionTo(ACTION_ADD_GARAGE_REPORT, STATE_PENDING_AFTER_REPORT_DECISION)
+	if err != nil { return nil, err }
 
-	t.afterReportProcess(stub, theClaim)
+	_, err = SaveClaim(stub, theClaim)
+	if err != nil { return nil, err }
 
-	return nil, nil
+	return nil, t.afterReportProcess(stub, theClaim)
 }
 
 func (t *InsuranceChaincode) shouldAcceptGarageReportForClaim(stub shim.ChaincodeStubInterface, claim Claim, caller string, caller_affiliation string) (bool) {
 	//Super user can do anything
 	if caller_affiliation == ROLE_SUPER_USER { return true }
 
-	//Is caller a garage?
-	if caller_affiliation != ROLE_GARAGE {
-		fmt.Printf("Non Garage user attempting to add a garage report!!")
+	//Is the garage an approved garage?
+	if !t.isApprovedGarage(stub, caller) {
+		fmt.Printf("ADD_GARAGE_REPORT: Garage is not approved: %s\n", caller)
 		return false
 	}
 
-	// Is the Claim in a valid state to receive a garage report?
-	if STATE_AWAITING_GARAGE_REPORT != claim.Details.Status {
-		fmt.Printf("ADD_GARAGE_REPORT: claim in invalid state for garage report, with Id: %s\n", claim.Id)
+	//Has the garage been assigned to the claim?
+	if claim.Details.AssignedGarage != caller {
+		fmt.Printf("ADD_GARAGE_REPORT: Garage is not assigned to the claim: %s : %s\n", caller, claim.Details.AssignedGarage)
 		return false
 	}
 
-	//Is the garage an approved garage?
-	if !t.isApprovedGarage(stub, caller) {
-		fmt.Printf("ADD_GARAGE_REPORT: Garage is not approved: %s\n", caller)
-		return false
+	return true
+}
+
+//=================================================================================================================================
+//	 assignGarage - Assigns an approved garage to a claim, so that only that garage can see the claim and add the garage
+//		report.  A claim can be reassigned to another garage, with a reason, until the report has been added.
+//		args - claimId, garage, reason
+//=================================================================================================================================
+func (t *InsuranceChaincode) assignGarage(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+
+	fmt.Println("running assignGarage()")
+
+	if len(args) < 2 || len(args) > 3 {
+		fmt.Println("ASSIGN_GARAGE: Incorrect number of arguments. Expecting 2 or 3 (claimId, garage, reason)")
+		return nil, errors.New("ASSIGN_GARAGE: Incorrect number of arguments. Expecting 2 or 3 (claimId, garage, reason)")
 	}
 
-	return true
+	reason := ""
+	if len(args) == 3 { reason = args[2] }
+
+	theClaim, err := RetrieveClaim(stub, args[0])
+
+	if err != nil {	fmt.Printf("\nASSIGN_GARAGE: Failed to retrieve claim Id: %s", err); return nil, errors.New("ASSIGN_GARAGE: Error retrieving claim with claimId = " + args[0]) }
+
+	err = CheckClaimTransition(theClaim, ACTION_ASSIGN_GARAGE)
+	if err != nil { return nil, err }
+
+	if !t.isApprovedGarage(stub, args[1]) {
+		fmt.Printf("ASSIGN_GARAGE: Garage is not approved: %s\n", args[1])
+		return nil, errors.New("ASSIGN_GARAGE: Garage is not approved: " + args[1])
+	}
+
+	//Reassigning the claim needs a reason, which is kept with the assignment
+	if theClaim.Details.AssignedGarage != "" {
+		if theClaim.Details.AssignedGarage == args[1] { return nil, errors.New("ASSIGN_GARAGE: Garage is already assigned to the claim: " + args[1]) }
+
+		if reason == "" { return nil, errors.New("ASSIGN_GARAGE: A reason is required to reassign a claim to another garage") }
+	}
+
+	theClaim.AssignGarage(NewGarageAssignment(stub, args[1], caller, reason))
+
+	err = theClaim.TransitionTo(ACTION_ASSIGN_GARAGE, theClaim.Details.Status)
+	if err != nil { return nil, err }
+
+	_, err = SaveClaim(stub, theClaim)
+
+	return nil, err
 }
 
 //=========================================================================================
 // This Function process the claim after a report has arrived
 //=========================================================================================
-func (t *InsuranceChaincode) afterReportProcess(stub shim.ChaincodeStubInterface, claim Claim) {
+func (t *InsuranceChaincode) afterReportProcess(stub shim.ChaincodeStubInterface, claim Claim) (error) {
 	//Get vehicle
 	policy, _ := RetrievePolicy(stub, claim.Relations.RelatedPolicy)
 	vehicle, _ := RetrieveVehicle(stub, policy.Relations.Vehicle)
 
-	t.queryOracleForVehicleValue(stub, claim, vehicle)
+	return t.queryOracleForVehicleValue(stub, claim, vehicle)
 }
 //=========================================================================================
 // This Function process the claim after a report has arrived
@@ -660,21 +1592,16 @@ func (t *InsuranceChaincode) afterReportProcess(stub shim.ChaincodeStubInterface
 func (t *InsuranceChaincode) afterVehicleValueObtainedProcess(stub shim.ChaincodeStubInterface, theClaim Claim, vehicleValue int) ([]byte, error) {
 	fmt.Println("running afterVehicleValueObtainedProcess()")
 
-	if theClaim.Details.Status == STATE_PENDING_AFTER_REPORT_DECISION{
-
-		if theClaim.Details.Report.WriteOff || theClaim.Details.Report.Estimate > (vehicleValue * 50 / 100) {
-			//process total_loss
-			t.processTotalLoss(stub, theClaim, vehicleValue)
-		}else{
-			theClaim.Details.Status = STATE_ORDER_GARAGE_WORK
-			SaveClaim(stub, theClaim)
-		}
-	} else {
+	if _, found := FindClaimTransition(theClaim.Details.Status, ACTION_VEHICLE_VALUE_OBTAINED); !found {
 		fmt.Println("AFTER_VALUE_PROCESS: Claim in invalid state: " + theClaim.Details.Status);
 		return nil, errors.New("AFTER_VALUE_PROCESS: Claim in invalid state: " + theClaim.Id)
 	}
-		
-	return nil, nil
+
+	if theClaim.Details.Report.WriteOff || theClaim.Details.Report.Estimate > (vehicleValue * 50 / 100) {
+		return t.processTotalLoss(stub, theClaim, vehicleValue)
+	}
+
+	return t.processRepair(stub, theClaim)
 }
 
 //=========================================================================================
@@ -683,29 +1610,168 @@ func (t *InsuranceChaincode) afterVehicleValueObtainedProcess(stub shim.Chaincod
 func (t *InsuranceChaincode) processTotalLoss(stub shim.ChaincodeStubInterface, theClaim Claim, vehicleValue int) ([]byte, error) {
 
 	fmt.Println("running processTotalLoss()")
-    if &theClaim != nil{
-		var settlement ClaimDetailsSettlement
-		settlement.Decision = TOTAL_LOSS
-		settlement.Dispute = false
-		theClaim.Details.Settlement = settlement
-
-		//theClaim.Details.Status = STATE_TOTAL_LOSS_ESTABLISHED
-		theClaim.Details.Status = STATE_AWAITING_CLAIMANT_CONFIRMATION
-		theClaim.Details.Settlement.TotalLoss.CarValueEstimate = vehicleValue
-		SaveClaim(stub, theClaim)
-	}else {fmt.Printf("PROCESS_TOTAL_LOSS: Error can not process Total loss on unexisting claim\n"); return nil, errors.New("PROCESS_TOTAL_LOSS: Error can not process Total loss on unexisting claim") }
+
+	//Payments may already have been added by a linked claim, so only the decision is replaced
+	theClaim.Details.Settlement.Decision = TOTAL_LOSS
+	theClaim.Details.Settlement.Dispute = false
+
+	err := theClaim.TransitionTo(ACTION_VEHICLE_VALUE_OBTAINED, STATE_AWAITING_CLAIMANT_CONFIRMATION)
+	if err != nil { return nil, err }
+
+	theClaim.Details.Settlement.TotalLoss.CarValueEstimate = vehicleValue
+
+	_, err = SaveClaim(stub, theClaim)
+
+	return nil, err
+}
+
+//=========================================================================================
+// This Function raises a repair work order with the garage that reported on the claim
+//=========================================================================================
+func (t *InsuranceChaincode) processRepair(stub shim.ChaincodeStubInterface, theClaim Claim) ([]byte, error) {
+
+	fmt.Println("running processRepair()")
+
+	theClaim.Details.Settlement.Decision = REPAIR
+	theClaim.Details.Settlement.Dispute = false
+
+	theClaim.Details.Repair = NewRepairWorkOrder(theClaim.Details.Report.Garage,
+		t.nextWorkOrderSeq(stub, theClaim.Details.Report.Garage), theClaim.Details.Report.Notes, theClaim.Details.Report.Estimate)
+
+	err := theClaim.TransitionTo(ACTION_VEHICLE_VALUE_OBTAINED, STATE_ORDER_GARAGE_WORK)
+	if err != nil { return nil, err }
+
+	_, err = SaveClaim(stub, theClaim)
+
+	return nil, err
+}
+
+//=========================================================================================
+// Gets the sequence number of the next work order raised with a garage, which follows the
+// highest sequence number of the garage's existing work orders
+//=========================================================================================
+func (t *InsuranceChaincode) nextWorkOrderSeq(stub shim.ChaincodeStubInterface, garage string) (string) {
+	highest := 0
+
+	for _, claim := range RetrieveAllClaims(stub) {
+		if claim.Details.Repair.Garage != garage { continue }
+
+		seq, err := strconv.Atoi(claim.Details.Repair.WorkOrderSeq)
+		if err == nil && seq > highest { highest = seq }
+	}
+
+	return strconv.Itoa(highest + 1)
+}
+
+//=========================================================================================
+// This Function is called by the insurer to instruct the garage to start the repair work
+//   args{claimId, startDate}
+//      startDate - yyyy-mm-dd
+//=========================================================================================
+func (t *InsuranceChaincode) orderGarageWork(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+
+	fmt.Println("running orderGarageWork()")
+
+	if len(args) != 2 {
+		fmt.Println("ORDER_GARAGE_WORK: Incorrect number of arguments. Expecting 2 (claimId, startDate)")
+		return nil, errors.New("ORDER_GARAGE_WORK: Incorrect number of arguments. Expecting 2 (claimId, startDate)")
+	}
+
+	theClaim, err := RetrieveClaim(stub, args[0])
+
+	if err != nil {	fmt.Printf("\nORDER_GARAGE_WORK: Failed to retrieve claim Id: %s", err); return nil, errors.New("ORDER_GARAGE_WORK: Error retrieving claim with claimId = " + args[0]) }
+
+	err = CheckClaimTransition(theClaim, ACTION_ORDER_GARAGE_WORK)
+	if err != nil { return nil, err }
+
+	_, err = time.Parse(WORK_ORDER_DATE_FORMAT, args[1])
+	if err != nil {fmt.Printf("ORDER_GARAGE_WORK Error: invalid value passed for startDate: %s\n", err); return nil, errors.New("Invalid value passed for startDate, expecting yyyy-mm-dd")}
+
+	theClaim.Details.Repair.StartDate = args[1]
+	theClaim.Details.Repair.WorkStatus = STATE_IN_PROGRESS
+
+	err = theClaim.TransitionTo(ACTION_ORDER_GARAGE_WORK, STATE_AWAITING_GARAGE_WORK_CONFIRMATION)
+	if err != nil { return nil, err }
+
+	_, err = SaveClaim(stub, theClaim)
+
+	return nil, err
+}
+
+//=========================================================================================
+// This Function is called by the garage to confirm that the repair work is complete.
+// The claim is then settled and a payment from the insurer to the garage is added.
+//   args{claimId, actualRepairCost, endDate}
+//=========================================================================================
+func (t *InsuranceChaincode) confirmWork(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+
+	fmt.Println("running confirmWork()")
+
+	if len(args) != 3 {
+		fmt.Println("CONFIRM_WORK: Incorrect number of arguments. Expecting 3 (claimId, actualRepairCost, endDate)")
+		return nil, errors.New("CONFIRM_WORK: Incorrect number of arguments. Expecting 3 (claimId, actualRepairCost, endDate)")
+	}
+
+	theClaim, err := RetrieveClaim(stub, args[0])
+
+	if err != nil {	fmt.Printf("\nCONFIRM_WORK: Failed to retrieve claim Id: %s", err); return nil, errors.New("CONFIRM_WORK: Error retrieving claim with claimId = " + args[0]) }
+
+	err = CheckClaimTransition(theClaim, ACTION_CONFIRM_WORK)
+	if err != nil { return nil, err }
+
+	if theClaim.Details.Repair.Garage != caller {
+		fmt.Println("CONFIRM_WORK: Caller is not the garage carrying out the repair: " + caller + " : " + theClaim.Details.Repair.Garage)
+		return nil, errors.New("CONFIRM_WORK: Caller is not the garage carrying out the repair")
+	}
+
+	actualRepairCost, err := strconv.Atoi(args[1])
+	if err != nil {fmt.Printf("CONFIRM_WORK Error: invalid value passed for actualRepairCost: %s\n", err); return nil, errors.New("Invalid value passed for actualRepairCost")}
+
+	if actualRepairCost < 0 { return nil, errors.New("Invalid value passed for actualRepairCost, it can't be negative") }
+
+	theClaim.Details.Repair.ActualRepairCost = actualRepairCost
+	theClaim.Details.Repair.EndDate = args[2]
+	theClaim.Details.Repair.WorkStatus = STATUS_CLOSED
+
+	err = theClaim.TransitionTo(ACTION_CONFIRM_WORK, STATE_SETTLED)
+	if err != nil { return nil, err }
+
+	policy, err := RetrievePolicy(stub, theClaim.Relations.RelatedPolicy)
+	if err != nil {fmt.Printf("CONFIRM_WORK: Error getting policy with id %s", theClaim.Relations.RelatedPolicy); return nil, errors.New("Policy doesnt exist")}
+
+	theClaim, err = t.addPendingPaymentsToClaim(stub, theClaim, policy)
+	if err != nil {fmt.Printf("CONFIRM_WORK Error: Unable to add pending payments: %s\n", err); return nil, err}
+
+	_, err = SaveClaim(stub, theClaim)
+	if err != nil { return nil, err }
+
+	t.emitClaimSettledEvent(stub, theClaim)
+
 	return nil, nil
 }
 
+//=========================================================================================
+// Emits a claim settled event so that the pending payments can be paid off chain
+//=========================================================================================
+func (t *InsuranceChaincode) emitClaimSettledEvent(stub shim.ChaincodeStubInterface, theClaim Claim) {
+	event := NewClaimSettledEvent(theClaim.Id, theClaim.Relations.RelatedPolicy, theClaim.Relations.LinkedClaims)
+
+	eventBytes, err := json.Marshal(event);
+
+	if (err != nil) {
+		fmt.Printf("\nUnable to parse event, continuing without emitting: %s", err)
+	} else {
+		//Emit claim settled event
+		stub.SetEvent(event.Type, eventBytes)
+	}
+}
+
 //=========================================================================================
 // This Function set the claimant aggreement in the payout
 //=========================================================================================
 func (t *InsuranceChaincode) agreePayoutAmount(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
 	fmt.Println("running agreePayoutAmount()")
 
-    //
-	//TODO - Check the Security  check that this claimant has the right to view and update this claim
-	//
 	if len(args) != 2 {
 		fmt.Println("AGREE_PAYOUT_AMOUNT: Incorrect number of arguments. Expecting 2 (claimId, agreement)")
 		return nil, errors.New("AGREE_PAYOUT_AMOUNT: Incorrect number of arguments. Expecting 2 (claimId, agreement)")
@@ -717,10 +1783,8 @@ func (t *InsuranceChaincode) agreePayoutAmount(stub shim.ChaincodeStubInterface,
 
 	if err != nil {	fmt.Printf("\nAGREE_PAYOUT_AMOUNT: Failed to retrieve claim Id: %s", err); return nil, errors.New("AGREE_PAYOUT_AMOUNT: Error retrieving claim with claimId = " + args[0]) }
 
-	if theClaim.Details.Status != STATE_AWAITING_CLAIMANT_CONFIRMATION{
-		fmt.Println("AGREE_PAYOUT_AMOUNT: Incorrect number of arguments. Expecting 2 (claimId, agreement)")
-		return nil, errors.New("AGREE_PAYOUT_AMOUNT: Incorrect number of arguments. Expecting 2 (claimId, agreement)")
-	}
+	err = CheckClaimTransition(theClaim, ACTION_AGREE_PAYOUT_AMOUNT)
+	if err != nil { return nil, err }
 
 	var acceptDeny bool
 	acceptDeny, err = strconv.ParseBool(args[1])
@@ -729,7 +1793,9 @@ func (t *InsuranceChaincode) agreePayoutAmount(stub shim.ChaincodeStubInterface,
 	if acceptDeny {
 		//TODO this will only work for total loss
 		theClaim.Details.Settlement.TotalLoss.CustomerAgreedValue = theClaim.Details.Settlement.TotalLoss.CarValueEstimate
-		theClaim.Details.Status = STATE_SETTLED
+
+		err = theClaim.TransitionTo(ACTION_AGREE_PAYOUT_AMOUNT, STATE_SETTLED)
+		if err != nil { return nil, err }
 		theClaim.Details.Settlement.Dispute = false
 
 		policy, err := RetrievePolicy(stub, theClaim.Relations.RelatedPolicy)
@@ -741,22 +1807,9 @@ func (t *InsuranceChaincode) agreePayoutAmount(stub shim.ChaincodeStubInterface,
 
 		//Add pending payments to claim
 		theClaim, err = t.addPendingPaymentsToClaim(stub, theClaim, policy)
-
-		//TODO Assuming one linked claim for now
-		var linkedClaim string
-		if len(theClaim.Relations.LinkedClaims) > 0 { linkedClaim = theClaim.Relations.LinkedClaims[0]}
-
-		event := NewClaimSettledEvent(theClaim.Id, theClaim.Relations.RelatedPolicy, linkedClaim)
-
-		eventBytes, err := json.Marshal(event);
 		if err != nil {fmt.Printf("AGREE_PAYOUT_AMOUNT Error: Unable to add pending payment: %s\n", err); return nil, err}
 
-		if (err != nil) {
-			fmt.Printf("\nAGREE_PAYOUT_AMOUNT: Unable to parse event, continuing without emitting", err)
-		} else {
-			//Emit claim settled event
-			stub.SetEvent(event.Type, eventBytes)
-		}
+		t.emitClaimSettledEvent(stub, theClaim)
 	} else {
 		theClaim.Details.Settlement.Dispute = true
 	}
@@ -775,31 +1828,51 @@ func (t *InsuranceChaincode) addPendingPaymentsToClaim(stub shim.ChaincodeStubIn
 		return claim, errors.New("addPendingPaymentsToClaim: Unexpected input for this STATE")
 	}
 
+	settlementValue := t.calculateSettlementValue(claim)
 	paymentAmount := t.calculatePaymentAmount(claim, policy)
 
-	claim, err := t.addPendingPaymentToClaimant(claim, policy, paymentAmount)
-	if err != nil {fmt.Printf("addPendingPaymentsToClaim: Unable to add claimant payment: %s", err); return claim, err}
+	var err error
 
-	//If we're not liable, there needs to be a pending payment added from the linked claim insurer to this parties insurer
-	if (claim.Details.Incident.Type == MULTIPLE_PARTIES && !claim.Details.IsLiable) {
-		claim, err = t.addPendingPaymentFromOtherPartyInsurer(stub, claim, policy, paymentAmount)
+	//Repairs are paid directly to the garage, otherwise the claimant is paid
+	if claim.Details.Settlement.Decision == REPAIR {
+		claim, err = t.addPendingPaymentToGarage(claim, policy, paymentAmount)
+		if err != nil {fmt.Printf("addPendingPaymentsToClaim: Unable to add garage payment: %s", err); return claim, err}
+	} else {
+		claim, err = t.addPendingPaymentToClaimant(claim, policy, paymentAmount)
+		if err != nil {fmt.Printf("addPendingPaymentsToClaim: Unable to add claimant payment: %s", err); return claim, err}
+	}
+
+	//If we're not fully liable, there needs to be a pending payment added from each liable linked claim insurer to this
+	//parties insurer, in proportion to their share of the liability
+	if (claim.Details.Incident.Type == MULTIPLE_PARTIES && claim.Details.LiabilityShare < FULL_LIABILITY) {
+		claim, err = t.addPendingPaymentsFromOtherPartyInsurers(stub, claim, policy, settlementValue)
 		if err != nil { return claim, err }
 	}
 
 	return claim, nil
 }
 
+//=========================================================================================
+// Calculates the full value of the settlement, before any excess is taken off
+//=========================================================================================
+func (t *InsuranceChaincode) calculateSettlementValue(claim Claim) (int){
+	//The excess on a repair is paid by the claimant to the garage
+	if (claim.Details.Settlement.Decision == REPAIR) {
+		return claim.Details.Repair.ActualRepairCost
+	}
+
+	return claim.Details.Settlement.TotalLoss.CustomerAgreedValue
+}
+
 func (t *InsuranceChaincode) calculatePaymentAmount(claim Claim, policy Policy) (int){
 	excess := policy.Details.Excess
 
 	if (claim.Details.Incident.Type == MULTIPLE_PARTIES) {
-		//If the party is not liable in a multiple party claim, then there should be no excess
-		if (!claim.Details.IsLiable) {
-			excess = 0
-		}
+		//In a multiple party claim, the excess is only paid in proportion to the party's share of the liability
+		excess = excess * claim.Details.LiabilityShare / FULL_LIABILITY
 	}
 
-	return claim.Details.Settlement.TotalLoss.CustomerAgreedValue - excess
+	return t.calculateSettlementValue(claim) - excess
 }
 
 //=========================================================================================
@@ -817,27 +1890,67 @@ func (t *InsuranceChaincode) addPendingPaymentToClaimant(theClaim Claim, policy
 }
 
 //=========================================================================================
-// This Function adds a pending payment from insurer to insurer
+// This Function adds a pending payment from insurer to the garage carrying out the repair
+//=========================================================================================
+func (t *InsuranceChaincode) addPendingPaymentToGarage(theClaim Claim, policy Policy, amount int) (Claim, error) {
+	fmt.Println("running addPendingPaymentToGarage()")
+
+	payment := NewClaimDetailsSettlementPayment(PAYMENT_TYPE_GARAGE,
+		theClaim.Details.Repair.Garage, PAYMENT_TYPE_INSURER, policy.Relations.Insurer, amount, STATE_NOT_PAID)
+
+	theClaim.AddPayment(payment)
+
+	return theClaim, nil
+}
+
+//=========================================================================================
+// This Function adds a pending payment from the insurer of each liable linked claim and from
+// each liable third party to this claims insurer, proportional to their share of the liability
 //=========================================================================================
-func (t *InsuranceChaincode) addPendingPaymentFromOtherPartyInsurer(stub shim.ChaincodeStubInterface, claim Claim, policy Policy, amount int) (Claim, error) {
-	fmt.Println("running addPendingPaymentFromOtherPartyInsurer()")
+func (t *InsuranceChaincode) addPendingPaymentsFromOtherPartyInsurers(stub shim.ChaincodeStubInterface, claim Claim, policy Policy, settlementValue int) (Claim, error) {
+	fmt.Println("running addPendingPaymentsFromOtherPartyInsurers()")
 
-	liableClaim, err := claim.GetLiableClaim(stub)
+	liableClaims, err := claim.GetLiableClaims(stub)
 	if err != nil { return claim, err }
 
-	liablePolicy, err := RetrievePolicy(stub, liableClaim.Relations.RelatedPolicy)
-	if err != nil { return claim, err}
+	for _, liableClaim := range liableClaims {
+		amount := settlementValue * liableClaim.Details.LiabilityShare / FULL_LIABILITY
+
+		liablePolicy, err := RetrievePolicy(stub, liableClaim.Relations.RelatedPolicy)
+		if err != nil { return claim, err}
+
+		payment := NewClaimDetailsSettlementPayment(PAYMENT_TYPE_INSURER,
+			policy.Relations.Insurer, PAYMENT_TYPE_INSURER, liablePolicy.Relations.Insurer, amount, STATE_NOT_PAID)
+		claimPayment := claim.AddPayment(payment)
 
-	payment := NewClaimDetailsSettlementPayment(PAYMENT_TYPE_INSURER,
-		policy.Relations.Insurer, PAYMENT_TYPE_INSURER, liablePolicy.Relations.Insurer, amount, STATE_NOT_PAID)
-	claim.AddPayment(payment)
+		//Double enter the payment to the liable claim list of payments, linking the two entries together
+		payment.LinkedClaim = claim.Id
+		payment.LinkedPayment = claimPayment.Id
+		liablePayment := liableClaim.AddPayment(payment)
 
-	//Double enter the payment to the liable claim list of payments
-	liableClaim.AddPayment(payment)
-	_, err = SaveClaim(stub, liableClaim)
+		claimPayment.LinkedClaim = liableClaim.Id
+		claimPayment.LinkedPayment = liablePayment.Id
+		claim.UpdatePayment(claimPayment)
 
+		_, err = SaveClaim(stub, liableClaim)
+
+		if err != nil { return claim, err }
+	}
+
+	//Third parties aren't on the ledger, so their payments are only held against this claim
+	thirdParties, err := t.retrieveThirdParties(stub, claim)
 	if err != nil { return claim, err }
 
+	for _, thirdParty := range thirdParties {
+		if thirdParty.Details.LiabilityShare == 0 { continue }
+
+		amount := settlementValue * thirdParty.Details.LiabilityShare / FULL_LIABILITY
+
+		payment := NewClaimDetailsSettlementPayment(PAYMENT_TYPE_INSURER,
+			policy.Relations.Insurer, PAYMENT_TYPE_THIRDPARTY, thirdParty.PaymentSender(), amount, STATE_NOT_PAID)
+		claim.AddPayment(payment)
+	}
+
 	//No need to save the other claim as its saved elsewhere
 
 	return claim, nil
@@ -850,11 +1963,6 @@ func (t *InsuranceChaincode) confirmPaidOut(stub shim.ChaincodeStubInterface, ca
 
 	fmt.Println("running confirmPaidOut()")
 
-	if caller_affiliation != ROLE_INSURER {
-		fmt.Printf("\nconfirmPaidOut: Caller is not an insurer")
-		return nil, errors.New("\nconfirmPaidOut: Caller is not an insurer")
-	}
-
 	if len(args) != 2 {
 		fmt.Println("CONFIRM_PAID_OUT: Incorrect number of arguments. Expecting 2 (claimId, paymentId)")
 		return nil, errors.New("CONFIRM_PAID_OUT: Incorrect number of arguments. Expecting 2 (claimId, paymentId)")
@@ -864,21 +1972,25 @@ func (t *InsuranceChaincode) confirmPaidOut(stub shim.ChaincodeStubInterface, ca
 
 	if err != nil {fmt.Println("Unable to retrieve claim with id: " + args[0]); return nil, err}
 
-	if theClaim.Details.Status != STATE_SETTLED{
-		fmt.Println("CONFIRM_PAID_OUT: Unexpected input for this STATE")
-		return nil, errors.New("CONFIRM_PAID_OUT: Unexpected input for this STATE")
-	}
+	err = CheckClaimTransition(theClaim, ACTION_CONFIRM_PAID_OUT)
+	if err != nil { return nil, err }
 
 	payment, err := theClaim.GetPayment(args[1])
 
-	//Check that the caller is the sender
-	if (payment.Sender != caller){
+	if err != nil { return nil, err}
+
+	//Third parties aren't on the ledger, so the receiving insurer confirms that they have paid
+	if (payment.SenderType == PAYMENT_TYPE_THIRDPARTY){
+		if (payment.Recipient != caller){
+			fmt.Println("CONFIRM_PAID_OUT: Caller is not the recipient of the third party payment")
+			return nil, errors.New("CONFIRM_PAID_OUT: Caller is not the recipient of the third party payment")
+		}
+	} else if (payment.Sender != caller){
+		//Check that the caller is the sender
 		fmt.Println("CONFIRM_PAID_OUT: Caller is not the sender of the payment")
 		return nil, errors.New("CONFIRM_PAID_OUT: Caller is not the sender of the payment")
 	}
 
-	if err != nil { return nil, err}
-
 	payment.Status = STATE_PAID
 	theClaim.UpdatePayment(payment)
 
@@ -891,57 +2003,157 @@ func (t *InsuranceChaincode) confirmPaidOut(stub shim.ChaincodeStubInterface, ca
 }
 
 //=========================================================================================
-// Updates the status of any matching payments on linked claims
+// Updates the status of the double entered payment on the linked claim
 //=========================================================================================
 func (t *InsuranceChaincode) updateLinkedPayments(stub shim.ChaincodeStubInterface, claim Claim, payment ClaimDetailsSettlementPayment) (error){
 
 	fmt.Println("running updateLinkedPayments()")
 
 	//If this is a payment to an insurer then update the payment on the linked claim
-	if (payment.RecipientType == PAYMENT_TYPE_INSURER) {
-		for _, claimId := range claim.Relations.LinkedClaims {
-			linkedClaim, err := RetrieveClaim(stub, claimId)
-			if err != nil{ return err }
-
-			//Check if there is a payment with the same parameters TODO: Hacky. There should be a proper link I think
-			for _, linkedPayment := range linkedClaim.Details.Settlement.Payments {
-				if linkedPayment.Sender == payment.Sender &&
-						linkedPayment.Recipient == payment.Recipient &&
-						linkedPayment.Amount == payment.Amount {
-					//We've found a matching payment...update
-					linkedPayment.Status = STATE_PAID
-					linkedClaim.UpdatePayment(linkedPayment)
-					_, err = SaveClaim(stub, linkedClaim)
-
-					//Fire Insurer payment paid event
-					event := NewInsurerPaymentPaidEvent(linkedClaim.Id, linkedClaim.Relations.RelatedPolicy)
-					eventBytes, _ := json.Marshal(event)
-					stub.SetEvent(event.Type, eventBytes)
-
-					if err != nil { return err }
-				}
-			}
-		}
+	if (payment.RecipientType == PAYMENT_TYPE_INSURER && payment.LinkedClaim != "") {
+		linkedClaim, err := RetrieveClaim(stub, payment.LinkedClaim)
+		if err != nil{ return err }
+
+		linkedPayment, err := linkedClaim.GetPayment(payment.LinkedPayment)
+		if err != nil{ return err }
+
+		linkedPayment.Status = STATE_PAID
+		linkedClaim.UpdatePayment(linkedPayment)
+		_, err = SaveClaim(stub, linkedClaim)
+		if err != nil { return err }
+
+		//Fire Insurer payment paid event
+		event := NewInsurerPaymentPaidEvent(linkedClaim.Id, linkedClaim.Relations.RelatedPolicy)
+		eventBytes, _ := json.Marshal(event)
+		stub.SetEvent(event.Type, eventBytes)
 	}
 
 	return nil
 }
 
 //===============================================================================
-// This method checks whether all the payement out are sent
+// This method checks whether the garage is approved, i.e. it has a profile, isn't
+// suspended and its accreditation hasn't expired
 //===============================================================================
-func (t *InsuranceChaincode) isApprovedGarage(stub shim.ChaincodeStubInterface, garage string) bool {
+func (t *InsuranceChaincode) isApprovedGarage(stub shim.ChaincodeStubInterface, garageId string) bool {
+
+	garage, err := RetrieveGarage(stub, garageId)
+	if err != nil {	fmt.Printf("IS_APPROVED_GARAGE: Unable to retrieve garage %s: %s", garageId, err); return false}
 
-	approvedGarages, err := RetrieveApprovedGarages(stub)
-	if err != nil {	fmt.Printf("IS_APPROVED_GARAGE: Unable to retrieve the approved garages: %s", err); return false}
+	txTime, err := GetTxTime(stub)
+	if err != nil {	fmt.Printf("IS_APPROVED_GARAGE: Unable to get the transaction time: %s", err); return false}
 
-    for _, appGarage := range approvedGarages.Garages {
-		fmt.Printf("Approved Garage: %s\n", appGarage)
-        if appGarage == garage {
-            return true
-        }
-    }
-    return false
+	return garage.IsApproved(txTime)
+}
+
+//=================================================================================================================================
+//	 addGarage - Adds the profile of a new approved garage
+//		args - garageId, name, address, x, y, accreditationExpiry (yyyy-mm-dd, or empty if it doesn't expire), specialisms
+//=================================================================================================================================
+func (t *InsuranceChaincode) addGarage(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+
+	fmt.Println("running addGarage()")
+
+	if len(args) != 7 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 7 (GarageId, Name, Address, X, Y, AccreditationExpiry, Specialisms)")
+	}
+
+	if args[0] == "" || args[1] == "" {
+		return nil, errors.New("A garage id and name are required")
+	}
+
+	_, err := RetrieveGarage(stub, args[0])
+	if err == nil { return nil, errors.New("Garage already exists: " + args[0]) }
+	if !IsNotFoundError(err) { fmt.Printf("addGarage: Unable to check for an existing garage: %s", err); return nil, err }
+
+	x, err := strconv.ParseFloat(args[3], 32)
+	if err != nil || x < -180 || x > 180 {fmt.Printf("addGarage: Invalid x coordinate: %s", err); return nil, errors.New("Invalid value passed for x coordinate")}
+
+	y, err := strconv.ParseFloat(args[4], 32)
+	if err != nil || y < -90 || y > 90 {fmt.Printf("addGarage: Invalid y coordinate: %s", err); return nil, errors.New("Invalid value passed for y coordinate")}
+
+	if args[5] != "" {
+		_, err = time.Parse(ACCREDITATION_DATE_FORMAT, args[5])
+		if err != nil {fmt.Printf("addGarage: Invalid accreditation expiry: %s", err); return nil, errors.New("Invalid value passed for accreditation expiry, expecting yyyy-mm-dd")}
+	}
+
+	garage := NewGarage(args[0], args[1], args[2], Coordinates{float32(x), float32(y)}, args[5], parseCommaList(args[6]))
+
+	_, err = SaveGarage(stub, garage)
+
+	return nil, err
+}
+
+//=================================================================================================================================
+//	 suspendGarage - Suspends an approved garage, so that it can't be assigned to claims or add garage reports
+//		args - garageId, reason
+//=================================================================================================================================
+func (t *InsuranceChaincode) suspendGarage(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+
+	fmt.Println("running suspendGarage()")
+
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 2 (GarageId, Reason)")
+	}
+
+	garage, err := RetrieveGarage(stub, args[0])
+	if err != nil {fmt.Printf("suspendGarage: Unable to retrieve garage: %s", err); return nil, errors.New("Garage does not exist: " + args[0])}
+
+	if garage.Details.Status == GARAGE_STATUS_SUSPENDED {
+		return nil, errors.New("Garage is already suspended: " + args[0])
+	}
+
+	garage.Details.Status = GARAGE_STATUS_SUSPENDED
+	garage.Details.SuspensionReason = args[1]
+
+	_, err = SaveGarage(stub, garage)
+
+	return nil, err
+}
+
+//=================================================================================================================================
+//	 reinstateGarage - Lifts the suspension of a garage
+//		args - garageId
+//=================================================================================================================================
+func (t *InsuranceChaincode) reinstateGarage(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+
+	fmt.Println("running reinstateGarage()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1 (GarageId)")
+	}
+
+	garage, err := RetrieveGarage(stub, args[0])
+	if err != nil {fmt.Printf("reinstateGarage: Unable to retrieve garage: %s", err); return nil, errors.New("Garage does not exist: " + args[0])}
+
+	if garage.Details.Status != GARAGE_STATUS_SUSPENDED {
+		return nil, errors.New("Garage is not suspended: " + args[0])
+	}
+
+	garage.Details.Status = GARAGE_STATUS_APPROVED
+	garage.Details.SuspensionReason = ""
+
+	_, err = SaveGarage(stub, garage)
+
+	return nil, err
+}
+
+//=================================================================================================================================
+//	 removeGarage - Removes the profile of a garage, so that it is no longer approved
+//		args - garageId
+//=================================================================================================================================
+func (t *InsuranceChaincode) removeGarage(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+
+	fmt.Println("running removeGarage()")
+
+	if len(args) != 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 1 (GarageId)")
+	}
+
+	_, err := RetrieveGarage(stub, args[0])
+	if err != nil {fmt.Printf("removeGarage: Unable to retrieve garage: %s", err); return nil, errors.New("Garage does not exist: " + args[0])}
+
+	return nil, DeleteGarage(stub, args[0])
 }
 
 //===============================================================================
@@ -954,28 +2166,23 @@ func (t *InsuranceChaincode) closeClaim(stub shim.ChaincodeStubInterface,  calle
 		return nil, errors.New("CLOSE_CLAIM: Incorrect number of arguments. Expecting 1 (claimId)")
 	}
 
-	//Only an insurer can close the claim
-	if (caller_affiliation != ROLE_INSURER) {
-		fmt.Println("CLOSE_CLAIM: Only an insurer can close the claim")
-		return nil, errors.New("CLOSE_CLAIM: Only an insurer can close the claim")
-	}
-
     var theClaim Claim
 
 	theClaim, err := RetrieveClaim(stub , args[0])
 
 	if err != nil {	fmt.Printf("\nCLOSE_CLAIM: Failed to retrieve claim Id: %s", err); return nil, errors.New("CLOSE_CLAIM: Error retrieving claim with claimId = " + args[0]) }
 
-	if theClaim.Details.Status != STATE_SETTLED{
-		fmt.Println("CLOSE_CLAIM: Incorrect STATE, Claim can not be closed if not SETTLED")
-		return nil, errors.New("CLOSE_CLAIM: Incorrect STATE, Claim can not be closed if not SETTLED")
-	}
+	err = CheckClaimTransition(theClaim, ACTION_CLOSE_CLAIM)
+	if err != nil { return nil, err }
 
 	if TOTAL_LOSS == theClaim.Details.Settlement.Decision{
 		return t.closeTotalLossClaim(stub, theClaim)
 	}
-	fmt.Printf("\nCLOSE_CLAIM: Unsuported Close Operation. Currently the System can only Close Total_Loss Claims.\n")
-	return nil, errors.New("CLOSE_CLAIM: Unsuported Close Operation. Currently the System can only Close Total_Loss Claims.")
+	if REPAIR == theClaim.Details.Settlement.Decision{
+		return t.closeRepairClaim(stub, theClaim)
+	}
+	fmt.Printf("\nCLOSE_CLAIM: Unsuported Close Operation. Unknown settlement decision: %s\n", theClaim.Details.Settlement.Decision)
+	return nil, errors.New("CLOSE_CLAIM: Unsuported Close Operation. Unknown settlement decision: " + theClaim.Details.Settlement.Decision)
 }
 
 //============================================================================================================
@@ -988,12 +2195,37 @@ func (t *InsuranceChaincode) closeTotalLossClaim(stub shim.ChaincodeStubInterfac
 		return nil, errors.New("CLOSE_CLAIM: Error: open payment out. Total_Loss Claim can not be closed with open payment out")
 	}
 
-	theClaim.Details.Status = STATUS_CLOSED
+	err := theClaim.TransitionTo(ACTION_CLOSE_CLAIM, STATUS_CLOSED)
+	if err != nil { return nil, err }
+
 	SaveClaim(stub, theClaim)
 
 	return nil, nil
 }
 
+//============================================================================================================
+// Closes a repair claim once the work has been completed and the garage has been paid
+//============================================================================================================
+func (t *InsuranceChaincode) closeRepairClaim(stub shim.ChaincodeStubInterface,  theClaim Claim) ([]byte, error){
+
+	if theClaim.Details.Repair.WorkStatus != STATUS_CLOSED {
+		fmt.Println("CLOSE_CLAIM: Error: repair work is not complete. Repair Claim can not be closed with open work order")
+		return nil, errors.New("CLOSE_CLAIM: Error: repair work is not complete. Repair Claim can not be closed with open work order")
+	}
+
+	if !theClaim.AreAllPaymentsPaid() {
+		fmt.Println("CLOSE_CLAIM: Error: open payment out. Repair Claim can not be closed with open payment out")
+		return nil, errors.New("CLOSE_CLAIM: Error: open payment out. Repair Claim can not be closed with open payment out")
+	}
+
+	err := theClaim.TransitionTo(ACTION_CLOSE_CLAIM, STATUS_CLOSED)
+	if err != nil { return nil, err }
+
+	_, err = SaveClaim(stub, theClaim)
+
+	return nil, err
+}
+
 func (t *InsuranceChaincode) isVehicleValidForClaim(stub shim.ChaincodeStubInterface,  theClaim Claim, vehicleReg string)(bool){
 
 	//Check policy exists
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPoliceReportLiabilityShares(t *testing.T) {
+	tests := []struct {
+		name			string
+		partyRegs		[]string
+		atFaultRegs		[]string
+		shares			[]int
+	}{
+		{"single party at fault",			[]string{"BP08BRV"},						[]string{"BP08BRV"},				[]int{100}},
+		{"single party not at fault",		[]string{"BP08BRV"},						[]string{},							[]int{0}},
+		{"other party at fault",			[]string{"BP08BRV", "DZ14TYV"},				[]string{"DZ14TYV"},				[]int{0, 100}},
+		{"one of three at fault",			[]string{"BP08BRV", "DZ14TYV", "AB12CDE"},	[]string{"AB12CDE"},				[]int{0, 0, 100}},
+		{"two of three at fault",			[]string{"BP08BRV", "DZ14TYV", "AB12CDE"},	[]string{"BP08BRV", "AB12CDE"},		[]int{50, 0, 50}},
+		{"all three at fault",				[]string{"BP08BRV", "DZ14TYV", "AB12CDE"},	[]string{"AB12CDE", "DZ14TYV", "BP08BRV"},	[]int{34, 33, 33}},
+		{"unknown third party at fault",	[]string{"BP08BRV", UNKNOWN_REGISTRATION},	[]string{UNKNOWN_REGISTRATION},		[]int{0, 100}},
+	}
+
+	for _, test := range tests {
+		report := PoliceReport{AtFaultRegs: test.atFaultRegs}
+
+		shares, err := report.LiabilityShares(test.partyRegs)
+		if err != nil { t.Errorf("%s: %s", test.name, err); continue }
+
+		for index := range test.shares {
+			if shares[index] != test.shares[index] { t.Errorf("%s: expected shares %v, got %v", test.name, test.shares, shares); break }
+		}
+	}
+}
+
+func TestPoliceReportLiabilitySharesRejectsInvalidReports(t *testing.T) {
+	tests := []struct {
+		name			string
+		partyRegs		[]string
+		atFaultRegs		[]string
+	}{
+		{"vehicle not party to the claim",	[]string{"BP08BRV", "DZ14TYV"},		[]string{"AB12CDE"}},
+		{"no one at fault",					[]string{"BP08BRV", "DZ14TYV"},		[]string{}},
+	}
+
+	for _, test := range tests {
+		report := PoliceReport{AtFaultRegs: test.atFaultRegs}
+
+		_, err := report.LiabilityShares(test.partyRegs)
+		if err == nil { t.Errorf("Expected a police report with %s to be rejected", test.name) }
+	}
+}
@@ -0,0 +1,96 @@
+//go:build memorystorage
+// +build memorystorage
+
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//==============================================================================================================================
+//	MemoryStorage - Holds objects in memory, for unit tests.  Nothing is written to the ledger, so every peer would hold
+//		different objects; this backend is only built with the memorystorage build tag and must never be included in a
+//		deployed chaincode.
+//==============================================================================================================================
+type MemoryStorage struct {
+	values		map[string][]byte
+	lock		*sync.Mutex
+}
+
+const   STORAGE_BACKEND_MEMORY	= "memory"
+
+var memoryStorage = NewMemoryStorage()
+
+func init() {
+	storageBackends[STORAGE_BACKEND_MEMORY] = memoryStorage
+}
+
+func NewMemoryStorage() (MemoryStorage) {
+	return MemoryStorage{map[string][]byte{}, &sync.Mutex{}}
+}
+
+//=================================================================================================================================
+//	 ResetMemoryStorage	-	Removes everything held in memory, so each unit test can start from empty storage
+//=================================================================================================================================
+func ResetMemoryStorage() {
+	memoryStorage.lock.Lock()
+	defer memoryStorage.lock.Unlock()
+
+	for key := range memoryStorage.values {
+		delete(memoryStorage.values, key)
+	}
+}
+
+func (t MemoryStorage) Retrieve(stub shim.ChaincodeStubInterface, key string) ([]byte, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.values[key], nil
+}
+
+func (t MemoryStorage) Save(stub shim.ChaincodeStubInterface, key string, value []byte) (error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.values[key] = value
+
+	return nil
+}
+
+func (t MemoryStorage) Delete(stub shim.ChaincodeStubInterface, key string) (error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.values, key)
+
+	return nil
+}
+
+func (t MemoryStorage) List(stub shim.ChaincodeStubInterface, prefix string) ([]KeyValue, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	keys := []string{}
+
+	for key := range t.values {
+		if strings.HasPrefix(key, prefix) { keys = append(keys, key) }
+	}
+
+	//Listed in key order, as the other backends are
+	sort.Strings(keys)
+
+	entries := []KeyValue{}
+
+	for _, key := range keys {
+		entries = append(entries, KeyValue{Key: key, Value: string(t.values[key])})
+	}
+
+	return entries, nil
+}
+
+func (t MemoryStorage) Flush(stub shim.ChaincodeStubInterface) (error) {
+	return nil
+}
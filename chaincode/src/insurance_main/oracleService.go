@@ -12,6 +12,9 @@ import (
 //Stores the oracle host address
 const ORACLE_HOST_KEY = "oracleHost"
 
+//Prefix of the key the claim id of an oracle request is stored under, followed by the request id (the txId)
+const ORACLE_REQUEST_KEY_PREFIX = "oracleRequest:"
+
 func InitOracleService(stub shim.ChaincodeStubInterface, args []string) {
 	if (len(args) >= 2) {
 		stub.PutState(ORACLE_HOST_KEY, []byte(args[1]))
@@ -0,0 +1,42 @@
+//go:build identityinjector && memorystorage
+// +build identityinjector,memorystorage
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVehicleValueCallbackChecksArguments(t *testing.T) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_MEMORY)
+
+	stub.nextTx()
+	OpenTxContext(stub, "test", "claimant1", ROLE_POLICY_HOLDER)
+
+	claim := NewClaim("C1", "P1", "Hit a tree", "2017-01-01", "single_party")
+	claim.Details.Status = STATE_PENDING_AFTER_REPORT_DECISION
+
+	_, err := SaveClaim(stub, claim)
+	CloseTxContext(stub)
+	if err != nil { t.Fatal(err) }
+
+	stub.PutState(ORACLE_REQUEST_KEY_PREFIX + "request1", []byte("C1"))
+
+	InjectRole("oracle", ROLE_ORACLE)
+
+	for _, args := range [][]string{{}, {"request1"}, {"request1", "5000", "extra"}} {
+		stub.nextTx()
+
+		_, err = cc.Invoke(stub, "vehicleValueOracleCallback", args)
+		if err == nil || !strings.Contains(err.Error(), "Incorrect number of arguments") { t.Fatalf("Expected %q to be rejected, got: %v", args, err) }
+	}
+
+	stub.nextTx()
+
+	_, err = cc.Invoke(stub, "vehicleValueOracleCallback", []string{"request1", "5000"})
+	if err != nil { t.Fatal(err) }
+
+	claim, _ = RetrieveClaim(stub, "C1")
+	if claim.Details.Status != STATE_ORDER_GARAGE_WORK { t.Fatalf("Unexpected state: %s", claim.Details.Status) }
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"errors"
+	"fmt"
+)
+
+//==============================================================================================================================
+//	Permission - The roles allowed to call an invoke or query function, and any ownership the caller must have of the
+//		object given as the first argument.  Superusers are not subject to ownership constraints.
+//==============================================================================================================================
+type Permission struct {
+	Function		string		`json:"function"`
+	Roles			[]string	`json:"roles"`
+	Ownership		string		`json:"ownership"`
+}
+
+//==============================================================================================================================
+//	Permissions - The permissions table saved on the ledger, with an entry for every function that can be called.
+//		Functions without an entry can't be called by anyone.
+//==============================================================================================================================
+type Permissions struct {
+	Type			string			`json:"type"`
+	Entries			[]Permission	`json:"entries"`
+}
+
+//==============================================================================================================================
+//	 Ownership constraints
+//		policyOwner		-	The first argument is a policy id, and the caller must own the policy
+//		claimOwner		-	The first argument is a claim id, and the caller must own the claim's policy
+//		claimInsurer	-	The first argument is a claim id, and the caller must be the insurer of the claim's policy
+//		claimParty		-	The first argument is a claim id, and the caller must own or be the insurer of the claim's policy
+//==============================================================================================================================
+const   OWNERSHIP_NONE			= ""
+const   OWNERSHIP_POLICY_OWNER	= "policyOwner"
+const   OWNERSHIP_CLAIM_OWNER	= "claimOwner"
+const   OWNERSHIP_CLAIM_INSURER	= "claimInsurer"
+const   OWNERSHIP_CLAIM_PARTY	= "claimParty"
+
+var OWNERSHIP_CONSTRAINTS = []string{OWNERSHIP_NONE, OWNERSHIP_POLICY_OWNER, OWNERSHIP_CLAIM_OWNER, OWNERSHIP_CLAIM_INSURER, OWNERSHIP_CLAIM_PARTY}
+
+var ALL_ROLES = []string{ROLE_POLICY_HOLDER, ROLE_GARAGE, ROLE_INSURER, ROLE_SUPER_USER, ROLE_ORACLE, ROLE_POLICE}
+
+//==============================================================================================================================
+//	 Permission administration functions - Always restricted to superusers, so they are not in the permissions table and
+//	 a superuser can't be locked out of changing it
+//==============================================================================================================================
+const   FUNCTION_SET_PERMISSION			= "setPermission"
+const   FUNCTION_RETRIEVE_PERMISSIONS	= "retrievePermissions"
+
+var PERMISSION_ADMIN_FUNCTIONS = []string{FUNCTION_SET_PERMISSION, FUNCTION_RETRIEVE_PERMISSIONS}
+
+//==============================================================================================================================
+//	 PERMISSIONED_FUNCTIONS - Every invoke and query function that can be given a permission, with the type of object its
+//	 first argument identifies.  An ownership constraint can only be set on a function whose first argument is a policy or
+//	 claim id.  A function added to Invoke or Query must also be added here.
+//==============================================================================================================================
+const   FIRST_ARGUMENT_OTHER	= ""
+const   FIRST_ARGUMENT_POLICY	= "policy"
+const   FIRST_ARGUMENT_CLAIM	= "claim"
+
+var PERMISSIONED_FUNCTIONS = map[string]string{
+	"init":									FIRST_ARGUMENT_OTHER,
+	"addPolicy":							FIRST_ARGUMENT_OTHER,
+	"addVehicle":							FIRST_ARGUMENT_OTHER,
+	"addUser":								FIRST_ARGUMENT_OTHER,
+	"createClaim":							FIRST_ARGUMENT_POLICY,
+	"addGarage":							FIRST_ARGUMENT_OTHER,
+	"suspendGarage":						FIRST_ARGUMENT_OTHER,
+	"reinstateGarage":						FIRST_ARGUMENT_OTHER,
+	"removeGarage":							FIRST_ARGUMENT_OTHER,
+	"updateThirdParty":						FIRST_ARGUMENT_OTHER,
+	ACTION_ASSIGN_GARAGE:					FIRST_ARGUMENT_CLAIM,
+	ACTION_ADD_POLICE_REPORT:				FIRST_ARGUMENT_CLAIM,
+	ACTION_DECLARE_LIABILITY:				FIRST_ARGUMENT_CLAIM,
+	ACTION_SUBMIT_LIABILITY_EVIDENCE:		FIRST_ARGUMENT_CLAIM,
+	ACTION_RESOLVE_LIABILITY_DISPUTE:		FIRST_ARGUMENT_CLAIM,
+	ACTION_ADD_GARAGE_REPORT:				FIRST_ARGUMENT_CLAIM,
+	ACTION_VEHICLE_VALUE_OBTAINED:			FIRST_ARGUMENT_OTHER,
+	ACTION_ORDER_GARAGE_WORK:				FIRST_ARGUMENT_CLAIM,
+	ACTION_CONFIRM_WORK:					FIRST_ARGUMENT_CLAIM,
+	ACTION_AGREE_PAYOUT_AMOUNT:				FIRST_ARGUMENT_CLAIM,
+	ACTION_CONFIRM_PAID_OUT:				FIRST_ARGUMENT_CLAIM,
+	ACTION_CLOSE_CLAIM:						FIRST_ARGUMENT_CLAIM,
+	"retrieveAllPolicies":					FIRST_ARGUMENT_OTHER,
+	"retrieveAllClaims":					FIRST_ARGUMENT_OTHER,
+	"retrieveClaim":						FIRST_ARGUMENT_CLAIM,
+	"retrievePolicy":						FIRST_ARGUMENT_POLICY,
+	"retrievePolicySummaryByRegistration":	FIRST_ARGUMENT_OTHER,
+	"retrieveVehicle":						FIRST_ARGUMENT_OTHER,
+	"retrieveUser":							FIRST_ARGUMENT_OTHER,
+	"retrieveClaimHistory":					FIRST_ARGUMENT_CLAIM,
+	"retrieveClaimStateMachine":			FIRST_ARGUMENT_OTHER,
+	"retrieveAllowedClaimActions":			FIRST_ARGUMENT_CLAIM,
+	"retrieveGarages":						FIRST_ARGUMENT_OTHER,
+}
+
+//=================================================================================================================================
+//	 DefaultPermissions	-	The permissions each function has until a superuser changes them.  The roles for claim actions
+//							are seeded from the default roles in the claim state machine.
+//=================================================================================================================================
+func DefaultPermissions() (Permissions) {
+	var permissions Permissions
+
+	permissions.Type = "permissions"
+	permissions.Entries = []Permission{
+		{"init",						[]string{ROLE_SUPER_USER},						OWNERSHIP_NONE},
+		{"addPolicy",					[]string{ROLE_INSURER},							OWNERSHIP_NONE},
+		{"addVehicle",					[]string{ROLE_INSURER, ROLE_SUPER_USER},		OWNERSHIP_NONE},
+		{"addUser",						[]string{ROLE_INSURER, ROLE_SUPER_USER},		OWNERSHIP_NONE},
+		{"createClaim",					[]string{ROLE_POLICY_HOLDER},					OWNERSHIP_POLICY_OWNER},
+		{"addGarage",					[]string{ROLE_INSURER, ROLE_SUPER_USER},		OWNERSHIP_NONE},
+		{"suspendGarage",				[]string{ROLE_INSURER, ROLE_SUPER_USER},		OWNERSHIP_NONE},
+		{"reinstateGarage",				[]string{ROLE_INSURER, ROLE_SUPER_USER},		OWNERSHIP_NONE},
+		{"removeGarage",				[]string{ROLE_INSURER, ROLE_SUPER_USER},		OWNERSHIP_NONE},
+		{"updateThirdParty",			[]string{ROLE_INSURER, ROLE_SUPER_USER},		OWNERSHIP_NONE},
+		{ACTION_ADD_POLICE_REPORT,		claimActionRoles(ACTION_ADD_POLICE_REPORT),			OWNERSHIP_NONE},
+		{ACTION_DECLARE_LIABILITY,		claimActionRoles(ACTION_DECLARE_LIABILITY),			OWNERSHIP_CLAIM_OWNER},
+		{ACTION_SUBMIT_LIABILITY_EVIDENCE,	claimActionRoles(ACTION_SUBMIT_LIABILITY_EVIDENCE),	OWNERSHIP_NONE},
+		{ACTION_RESOLVE_LIABILITY_DISPUTE,	claimActionRoles(ACTION_RESOLVE_LIABILITY_DISPUTE),	OWNERSHIP_NONE},
+		{ACTION_ASSIGN_GARAGE,			claimActionRoles(ACTION_ASSIGN_GARAGE),				OWNERSHIP_CLAIM_PARTY},
+		{ACTION_ADD_GARAGE_REPORT,		claimActionRoles(ACTION_ADD_GARAGE_REPORT),			OWNERSHIP_NONE},
+		{ACTION_VEHICLE_VALUE_OBTAINED,	claimActionRoles(ACTION_VEHICLE_VALUE_OBTAINED),	OWNERSHIP_NONE},
+		{ACTION_ORDER_GARAGE_WORK,		claimActionRoles(ACTION_ORDER_GARAGE_WORK),			OWNERSHIP_CLAIM_INSURER},
+		{ACTION_CONFIRM_WORK,			claimActionRoles(ACTION_CONFIRM_WORK),				OWNERSHIP_NONE},
+		{ACTION_AGREE_PAYOUT_AMOUNT,	claimActionRoles(ACTION_AGREE_PAYOUT_AMOUNT),		OWNERSHIP_CLAIM_OWNER},
+		{ACTION_CONFIRM_PAID_OUT,		claimActionRoles(ACTION_CONFIRM_PAID_OUT),			OWNERSHIP_NONE},
+		{ACTION_CLOSE_CLAIM,			claimActionRoles(ACTION_CLOSE_CLAIM),				OWNERSHIP_CLAIM_INSURER},
+
+		//Queries only return objects relevant to the caller, so any role can call them, except the cross-insurer policy lookup
+		{"retrieveAllPolicies",			ALL_ROLES,		OWNERSHIP_NONE},
+		{"retrieveAllClaims",			ALL_ROLES,		OWNERSHIP_NONE},
+		{"retrieveClaim",				ALL_ROLES,		OWNERSHIP_NONE},
+		{"retrievePolicy",				ALL_ROLES,		OWNERSHIP_NONE},
+		{"retrievePolicySummaryByRegistration",	[]string{ROLE_INSURER, ROLE_SUPER_USER},	OWNERSHIP_NONE},
+		{"retrieveVehicle",				ALL_ROLES,		OWNERSHIP_NONE},
+		{"retrieveUser",				ALL_ROLES,		OWNERSHIP_NONE},
+		{"retrieveClaimHistory",		ALL_ROLES,		OWNERSHIP_NONE},
+		{"retrieveClaimStateMachine",	ALL_ROLES,		OWNERSHIP_NONE},
+		{"retrieveAllowedClaimActions",	ALL_ROLES,		OWNERSHIP_NONE},
+		{"retrieveGarages",				ALL_ROLES,		OWNERSHIP_NONE},
+	}
+
+	return permissions
+}
+
+//=================================================================================================================================
+//	 claimActionRoles	-	Gets the default roles for the action in any state of the claim state machine
+//=================================================================================================================================
+func claimActionRoles(action string) ([]string) {
+	roles := []string{}
+
+	for _, transition := range CLAIM_TRANSITIONS {
+		if transition.Action != action { continue }
+
+		for _, role := range transition.DefaultRoles {
+			if indexOf(roles, role) < 0 { roles = append(roles, role) }
+		}
+	}
+
+	return roles
+}
+
+//=================================================================================================================================
+//	 ownershipFirstArgument	-	Gets the type of object the first argument must identify for the ownership constraint
+//=================================================================================================================================
+func ownershipFirstArgument(ownership string) (string) {
+	if ownership == OWNERSHIP_POLICY_OWNER { return FIRST_ARGUMENT_POLICY }
+
+	if ownership == OWNERSHIP_NONE { return FIRST_ARGUMENT_OTHER }
+
+	return FIRST_ARGUMENT_CLAIM
+}
+
+//=================================================================================================================================
+//	 FindPermission	-	Finds the permission for a function
+//=================================================================================================================================
+func (t *Permissions) FindPermission(function string) (Permission, bool) {
+	for _, permission := range t.Entries {
+		if permission.Function == function { return permission, true }
+	}
+
+	return Permission{}, false
+}
+
+//=================================================================================================================================
+//	 SetPermission	-	Adds the permission, replacing any existing permission for the same function
+//=================================================================================================================================
+func (t *Permissions) SetPermission(permission Permission) {
+	for i, existing := range t.Entries {
+		if existing.Function == permission.Function { t.Entries[i] = permission; return }
+	}
+
+	t.Entries = append(t.Entries, permission)
+}
+
+//=================================================================================================================================
+//	 InitPermissions	-	Saves the default permissions for any function that isn't yet in the permissions table.  Existing
+//							permissions are kept, so that changes made by a superuser survive the chaincode being
+//							initialised again.
+//=================================================================================================================================
+func InitPermissions(stub shim.ChaincodeStubInterface) (error) {
+	permissions, err := RetrievePermissions(stub)
+	if IsNotFoundError(err) {
+		permissions = Permissions{Type: "permissions", Entries: []Permission{}}
+	} else if err != nil {
+		return err
+	}
+
+	for _, permission := range DefaultPermissions().Entries {
+		if _, found := permissions.FindPermission(permission.Function); !found {
+			permissions.SetPermission(permission)
+		}
+	}
+
+	_, err = SavePermissions(stub, permissions)
+
+	return err
+}
+
+//=================================================================================================================================
+//	 CheckPermission	-	Checks that the caller's role is allowed to call the function, and that the caller meets the
+//							function's ownership constraint
+//=================================================================================================================================
+func (t *InsuranceChaincode) CheckPermission(stub shim.ChaincodeStubInterface, function string, caller string, callerAffiliation string, args []string) (error) {
+
+	if indexOf(PERMISSION_ADMIN_FUNCTIONS, function) >= 0 {
+		if callerAffiliation != ROLE_SUPER_USER { return t.notPermitted(function, callerAffiliation) }
+		return nil
+	}
+
+	permissions, err := RetrievePermissions(stub)
+	if err != nil { fmt.Printf("CheckPermission: Unable to retrieve permissions: %s", err); return errors.New("Unable to retrieve permissions") }
+
+	permission, found := permissions.FindPermission(function)
+
+	if !found || indexOf(permission.Roles, callerAffiliation) < 0 { return t.notPermitted(function, callerAffiliation) }
+
+	if permission.Ownership == OWNERSHIP_NONE || callerAffiliation == ROLE_SUPER_USER { return nil }
+
+	if len(args) == 0 { return t.notPermitted(function, callerAffiliation) }
+
+	owns, err := t.meetsOwnershipConstraint(stub, permission.Ownership, args[0], caller)
+	if err != nil { return err }
+
+	if !owns {
+		fmt.Println("NOT_PERMITTED: " + caller + " does not meet the ownership constraint " + permission.Ownership + " of " + function + " for " + args[0])
+		return errors.New("NOT_PERMITTED: " + function + " requires the caller to be the " + permission.Ownership + " of " + args[0])
+	}
+
+	return nil
+}
+
+func (t *InsuranceChaincode) notPermitted(function string, callerAffiliation string) (error) {
+	fmt.Println("NOT_PERMITTED: " + function + " is not permitted for role " + callerAffiliation)
+	return errors.New("NOT_PERMITTED: " + function + " is not permitted for role " + callerAffiliation)
+}
+
+//=================================================================================================================================
+//	 meetsOwnershipConstraint	-	Checks the caller's ownership of the policy or claim with the given id
+//=================================================================================================================================
+func (t *InsuranceChaincode) meetsOwnershipConstraint(stub shim.ChaincodeStubInterface, ownership string, id string, caller string) (bool, error) {
+	policyId := id
+
+	if ownership == OWNERSHIP_CLAIM_OWNER || ownership == OWNERSHIP_CLAIM_INSURER || ownership == OWNERSHIP_CLAIM_PARTY {
+		claim, err := RetrieveClaim(stub, id)
+		if err != nil { fmt.Printf("meetsOwnershipConstraint: Unable to retrieve claim %s: %s", id, err); return false, err }
+
+		policyId = claim.Relations.RelatedPolicy
+	}
+
+	policy, err := RetrievePolicy(stub, policyId)
+	if err != nil { fmt.Printf("meetsOwnershipConstraint: Unable to retrieve policy %s: %s", policyId, err); return false, err }
+
+	if ownership == OWNERSHIP_CLAIM_INSURER { return policy.Relations.Insurer == caller, nil }
+
+	if ownership == OWNERSHIP_CLAIM_PARTY { return policy.Relations.Owner == caller || policy.Relations.Insurer == caller, nil }
+
+	return policy.Relations.Owner == caller, nil
+}
+
+//=================================================================================================================================
+//	 setPermission	-	Sets the roles and ownership constraint for a function, then emits a permissions changed event.
+//						Only callable by a superuser.
+//		args - function, roles (JSON array), ownership
+//=================================================================================================================================
+func (t *InsuranceChaincode) setPermission(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, args []string) ([]byte, error) {
+
+	fmt.Println("running setPermission()")
+
+	if len(args) != 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting 3 (Function, Roles, Ownership)")
+	}
+
+	if indexOf(PERMISSION_ADMIN_FUNCTIONS, args[0]) >= 0 {
+		return nil, errors.New("The permission for " + args[0] + " can't be changed")
+	}
+
+	firstArgument, found := PERMISSIONED_FUNCTIONS[args[0]]
+	if !found { return nil, errors.New("Unknown function: " + args[0]) }
+
+	var roles []string
+
+	err := unmarshal([]byte(args[1]), &roles)
+	if err != nil { fmt.Printf("setPermission: Invalid roles: %s", err); return nil, errors.New("Invalid value passed for roles, expecting a JSON array") }
+
+	for _, role := range roles {
+		if indexOf(ALL_ROLES, role) < 0 { return nil, errors.New("Unknown role: " + role) }
+	}
+
+	if indexOf(OWNERSHIP_CONSTRAINTS, args[2]) < 0 {
+		return nil, errors.New("Unknown ownership constraint: " + args[2])
+	}
+
+	//The ownership is checked against the first argument, so it must identify the right type of object
+	if args[2] != OWNERSHIP_NONE && ownershipFirstArgument(args[2]) != firstArgument {
+		return nil, errors.New("The ownership constraint " + args[2] + " can't be set on " + args[0] + ", its first argument is not a " + ownershipFirstArgument(args[2]) + " id")
+	}
+
+	permissions, err := RetrievePermissions(stub)
+	if err != nil { return nil, err }
+
+	permission := Permission{args[0], roles, args[2]}
+
+	permissions.SetPermission(permission)
+
+	_, err = SavePermissions(stub, permissions)
+	if err != nil { return nil, err }
+
+	event := NewPermissionsChangedEvent(permission, caller)
+
+	eventBytes, err := marshall(&event)
+	if err != nil {
+		fmt.Printf("\nUnable to marshall permissions changed event for function: " + permission.Function + " : %s", err)
+	} else {
+		stub.SetEvent(event.Type, eventBytes)
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 retrievePermissionsJSON	-	Returns the permissions table.  Only callable by a superuser.
+//=================================================================================================================================
+func (t *InsuranceChaincode) retrievePermissionsJSON(stub shim.ChaincodeStubInterface) ([]byte, error) {
+	permissions, err := RetrievePermissions(stub)
+	if err != nil { return nil, err }
+
+	return marshall(permissions)
+}
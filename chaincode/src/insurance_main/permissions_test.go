@@ -0,0 +1,314 @@
+//go:build identityinjector && memorystorage
+// +build identityinjector,memorystorage
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+//Initialises the chaincode with the reference data in memory, plus claim C1 on policy P1 (claimant1, insurer1)
+func newPermissionsTestChaincode(t *testing.T) (*InsuranceChaincode, *testStub) {
+	cc, stub := newTestChaincode(t, STORAGE_BACKEND_MEMORY)
+
+	stub.nextTx()
+	OpenTxContext(stub, "test", "claimant1", ROLE_POLICY_HOLDER)
+
+	_, err := SaveClaim(stub, NewClaim("C1", "P1", "Hit a tree", "2017-01-01", "single_party"))
+	CloseTxContext(stub)
+	if err != nil { t.Fatal(err) }
+
+	return cc, stub
+}
+
+func TestDefaultPermissions(t *testing.T) {
+	tests := []struct {
+		function	string
+		caller		string
+		role		string
+		args		[]string
+		allowed		bool
+	}{
+		{"addPolicy",							"insurer1",		ROLE_INSURER,			nil,			true},
+		{"addPolicy",							"claimant1",	ROLE_POLICY_HOLDER,		nil,			false},
+		{"addPolicy",							"admin",		ROLE_SUPER_USER,		nil,			false},
+		{"addVehicle",							"admin",		ROLE_SUPER_USER,		nil,			true},
+		{"addGarage",							"garage1",		ROLE_GARAGE,			nil,			false},
+		{ACTION_ADD_GARAGE_REPORT,				"garage1",		ROLE_GARAGE,			nil,			true},
+		{ACTION_ADD_GARAGE_REPORT,				"insurer1",		ROLE_INSURER,			nil,			false},
+		{ACTION_ADD_POLICE_REPORT,				"police1",		ROLE_POLICE,			nil,			true},
+		{ACTION_RESOLVE_LIABILITY_DISPUTE,		"insurer1",		ROLE_INSURER,			nil,			false},
+		{"retrieveAllClaims",					"police1",		ROLE_POLICE,			nil,			true},
+		{"retrievePolicySummaryByRegistration",	"insurer2",		ROLE_INSURER,			nil,			true},
+		{"retrievePolicySummaryByRegistration",	"claimant1",	ROLE_POLICY_HOLDER,		nil,			false},
+		{"notAFunction",						"admin",		ROLE_SUPER_USER,		nil,			false},
+		{"addPolicy",							"insurer1",		"underwriter",			nil,			false},
+		{FUNCTION_SET_PERMISSION,				"admin",		ROLE_SUPER_USER,		nil,			true},
+		{FUNCTION_SET_PERMISSION,				"insurer1",		ROLE_INSURER,			nil,			false},
+		{FUNCTION_RETRIEVE_PERMISSIONS,			"insurer1",		ROLE_INSURER,			nil,			false},
+
+		//policyOwner - the caller must own the policy
+		{"createClaim",							"claimant1",	ROLE_POLICY_HOLDER,		[]string{"P1"},	true},
+		{"createClaim",							"claimant2",	ROLE_POLICY_HOLDER,		[]string{"P1"},	false},
+		{"createClaim",							"claimant1",	ROLE_POLICY_HOLDER,		[]string{"P9"},	false},
+		{"createClaim",							"claimant1",	ROLE_POLICY_HOLDER,		[]string{},		false},
+
+		//claimOwner - the caller must own the claim's policy
+		{ACTION_DECLARE_LIABILITY,				"claimant1",	ROLE_POLICY_HOLDER,		[]string{"C1"},	true},
+		{ACTION_DECLARE_LIABILITY,				"claimant2",	ROLE_POLICY_HOLDER,		[]string{"C1"},	false},
+		{ACTION_AGREE_PAYOUT_AMOUNT,			"claimant2",	ROLE_POLICY_HOLDER,		[]string{"C1"},	false},
+		{ACTION_DECLARE_LIABILITY,				"claimant1",	ROLE_POLICY_HOLDER,		[]string{"C9"},	false},
+
+		//claimInsurer - the caller must be the insurer of the claim's policy
+		{ACTION_ORDER_GARAGE_WORK,				"insurer1",		ROLE_INSURER,			[]string{"C1"},	true},
+		{ACTION_ORDER_GARAGE_WORK,				"insurer2",		ROLE_INSURER,			[]string{"C1"},	false},
+		{ACTION_CLOSE_CLAIM,					"insurer2",		ROLE_INSURER,			[]string{"C1"},	false},
+
+		//claimParty - the caller must own or be the insurer of the claim's policy
+		{"assignGarage",						"claimant1",	ROLE_POLICY_HOLDER,		[]string{"C1"},	true},
+		{"assignGarage",						"insurer1",		ROLE_INSURER,			[]string{"C1"},	true},
+		{"assignGarage",						"claimant2",	ROLE_POLICY_HOLDER,		[]string{"C1"},	false},
+		{"assignGarage",						"insurer2",		ROLE_INSURER,			[]string{"C1"},	false},
+
+		//Superusers are not subject to ownership constraints
+		{"assignGarage",						"admin",		ROLE_SUPER_USER,		[]string{"C1"},	true},
+	}
+
+	cc, stub := newPermissionsTestChaincode(t)
+
+	for _, test := range tests {
+		err := cc.CheckPermission(stub, test.function, test.caller, test.role, test.args)
+
+		if test.allowed && err != nil {
+			t.Errorf("%s should be permitted for %s (%s) with %q: %s", test.function, test.caller, test.role, test.args, err)
+		} else if !test.allowed && err == nil {
+			t.Errorf("%s should not be permitted for %s (%s) with %q", test.function, test.caller, test.role, test.args)
+		}
+	}
+}
+
+func TestDefaultPermissionsAreForPermissionedFunctions(t *testing.T) {
+	defaults := DefaultPermissions()
+
+	for _, permission := range defaults.Entries {
+		firstArgument, found := PERMISSIONED_FUNCTIONS[permission.Function]
+		if !found { t.Errorf("%s is not a permissioned function", permission.Function); continue }
+
+		if permission.Ownership != OWNERSHIP_NONE && ownershipFirstArgument(permission.Ownership) != firstArgument {
+			t.Errorf("The ownership constraint of %s doesn't match its first argument", permission.Function)
+		}
+	}
+
+	for function := range PERMISSIONED_FUNCTIONS {
+		if _, found := defaults.FindPermission(function); !found { t.Errorf("%s has no default permission", function) }
+	}
+}
+
+func TestSetPermissionIsOnlyPermittedForSuperusers(t *testing.T) {
+	cc, stub := newPermissionsTestChaincode(t)
+
+	InjectRole("insurer1", ROLE_INSURER)
+
+	_, err := cc.Invoke(stub, FUNCTION_SET_PERMISSION, []string{"addPolicy", `["insurer","policyholder"]`, ""})
+	if err == nil || !strings.HasPrefix(err.Error(), "NOT_PERMITTED") { t.Fatalf("Expected setPermission to be refused, got: %v", err) }
+
+	if _, found := stub.events[EVENT_TYPE_PERMISSIONS_CHANGED]; found { t.Fatal("A permissions changed event was emitted for a refused change") }
+
+	err = cc.CheckPermission(stub, "addPolicy", "claimant1", ROLE_POLICY_HOLDER, nil)
+	if err == nil { t.Fatal("The permissions table was changed by an insurer") }
+
+	_, err = cc.Query(stub, FUNCTION_RETRIEVE_PERMISSIONS, []string{})
+	if err == nil || !strings.HasPrefix(err.Error(), "NOT_PERMITTED") { t.Fatalf("Expected retrievePermissions to be refused, got: %v", err) }
+}
+
+func TestSetPermissionRejectsInvalidPermissions(t *testing.T) {
+	tests := []struct {
+		name		string
+		args		[]string
+	}{
+		{"unknown role",					[]string{"addPolicy", `["insurer","underwriter"]`, ""}},
+		{"unknown ownership",				[]string{"addPolicy", `["insurer"]`, "policyInsurer"}},
+		{"roles not a JSON array",			[]string{"addPolicy", "insurer", ""}},
+		{"permission admin function",		[]string{FUNCTION_SET_PERMISSION, `["insurer"]`, ""}},
+		{"missing ownership argument",		[]string{"addPolicy", `["insurer"]`}},
+		{"unknown function",				[]string{"notAFunction", `["insurer"]`, ""}},
+		{"claim ownership of a policy",		[]string{"addPolicy", `["insurer"]`, OWNERSHIP_CLAIM_OWNER}},
+		{"policy ownership of a claim",		[]string{ACTION_CLOSE_CLAIM, `["insurer"]`, OWNERSHIP_POLICY_OWNER}},
+		{"ownership without an id",			[]string{"retrieveAllClaims", `["insurer"]`, OWNERSHIP_CLAIM_PARTY}},
+	}
+
+	cc, stub := newPermissionsTestChaincode(t)
+
+	InjectRole("admin", ROLE_SUPER_USER)
+
+	for _, test := range tests {
+		stub.nextTx()
+
+		_, err := cc.Invoke(stub, FUNCTION_SET_PERMISSION, test.args)
+		if err == nil { t.Errorf("Expected setPermission with %s to be rejected", test.name) }
+
+		if _, found := stub.events[EVENT_TYPE_PERMISSIONS_CHANGED]; found { t.Errorf("A permissions changed event was emitted for %s", test.name) }
+	}
+
+	//The table is unchanged
+	permissions, err := RetrievePermissions(stub)
+	if err != nil { t.Fatal(err) }
+
+	permission, _ := permissions.FindPermission("addPolicy")
+	if len(permission.Roles) != 1 || permission.Roles[0] != ROLE_INSURER || permission.Ownership != OWNERSHIP_NONE {
+		t.Fatalf("The addPolicy permission was changed: %+v", permission)
+	}
+}
+
+func TestSetPermissionChangesTableAndEmitsEvent(t *testing.T) {
+	cc, stub := newPermissionsTestChaincode(t)
+
+	InjectRole("admin", ROLE_SUPER_USER)
+
+	_, err := cc.Invoke(stub, FUNCTION_SET_PERMISSION, []string{"addPolicy", `["insurer","superuser"]`, OWNERSHIP_NONE})
+	if err != nil { t.Fatal(err) }
+
+	payload, found := stub.events[EVENT_TYPE_PERMISSIONS_CHANGED]
+	if !found { t.Fatal("No permissions changed event was emitted") }
+
+	var event PermissionsChangedEvent
+
+	err = unmarshal(payload, &event)
+	if err != nil { t.Fatal(err) }
+
+	if event.Type != EVENT_TYPE_PERMISSIONS_CHANGED || event.Function != "addPolicy" || event.ChangedBy != "admin" ||
+		len(event.Roles) != 2 || event.Roles[1] != ROLE_SUPER_USER || event.Ownership != OWNERSHIP_NONE {
+		t.Fatalf("Unexpected event: %+v", event)
+	}
+
+	err = cc.CheckPermission(stub, "addPolicy", "admin", ROLE_SUPER_USER, nil)
+	if err != nil { t.Fatalf("addPolicy should now be permitted for superusers: %s", err) }
+
+	//Adding an ownership constraint to a function that had none
+	stub.nextTx()
+
+	_, err = cc.Invoke(stub, FUNCTION_SET_PERMISSION, []string{"retrievePolicy", `["policyholder"]`, OWNERSHIP_POLICY_OWNER})
+	if err != nil { t.Fatal(err) }
+
+	if cc.CheckPermission(stub, "retrievePolicy", "claimant2", ROLE_POLICY_HOLDER, []string{"P1"}) == nil { t.Fatal("claimant2 should not own P1") }
+	if cc.CheckPermission(stub, "retrievePolicy", "insurer1", ROLE_INSURER, []string{"P1"}) == nil { t.Fatal("Insurers were removed from retrievePolicy") }
+	if cc.CheckPermission(stub, "retrievePolicy", "claimant1", ROLE_POLICY_HOLDER, []string{"P1"}) != nil { t.Fatal("claimant1 owns P1") }
+
+	//Changes survive the chaincode being initialised again
+	stub.nextTx()
+
+	_, err = cc.Init(stub, "init", []string{})
+	if err != nil { t.Fatal(err) }
+
+	err = cc.CheckPermission(stub, "addPolicy", "admin", ROLE_SUPER_USER, nil)
+	if err != nil { t.Fatalf("The change was lost on Init: %s", err) }
+}
+
+func TestSetPermissionWidensClaimActions(t *testing.T) {
+	cc, stub := newPermissionsTestChaincode(t)
+
+	stub.nextTx()
+	OpenTxContext(stub, "test", "claimant1", ROLE_POLICY_HOLDER)
+
+	for _, claimId := range []string{"C2", "C3"} {
+		claim := NewClaim(claimId, "P1", "Hit a tree", "2017-01-01", "single_party")
+		claim.Details.Status = STATE_AWAITING_POLICE_REPORT
+
+		_, err := SaveClaim(stub, claim)
+		if err != nil { t.Fatal(err) }
+	}
+	CloseTxContext(stub)
+
+	InjectRole("insurer1", ROLE_INSURER)
+	stub.nextTx()
+
+	_, err := cc.Invoke(stub, ACTION_ADD_POLICE_REPORT, []string{"C2", "Hit a tree", "1", "2", "BP08BRV"})
+	if err == nil || !strings.HasPrefix(err.Error(), "NOT_PERMITTED") { t.Fatalf("Expected addPoliceReport to be refused for insurers, got: %v", err) }
+
+	//Only the permissions table decides who can perform a claim action
+	InjectRole("admin", ROLE_SUPER_USER)
+	stub.nextTx()
+
+	_, err = cc.Invoke(stub, FUNCTION_SET_PERMISSION, []string{ACTION_ADD_POLICE_REPORT, `["police","insurer","superuser"]`, OWNERSHIP_CLAIM_INSURER})
+	if err != nil { t.Fatal(err) }
+
+	InjectRole("insurer1", ROLE_INSURER)
+	stub.nextTx()
+
+	_, err = cc.Invoke(stub, ACTION_ADD_POLICE_REPORT, []string{"C2", "Hit a tree", "1", "2", "BP08BRV"})
+	if err != nil { t.Fatalf("addPoliceReport should now be permitted for the claim's insurer: %s", err) }
+
+	//Superusers are not subject to the ownership constraint
+	InjectRole("admin", ROLE_SUPER_USER)
+	stub.nextTx()
+
+	_, err = cc.Invoke(stub, ACTION_ADD_POLICE_REPORT, []string{"C3", "Hit a tree", "1", "2", "BP08BRV"})
+	if err != nil { t.Fatalf("addPoliceReport should now be permitted for superusers: %s", err) }
+
+	for _, claimId := range []string{"C2", "C3"} {
+		claim, _ := RetrieveClaim(stub, claimId)
+		if claim.Details.Status != STATE_AWAITING_GARAGE_REPORT { t.Fatalf("Unexpected state of claim %s: %s", claimId, claim.Details.Status) }
+	}
+}
+
+func allowedClaimActions(t *testing.T, cc *InsuranceChaincode, stub *testStub, claimId string) ([]string) {
+	bytes, err := cc.Query(stub, "retrieveAllowedClaimActions", []string{claimId})
+	if err != nil { t.Fatal(err) }
+
+	var transitions []ClaimTransition
+
+	err = unmarshal(bytes, &transitions)
+	if err != nil { t.Fatal(err) }
+
+	actions := []string{}
+	for _, transition := range transitions { actions = append(actions, transition.Action) }
+
+	return actions
+}
+
+func TestAllowedClaimActionsFollowPermissions(t *testing.T) {
+	cc, stub := newPermissionsTestChaincode(t)
+
+	stub.nextTx()
+	OpenTxContext(stub, "test", "claimant1", ROLE_POLICY_HOLDER)
+
+	claim := NewClaim("C2", "P1", "Hit a tree", "2017-01-01", "single_party")
+	claim.Details.Status = STATE_AWAITING_GARAGE_REPORT
+
+	_, err := SaveClaim(stub, claim)
+	CloseTxContext(stub)
+	if err != nil { t.Fatal(err) }
+
+	InjectRole("claimant1", ROLE_POLICY_HOLDER)
+	if actions := allowedClaimActions(t, cc, stub, "C2"); len(actions) != 1 || actions[0] != ACTION_ASSIGN_GARAGE {
+		t.Fatalf("Unexpected actions for claimant1: %q", actions)
+	}
+
+	//Restricting assignGarage to the claim's insurer removes it for the claimant, even though their role is still allowed
+	InjectRole("admin", ROLE_SUPER_USER)
+	stub.nextTx()
+
+	_, err = cc.Invoke(stub, FUNCTION_SET_PERMISSION, []string{ACTION_ASSIGN_GARAGE, `["policyholder","insurer","superuser"]`, OWNERSHIP_CLAIM_INSURER})
+	if err != nil { t.Fatal(err) }
+
+	InjectRole("claimant1", ROLE_POLICY_HOLDER)
+	if actions := allowedClaimActions(t, cc, stub, "C2"); len(actions) != 0 { t.Fatalf("Unexpected actions for claimant1: %q", actions) }
+
+	InjectRole("insurer1", ROLE_INSURER)
+	if actions := allowedClaimActions(t, cc, stub, "C2"); len(actions) != 1 || actions[0] != ACTION_ASSIGN_GARAGE {
+		t.Fatalf("Unexpected actions for insurer1: %q", actions)
+	}
+
+	//Roles added to the table are offered the action too
+	InjectRole("admin", ROLE_SUPER_USER)
+	stub.nextTx()
+
+	_, err = cc.Invoke(stub, FUNCTION_SET_PERMISSION, []string{ACTION_ADD_GARAGE_REPORT, `["garage","insurer"]`, OWNERSHIP_CLAIM_INSURER})
+	if err != nil { t.Fatal(err) }
+
+	InjectRole("insurer1", ROLE_INSURER)
+	if actions := allowedClaimActions(t, cc, stub, "C2"); len(actions) != 2 || actions[1] != ACTION_ADD_GARAGE_REPORT {
+		t.Fatalf("Unexpected actions for insurer1: %q", actions)
+	}
+}
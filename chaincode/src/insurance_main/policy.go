@@ -33,6 +33,18 @@ type PolicyRelations struct {
 
 }
 
+//==============================================================================================================================
+//	PolicySummary - The fields of a policy that an insurer needs to find the insurer of another vehicle involved in an
+//		incident.  The owner and the terms of the policy are left out, as they belong to the other insurer.
+//==============================================================================================================================
+type PolicySummary struct {
+	PolicyId	string			`json:"policyId"`
+	Vehicle		string			`json:"vehicle"`
+	Insurer		string			`json:"insurer"`
+	StartDate	string			`json:"startDate"`
+	EndDate		string			`json:"endDate"`
+}
+
 //=================================================================================================================================
 //	 newPolicy	-	Constructs a new policy
 //=================================================================================================================================
@@ -52,3 +64,18 @@ func NewPolicy(id string, owner string, insurer string, startDate string, endDat
 
 	return policy
 }
+
+//=================================================================================================================================
+//	 NewPolicySummary	-	Constructs the summary of a policy that can be shown to any insurer
+//=================================================================================================================================
+func NewPolicySummary(policy Policy) (PolicySummary) {
+	var summary PolicySummary
+
+	summary.PolicyId = policy.Id
+	summary.Vehicle = policy.Relations.Vehicle
+	summary.Insurer = policy.Relations.Insurer
+	summary.StartDate = policy.Details.StartDate
+	summary.EndDate = policy.Details.EndDate
+
+	return summary
+}
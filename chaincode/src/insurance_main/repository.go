@@ -0,0 +1,383 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"fmt"
+)
+
+//==============================================================================================================================
+//	 Repositories - Save and retrieve each type of object.  The repositories keep objects in the storage backend chosen at
+//	 Init, under the key namespace for their type.
+//==============================================================================================================================
+type PolicyRepository interface {
+	Save(stub shim.ChaincodeStubInterface, policy Policy) (Policy, error)
+	Retrieve(stub shim.ChaincodeStubInterface, id string) (Policy, error)
+	RetrieveAll(stub shim.ChaincodeStubInterface) ([]Policy)
+}
+
+type ClaimRepository interface {
+	//Saving a claim also records the change of status in the claim's history
+	Save(stub shim.ChaincodeStubInterface, claim Claim) (Claim, error)
+	Retrieve(stub shim.ChaincodeStubInterface, id string) (Claim, error)
+	RetrieveAll(stub shim.ChaincodeStubInterface) ([]Claim)
+	SaveHistory(stub shim.ChaincodeStubInterface, history ClaimHistory) (error)
+	RetrieveHistory(stub shim.ChaincodeStubInterface, claimId string) (ClaimHistory, error)
+}
+
+type ThirdPartyRepository interface {
+	Save(stub shim.ChaincodeStubInterface, thirdParty ThirdParty) (ThirdParty, error)
+	Retrieve(stub shim.ChaincodeStubInterface, id string) (ThirdParty, error)
+}
+
+type VehicleRepository interface {
+	Save(stub shim.ChaincodeStubInterface, vehicle Vehicle) (Vehicle, error)
+	Retrieve(stub shim.ChaincodeStubInterface, id string) (Vehicle, error)
+}
+
+type UserRepository interface {
+	Save(stub shim.ChaincodeStubInterface, user User) (User, error)
+	Retrieve(stub shim.ChaincodeStubInterface, id string) (User, error)
+}
+
+type GarageRepository interface {
+	Save(stub shim.ChaincodeStubInterface, garage Garage) (Garage, error)
+	Retrieve(stub shim.ChaincodeStubInterface, id string) (Garage, error)
+	RetrieveAll(stub shim.ChaincodeStubInterface) ([]Garage)
+	Delete(stub shim.ChaincodeStubInterface, id string) (error)
+}
+
+type PermissionRepository interface {
+	Save(stub shim.ChaincodeStubInterface, permissions Permissions) (Permissions, error)
+	Retrieve(stub shim.ChaincodeStubInterface) (Permissions, error)
+}
+
+type Repositories struct {
+	Policies		PolicyRepository
+	Claims			ClaimRepository
+	ThirdParties	ThirdPartyRepository
+	Vehicles		VehicleRepository
+	Users			UserRepository
+	Garages			GarageRepository
+	Permissions		PermissionRepository
+}
+
+//=================================================================================================================================
+//	 NewRepositories	-	Creates the repositories for every type of object, all keeping objects in the given storage
+//=================================================================================================================================
+func NewRepositories(storage Storage) (Repositories) {
+	return Repositories{
+		StoragePolicyRepository{storage},
+		StorageClaimRepository{storage},
+		StorageThirdPartyRepository{storage},
+		StorageVehicleRepository{storage},
+		StorageUserRepository{storage},
+		StorageGarageRepository{storage},
+		StoragePermissionRepository{storage},
+	}
+}
+
+//=================================================================================================================================
+//	 GetRepositories	-	Gets the repositories for the storage backend chosen at Init
+//=================================================================================================================================
+func GetRepositories(stub shim.ChaincodeStubInterface) (Repositories) {
+	return NewRepositories(GetStorage(stub))
+}
+
+//==============================================================================================================================
+//	 Policies
+//==============================================================================================================================
+type StoragePolicyRepository struct {
+	Storage		Storage
+}
+
+func (t StoragePolicyRepository) Save(stub shim.ChaincodeStubInterface, policy Policy) (Policy, error) {
+	if policy.Id == "" {
+		policyId, err := getNextPolicyId(stub)
+		if err != nil { return policy, err }
+
+		policy.Id = policyId
+	}
+
+	err := saveObject(stub, t.Storage, POLICY_NAMESPACE + policy.Id, policy)
+
+	return policy, err
+}
+
+func (t StoragePolicyRepository) Retrieve(stub shim.ChaincodeStubInterface, id string) (Policy, error){
+	var policy Policy
+
+	err := retrieveObject(stub, t.Storage, POLICY_NAMESPACE + id, &policy)
+
+	return policy, err
+}
+
+func (t StoragePolicyRepository) RetrieveAll(stub shim.ChaincodeStubInterface) ([]Policy){
+	var policies []Policy
+
+	entries, err := t.Storage.List(stub, POLICY_NAMESPACE)
+
+	if err != nil {	fmt.Printf("RetrieveAllPolicies: Unable to list policies: %s", err); return policies }
+
+	for _, entry := range entries {
+		var policy Policy
+
+		err = unmarshal([]byte(entry.Value), &policy)
+		if err != nil { fmt.Printf("RetrieveAllPolicies: Cannot unmarshall policy with key: " + entry.Key + " : %s", err); continue }
+
+		policies = append(policies, policy)
+	}
+
+	return policies
+}
+
+//==============================================================================================================================
+//	 Claims
+//==============================================================================================================================
+type StorageClaimRepository struct {
+	Storage		Storage
+}
+
+func (t StorageClaimRepository) Save(stub shim.ChaincodeStubInterface, claim Claim) (Claim, error) {
+	if claim.Id == "" {
+		claimId, err := getNextClaimId(stub)
+		if err != nil { return claim, err }
+
+		claim.Id = claimId
+	}
+
+	err := saveObject(stub, t.Storage, CLAIM_NAMESPACE + claim.Id, claim)
+	if err != nil { return claim, err }
+
+	err = t.appendHistory(stub, claim)
+
+	return claim, err
+}
+
+//=================================================================================================================================
+//	 appendHistory		-	Records the change of status of a claim that has just been saved
+//=================================================================================================================================
+func (t StorageClaimRepository) appendHistory(stub shim.ChaincodeStubInterface, claim Claim) (error) {
+	history, err := t.RetrieveHistory(stub, claim.Id)
+	if err != nil { return err }
+
+	history.AddEntry(NewClaimHistoryEntry(stub, history.CurrentStatus(), claim.Details.Status))
+
+	return t.SaveHistory(stub, history)
+}
+
+func (t StorageClaimRepository) SaveHistory(stub shim.ChaincodeStubInterface, history ClaimHistory) (error) {
+	return saveObject(stub, t.Storage, CLAIM_HISTORY_NAMESPACE + history.ClaimId, history)
+}
+
+func (t StorageClaimRepository) RetrieveHistory(stub shim.ChaincodeStubInterface, claimId string) (ClaimHistory, error){
+	var history ClaimHistory
+
+	bytes, err := t.Storage.Retrieve(stub, CLAIM_HISTORY_NAMESPACE + claimId)
+	if err != nil {	fmt.Printf("RetrieveClaimHistory: Cannot retrieve history for claim: " + claimId + " : %s", err); return history, err}
+
+	//No changes have been saved for this claim yet
+	if len(bytes) == 0 { return NewClaimHistory(claimId), nil }
+
+	err = unmarshal(bytes, &history)
+
+	return history, err
+}
+
+func (t StorageClaimRepository) Retrieve(stub shim.ChaincodeStubInterface, id string) (Claim, error){
+	var claim Claim
+
+	err := retrieveObject(stub, t.Storage, CLAIM_NAMESPACE + id, &claim)
+
+	return claim, err
+}
+
+func (t StorageClaimRepository) RetrieveAll(stub shim.ChaincodeStubInterface) ([]Claim){
+	var claims []Claim
+
+	entries, err := t.Storage.List(stub, CLAIM_NAMESPACE)
+
+	if err != nil {	fmt.Printf("RetrieveAllClaims: Unable to list claims: %s", err); return claims }
+
+	for _, entry := range entries {
+		var claim Claim
+
+		err = unmarshal([]byte(entry.Value), &claim)
+		if err != nil { fmt.Printf("RetrieveAllClaims: Cannot unmarshall claim with key: " + entry.Key + " : %s", err); continue }
+
+		claims = append(claims, claim)
+	}
+
+	return claims
+}
+
+//==============================================================================================================================
+//	 Third parties
+//==============================================================================================================================
+type StorageThirdPartyRepository struct {
+	Storage		Storage
+}
+
+func (t StorageThirdPartyRepository) Save(stub shim.ChaincodeStubInterface, thirdParty ThirdParty) (ThirdParty, error) {
+	if thirdParty.Id == "" {
+		thirdPartyId, err := getNextThirdPartyId(stub)
+		if err != nil { return thirdParty, err }
+
+		thirdParty.Id = thirdPartyId
+	}
+
+	err := saveObject(stub, t.Storage, THIRD_PARTY_NAMESPACE + thirdParty.Id, thirdParty)
+
+	return thirdParty, err
+}
+
+func (t StorageThirdPartyRepository) Retrieve(stub shim.ChaincodeStubInterface, id string) (ThirdParty, error){
+	var thirdParty ThirdParty
+
+	err := retrieveObject(stub, t.Storage, THIRD_PARTY_NAMESPACE + id, &thirdParty)
+
+	return thirdParty, err
+}
+
+//==============================================================================================================================
+//	 Vehicles - Saved with the registration as the id
+//==============================================================================================================================
+type StorageVehicleRepository struct {
+	Storage		Storage
+}
+
+func (t StorageVehicleRepository) Save(stub shim.ChaincodeStubInterface, vehicle Vehicle) (Vehicle, error) {
+	if vehicle.Id == "" {
+		vehicle.Id = vehicle.Details.Registration
+	}
+
+	err := saveObject(stub, t.Storage, VEHICLE_NAMESPACE + vehicle.Id, vehicle)
+
+	return vehicle, err
+}
+
+func (t StorageVehicleRepository) Retrieve(stub shim.ChaincodeStubInterface, id string) (Vehicle, error){
+	var vehicle Vehicle
+
+	err := retrieveObject(stub, t.Storage, VEHICLE_NAMESPACE + id, &vehicle)
+
+	return vehicle, err
+}
+
+//==============================================================================================================================
+//	 Users
+//==============================================================================================================================
+type StorageUserRepository struct {
+	Storage		Storage
+}
+
+func (t StorageUserRepository) Save(stub shim.ChaincodeStubInterface, user User) (User, error) {
+	if user.Id == "" {
+		userId, err := getNextUserId(stub)
+		if err != nil { return user, err }
+
+		user.Id = userId
+	}
+
+	err := saveObject(stub, t.Storage, USER_NAMESPACE + user.Id, user)
+
+	return user, err
+}
+
+func (t StorageUserRepository) Retrieve(stub shim.ChaincodeStubInterface, id string) (User, error){
+	var user User
+
+	err := retrieveObject(stub, t.Storage, USER_NAMESPACE + id, &user)
+
+	return user, err
+}
+
+//==============================================================================================================================
+//	 Garages
+//==============================================================================================================================
+type StorageGarageRepository struct {
+	Storage		Storage
+}
+
+func (t StorageGarageRepository) Save(stub shim.ChaincodeStubInterface, garage Garage) (Garage, error) {
+	err := saveObject(stub, t.Storage, GARAGE_NAMESPACE + garage.Id, garage)
+
+	return garage, err
+}
+
+func (t StorageGarageRepository) Retrieve(stub shim.ChaincodeStubInterface, id string) (Garage, error){
+	var garage Garage
+
+	err := retrieveObject(stub, t.Storage, GARAGE_NAMESPACE + id, &garage)
+
+	return garage, err
+}
+
+func (t StorageGarageRepository) RetrieveAll(stub shim.ChaincodeStubInterface) ([]Garage){
+	var garages []Garage
+
+	entries, err := t.Storage.List(stub, GARAGE_NAMESPACE)
+
+	if err != nil {	fmt.Printf("RetrieveAllGarages: Unable to list garages: %s", err); return garages }
+
+	for _, entry := range entries {
+		var garage Garage
+
+		err = unmarshal([]byte(entry.Value), &garage)
+		if err != nil { fmt.Printf("RetrieveAllGarages: Cannot unmarshall garage with key: " + entry.Key + " : %s", err); continue }
+
+		garages = append(garages, garage)
+	}
+
+	return garages
+}
+
+func (t StorageGarageRepository) Delete(stub shim.ChaincodeStubInterface, id string) (error){
+	return t.Storage.Delete(stub, GARAGE_NAMESPACE + id)
+}
+
+//==============================================================================================================================
+//	 Permissions
+//==============================================================================================================================
+type StoragePermissionRepository struct {
+	Storage		Storage
+}
+
+func (t StoragePermissionRepository) Save(stub shim.ChaincodeStubInterface, permissions Permissions) (Permissions, error) {
+	err := saveObject(stub, t.Storage, PERMISSIONS_KEY, permissions)
+
+	return permissions, err
+}
+
+func (t StoragePermissionRepository) Retrieve(stub shim.ChaincodeStubInterface) (Permissions, error){
+	var permissions Permissions
+
+	err := retrieveObject(stub, t.Storage, PERMISSIONS_KEY, &permissions)
+
+	return permissions, err
+}
+
+func retrieveObject(stub shim.ChaincodeStubInterface, storage Storage, id string, toStoreObject interface{}) (error){
+	bytes, err := storage.Retrieve(stub, id)
+
+	if err != nil {	fmt.Printf("RetrieveObject: Cannot retrieve object with id: " + id + " : %s", err); return err}
+
+	if len(bytes) == 0 { fmt.Println("RetrieveObject: No object exists with id: " + id); return NotFoundError{id} }
+
+	err = unmarshal(bytes, toStoreObject)
+
+	if err != nil {	fmt.Printf("RetrieveObject: Cannot unmarshall object with id: " + id + " : %s", err); return err}
+
+	return nil
+}
+
+func saveObject(stub shim.ChaincodeStubInterface, storage Storage, id string, object interface{}) (error){
+	bytes, err := marshall(object)
+
+	if err != nil {fmt.Printf("\nUnable to marshall object with id: " + id + " : %s", err); return err}
+
+	err = storage.Save(stub, id, bytes)
+
+	if err != nil {fmt.Printf("Unable to save object: %s",  err); return err}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+//go:build memorystorage
+// +build memorystorage
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func init() {
+	testGlobalResets = append(testGlobalResets, ResetMemoryStorage)
+}
+
+//Creates the repositories on empty memory storage, within an open transaction so that ids can be created
+func newTestRepositories(t *testing.T) (*testStub, Repositories) {
+	ResetMemoryStorage()
+
+	stub := newTestStub()
+
+	OpenTxContext(stub, "test", "insurer1", "")
+	t.Cleanup(func() { CloseTxContext(stub) })
+
+	return stub, NewRepositories(memoryStorage)
+}
+
+func expectNotFound(t *testing.T, name string, err error) {
+	if !IsNotFoundError(err) { t.Fatalf("Expected a NotFoundError retrieving a missing %s, got: %v", name, err) }
+}
+
+func TestPolicyRepository(t *testing.T) {
+	stub, repositories := newTestRepositories(t)
+
+	saved, err := repositories.Policies.Save(stub, NewPolicy("", "claimant1", "insurer1", "2017-01-01", "2018-01-01", 100, "BP08BRV"))
+	if err != nil { t.Fatal(err) }
+
+	if !strings.HasPrefix(saved.Id, POLICY_ID_PREFIX) { t.Fatalf("Expected a new policy id, got: %s", saved.Id) }
+
+	_, err = repositories.Policies.Save(stub, NewPolicy("P2", "claimant2", "insurer2", "2017-01-01", "2018-01-01", 100, "DZ14TYV"))
+	if err != nil { t.Fatal(err) }
+
+	policy, err := repositories.Policies.Retrieve(stub, saved.Id)
+	if err != nil { t.Fatal(err) }
+
+	if policy.Relations.Owner != "claimant1" || policy.Relations.Vehicle != "BP08BRV" { t.Fatalf("Unexpected policy: %+v", policy) }
+
+	if policies := repositories.Policies.RetrieveAll(stub); len(policies) != 2 { t.Fatalf("Expected 2 policies, got: %+v", policies) }
+
+	_, err = repositories.Policies.Retrieve(stub, "P3")
+	expectNotFound(t, "policy", err)
+}
+
+func TestClaimRepository(t *testing.T) {
+	stub, repositories := newTestRepositories(t)
+
+	saved, err := repositories.Claims.Save(stub, NewClaim("", "P1", "Hit a tree", "2017-01-01", "single_party"))
+	if err != nil { t.Fatal(err) }
+
+	if !strings.HasPrefix(saved.Id, CLAIM_ID_PREFIX) { t.Fatalf("Expected a new claim id, got: %s", saved.Id) }
+
+	claim, err := repositories.Claims.Retrieve(stub, saved.Id)
+	if err != nil { t.Fatal(err) }
+
+	if claim.Relations.RelatedPolicy != "P1" || claim.Details.Description != "Hit a tree" { t.Fatalf("Unexpected claim: %+v", claim) }
+
+	if claims := repositories.Claims.RetrieveAll(stub); len(claims) != 1 { t.Fatalf("Expected 1 claim, got: %+v", claims) }
+
+	//The claim's history isn't listed with the claims
+	history, err := repositories.Claims.RetrieveHistory(stub, saved.Id)
+	if err != nil { t.Fatal(err) }
+
+	if len(history.Entries) != 1 || history.Entries[0].NewStatus != claim.Details.Status { t.Fatalf("Unexpected history: %+v", history) }
+
+	_, err = repositories.Claims.Retrieve(stub, "C1")
+	expectNotFound(t, "claim", err)
+}
+
+func TestThirdPartyRepository(t *testing.T) {
+	stub, repositories := newTestRepositories(t)
+
+	saved, err := repositories.ThirdParties.Save(stub, NewThirdParty("", "AB12CDE", "vehicle", "insurer2"))
+	if err != nil { t.Fatal(err) }
+
+	thirdParty, err := repositories.ThirdParties.Retrieve(stub, saved.Id)
+	if err != nil { t.Fatal(err) }
+
+	if thirdParty.Details.Registration != "AB12CDE" { t.Fatalf("Unexpected third party: %+v", thirdParty) }
+
+	_, err = repositories.ThirdParties.Retrieve(stub, "T1")
+	expectNotFound(t, "third party", err)
+}
+
+func TestVehicleRepository(t *testing.T) {
+	stub, repositories := newTestRepositories(t)
+
+	saved, err := repositories.Vehicles.Save(stub, NewVehicle("BP08BRV", "Ford", "Ka", "2010", 1000, "1"))
+	if err != nil { t.Fatal(err) }
+
+	if saved.Id != "BP08BRV" { t.Fatalf("Expected the registration as the id, got: %s", saved.Id) }
+
+	vehicle, err := repositories.Vehicles.Retrieve(stub, "BP08BRV")
+	if err != nil { t.Fatal(err) }
+
+	if vehicle.Details.Make != "Ford" { t.Fatalf("Unexpected vehicle: %+v", vehicle) }
+
+	_, err = repositories.Vehicles.Retrieve(stub, "DZ14TYV")
+	expectNotFound(t, "vehicle", err)
+}
+
+func TestUserRepository(t *testing.T) {
+	stub, repositories := newTestRepositories(t)
+
+	saved, err := repositories.Users.Save(stub, NewUser("", "Jane", "Smith", "jane@example.com", "P1"))
+	if err != nil { t.Fatal(err) }
+
+	if !strings.HasPrefix(saved.Id, USER_ID_PREFIX) { t.Fatalf("Expected a new user id, got: %s", saved.Id) }
+
+	user, err := repositories.Users.Retrieve(stub, saved.Id)
+	if err != nil { t.Fatal(err) }
+
+	if user.Details.Email != "jane@example.com" { t.Fatalf("Unexpected user: %+v", user) }
+
+	_, err = repositories.Users.Retrieve(stub, "U1")
+	expectNotFound(t, "user", err)
+}
+
+func TestGarageRepository(t *testing.T) {
+	stub, repositories := newTestRepositories(t)
+
+	for _, id := range []string{"garage1", "garage2"} {
+		_, err := repositories.Garages.Save(stub, NewGarage(id, "Garage", "1 High Street", Coordinates{}, "", []string{"bodywork"}))
+		if err != nil { t.Fatal(err) }
+	}
+
+	garage, err := repositories.Garages.Retrieve(stub, "garage1")
+	if err != nil { t.Fatal(err) }
+
+	if !garage.HasSpecialism("bodywork") { t.Fatalf("Unexpected garage: %+v", garage) }
+
+	if garages := repositories.Garages.RetrieveAll(stub); len(garages) != 2 { t.Fatalf("Expected 2 garages, got: %+v", garages) }
+
+	err = repositories.Garages.Delete(stub, "garage1")
+	if err != nil { t.Fatal(err) }
+
+	_, err = repositories.Garages.Retrieve(stub, "garage1")
+	expectNotFound(t, "garage", err)
+
+	if garages := repositories.Garages.RetrieveAll(stub); len(garages) != 1 { t.Fatalf("Expected 1 garage, got: %+v", garages) }
+}
+
+func TestPermissionRepository(t *testing.T) {
+	stub, repositories := newTestRepositories(t)
+
+	_, err := repositories.Permissions.Retrieve(stub)
+	expectNotFound(t, "permissions table", err)
+
+	_, err = repositories.Permissions.Save(stub, DefaultPermissions())
+	if err != nil { t.Fatal(err) }
+
+	permissions, err := repositories.Permissions.Retrieve(stub)
+	if err != nil { t.Fatal(err) }
+
+	if len(permissions.Entries) != len(DefaultPermissions().Entries) { t.Fatalf("Unexpected permissions: %+v", permissions) }
+}
+
+func TestRepositoriesKeepTypesInSeparateNamespaces(t *testing.T) {
+	stub, repositories := newTestRepositories(t)
+
+	//A vehicle registered as P1 doesn't overwrite policy P1
+	_, err := repositories.Policies.Save(stub, NewPolicy("P1", "claimant1", "insurer1", "2017-01-01", "2018-01-01", 100, "BP08BRV"))
+	if err != nil { t.Fatal(err) }
+
+	_, err = repositories.Vehicles.Save(stub, NewVehicle("P1", "Ford", "Ka", "2010", 1000, "1"))
+	if err != nil { t.Fatal(err) }
+
+	policy, err := repositories.Policies.Retrieve(stub, "P1")
+	if err != nil { t.Fatal(err) }
+
+	if policy.Type != "policy" || policy.Relations.Vehicle != "BP08BRV" { t.Fatalf("Policy P1 was overwritten: %+v", policy) }
+
+	vehicle, err := repositories.Vehicles.Retrieve(stub, "P1")
+	if err != nil { t.Fatal(err) }
+
+	if vehicle.Type != "vehicle" || vehicle.Details.Make != "Ford" { t.Fatalf("Unexpected vehicle P1: %+v", vehicle) }
+
+	//Each type is only found under its own namespace
+	_, err = repositories.Users.Retrieve(stub, "P1")
+	expectNotFound(t, "user", err)
+
+	for _, key := range []string{POLICY_NAMESPACE + "P1", VEHICLE_NAMESPACE + "P1"} {
+		if value, _ := memoryStorage.Retrieve(stub, key); len(value) == 0 { t.Fatalf("Nothing saved with key: %s", key) }
+	}
+
+	if value, _ := memoryStorage.Retrieve(stub, "P1"); len(value) != 0 { t.Fatal("An object was saved without a namespace") }
+}
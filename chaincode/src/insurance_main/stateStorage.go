@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"unicode/utf8"
+)
+
+//==============================================================================================================================
+//	StateStorage - Saves objects directly in the state of this chaincode, for deployments without the CRUD chaincode.
+//		Writes are part of the transaction, so nothing needs to be held until the end of it.
+//==============================================================================================================================
+type StateStorage struct {}
+
+func (t StateStorage) Retrieve(stub shim.ChaincodeStubInterface, key string) ([]byte, error) {
+	return stub.GetState(key)
+}
+
+func (t StateStorage) Save(stub shim.ChaincodeStubInterface, key string, value []byte) (error) {
+	return stub.PutState(key, value)
+}
+
+func (t StateStorage) Delete(stub shim.ChaincodeStubInterface, key string) (error) {
+	return stub.DelState(key)
+}
+
+func (t StateStorage) List(stub shim.ChaincodeStubInterface, prefix string) ([]KeyValue, error) {
+	entries := []KeyValue{}
+
+	//Sorts after any key starting with the prefix
+	iterator, err := stub.RangeQueryState(prefix, prefix + string(utf8.MaxRune))
+	if err != nil { return entries, err }
+
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		key, value, err := iterator.Next()
+		if err != nil { return entries, err }
+
+		entries = append(entries, KeyValue{Key: key, Value: string(value)})
+	}
+
+	return entries, nil
+}
+
+func (t StateStorage) Flush(stub shim.ChaincodeStubInterface) (error) {
+	return nil
+}
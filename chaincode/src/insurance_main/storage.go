@@ -0,0 +1,173 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"errors"
+	"fmt"
+)
+
+//==============================================================================================================================
+//	Storage - A key/value store that the repositories save objects in.  The backend is chosen when the chaincode is
+//		initialised.
+//==============================================================================================================================
+type Storage interface {
+	//Retrieves the value saved with the key, or nil if there is no value
+	Retrieve(stub shim.ChaincodeStubInterface, key string) ([]byte, error)
+
+	Save(stub shim.ChaincodeStubInterface, key string, value []byte) (error)
+
+	Delete(stub shim.ChaincodeStubInterface, key string) (error)
+
+	//Retrieves all key/value pairs with keys starting with the prefix
+	List(stub shim.ChaincodeStubInterface, prefix string) ([]KeyValue, error)
+
+	//Called at the end of every invoke, for backends that hold writes until the end of the transaction
+	Flush(stub shim.ChaincodeStubInterface) (error)
+}
+
+//==============================================================================================================================
+//	KeyValue - A single key/value pair, with the version of the value when the backend keeps versions.  ExpectedVersion
+//		is used to save values in the crud chaincode only if they are still at the expected version.
+//==============================================================================================================================
+type KeyValue struct {
+	Key					string		`json:"key"`
+	Value				string		`json:"value"`
+	Version				int			`json:"version"`
+	ExpectedVersion		*int		`json:"expectedVersion,omitempty"`
+}
+
+//Stores the name of the storage backend chosen at Init
+const STORAGE_BACKEND_KEY = "STORAGE_BACKEND"
+
+//==============================================================================================================================
+//	 Storage backends
+//		crud	-	Objects are saved in the CRUD chaincode (the default)
+//		state	-	Objects are saved in the state of this chaincode, for single chaincode deployments
+//		Backends only built for tests, such as the memory backend, add themselves to the storage backends.
+//==============================================================================================================================
+const   STORAGE_BACKEND_CRUD	= "crud"
+const   STORAGE_BACKEND_STATE	= "state"
+
+var storageBackends = map[string]Storage{
+	STORAGE_BACKEND_CRUD:	CrudStorage{},
+	STORAGE_BACKEND_STATE:	StateStorage{},
+}
+
+//=================================================================================================================================
+//	 InitStorage	-	Chooses the storage backend.  When no backend is given the stored backend is kept, or the CRUD chaincode
+//					is used if none has been stored.  The backend can't be changed once objects have been saved, as they
+//					would be left behind in the old backend.
+//=================================================================================================================================
+func InitStorage(stub shim.ChaincodeStubInterface, backend string) (error) {
+	bytes, err := stub.GetState(STORAGE_BACKEND_KEY)
+	if err != nil { fmt.Printf("InitStorage: Unable to get the stored storage backend: %s", err); return err }
+
+	current := string(bytes)
+
+	if backend == "" { backend = current }
+	if backend == "" { backend = STORAGE_BACKEND_CRUD }
+
+	if _, found := storageBackends[backend]; !found {
+		fmt.Println("InitStorage: Unknown storage backend: " + backend)
+		return errors.New("Unknown storage backend: " + backend)
+	}
+
+	if current != "" && backend != current {
+		saved, err := hasSavedObjects(stub)
+		if err != nil { fmt.Printf("InitStorage: Unable to check for saved objects: %s", err); return err }
+
+		if saved {
+			fmt.Println("InitStorage: Refusing to change the storage backend from " + current + " to " + backend)
+			return errors.New("Cannot change the storage backend from " + current + " to " + backend + " once objects have been saved")
+		}
+	}
+
+	return stub.PutState(STORAGE_BACKEND_KEY, []byte(backend))
+}
+
+//=================================================================================================================================
+//	 hasSavedObjects	-	Checks if any object has been saved in the current storage backend
+//=================================================================================================================================
+func hasSavedObjects(stub shim.ChaincodeStubInterface) (bool, error) {
+	storage := GetStorage(stub)
+
+	for _, namespace := range NAMESPACES {
+		entries, err := storage.List(stub, namespace)
+		if err != nil { return false, err }
+
+		if len(entries) > 0 { return true, nil }
+	}
+
+	return false, nil
+}
+
+//=================================================================================================================================
+//	 GetStorage	-	Gets the storage backend chosen at Init
+//=================================================================================================================================
+func GetStorage(stub shim.ChaincodeStubInterface) (Storage) {
+	bytes, _ := stub.GetState(STORAGE_BACKEND_KEY)
+
+	backend, found := storageBackends[string(bytes)]
+	if !found { backend = CrudStorage{} }
+
+	return CachedStorage{backend}
+}
+
+//==============================================================================================================================
+//	CachedStorage - Caches everything read from or saved to the backend in the transaction context, so that each key is
+//		only read from the backend once per invoke or query.  Saves are written through to the backend.
+//==============================================================================================================================
+type CachedStorage struct {
+	Backend		Storage
+}
+
+func (t CachedStorage) Retrieve(stub shim.ChaincodeStubInterface, key string) ([]byte, error) {
+	context := GetTxContext(stub)
+
+	if value, found := context.GetCachedValue(key); found { return value, nil }
+
+	value, err := t.Backend.Retrieve(stub, key)
+	if err != nil { return nil, err }
+
+	context.CacheValue(key, value)
+
+	return value, nil
+}
+
+func (t CachedStorage) Save(stub shim.ChaincodeStubInterface, key string, value []byte) (error) {
+	err := t.Backend.Save(stub, key, value)
+	if err != nil { return err }
+
+	GetTxContext(stub).CacheValue(key, value)
+
+	return nil
+}
+
+func (t CachedStorage) Delete(stub shim.ChaincodeStubInterface, key string) (error) {
+	err := t.Backend.Delete(stub, key)
+	if err != nil { return err }
+
+	GetTxContext(stub).CacheValue(key, nil)
+
+	return nil
+}
+
+//Listed values are cached, unless the key has already been read or saved in the transaction
+func (t CachedStorage) List(stub shim.ChaincodeStubInterface, prefix string) ([]KeyValue, error) {
+	entries, err := t.Backend.List(stub, prefix)
+	if err != nil { return entries, err }
+
+	context := GetTxContext(stub)
+
+	for _, entry := range entries {
+		if _, found := context.GetCachedValue(entry.Key); !found {
+			context.CacheValue(entry.Key, []byte(entry.Value))
+		}
+	}
+
+	return entries, nil
+}
+
+func (t CachedStorage) Flush(stub shim.ChaincodeStubInterface) (error) {
+	return t.Backend.Flush(stub)
+}
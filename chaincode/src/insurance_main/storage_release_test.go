@@ -0,0 +1,15 @@
+//go:build !memorystorage
+// +build !memorystorage
+
+package main
+
+import (
+	"testing"
+)
+
+func TestInitStorageRejectsMemoryBackendInReleaseBuilds(t *testing.T) {
+	stub := newTestStub()
+
+	err := InitStorage(stub, "memory")
+	if err == nil { t.Fatal("Expected the memory backend to be rejected without the memorystorage build tag") }
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+)
+
+func storedValue(stub *testStub, key string) (string) {
+	bytes, _ := stub.GetState(key)
+	return string(bytes)
+}
+
+func TestInitStorageDefaultsToCrud(t *testing.T) {
+	stub := newTestStub()
+
+	err := InitStorage(stub, "")
+	if err != nil { t.Fatal(err) }
+
+	if backend := storedValue(stub, STORAGE_BACKEND_KEY); backend != STORAGE_BACKEND_CRUD { t.Fatalf("Expected the crud backend, got: %s", backend) }
+}
+
+func TestInitStorageRejectsUnknownBackend(t *testing.T) {
+	stub := newTestStub()
+
+	err := InitStorage(stub, "disk")
+	if err == nil { t.Fatal("Expected an unknown backend to be rejected") }
+}
+
+func TestInitKeepsStoredBackendAndToken(t *testing.T) {
+	cc := new(InsuranceChaincode)
+	stub := newTestStub()
+
+	_, err := cc.Init(stub, "init", []string{"insurance_crud", "", "token", STORAGE_BACKEND_STATE})
+	if err != nil { t.Fatal(err) }
+
+	//Initialised again without a chaincodeId, token or backend
+	for _, args := range [][]string{{}, {""}, {"", ""}, {"", "", ""}, {"", "", "", ""}} {
+		stub.nextTx()
+
+		_, err = cc.Init(stub, "init", args)
+		if err != nil { t.Fatalf("Init with %q: %s", args, err) }
+
+		if backend := storedValue(stub, STORAGE_BACKEND_KEY); backend != STORAGE_BACKEND_STATE { t.Fatalf("Init with %q changed the backend to: %s", args, backend) }
+		if id := storedValue(stub, CRUD_CHAINCODE_ID_KEY); id != "insurance_crud" { t.Fatalf("Init with %q changed the crud chaincodeId to: %s", args, id) }
+		if token := storedValue(stub, CRUD_TOKEN_KEY); token != "token" { t.Fatalf("Init with %q changed the crud token to: %s", args, token) }
+	}
+}
+
+func TestInitRefusesBackendChangeOnceObjectsSaved(t *testing.T) {
+	cc := new(InsuranceChaincode)
+	stub := newTestStub()
+
+	//The reference data is saved in the state backend
+	_, err := cc.Init(stub, "init", []string{"", "", "", STORAGE_BACKEND_STATE})
+	if err != nil { t.Fatal(err) }
+
+	stub.nextTx()
+
+	_, err = cc.Init(stub, "init", []string{"insurance_crud", "", "token", STORAGE_BACKEND_CRUD})
+	if err == nil { t.Fatal("Expected the backend change to be refused") }
+
+	if backend := storedValue(stub, STORAGE_BACKEND_KEY); backend != STORAGE_BACKEND_STATE { t.Fatalf("The backend was changed to: %s", backend) }
+}
+
+func TestInitStorageAllowsBackendChangeBeforeObjectsSaved(t *testing.T) {
+	stub := newTestStub()
+
+	err := InitStorage(stub, STORAGE_BACKEND_STATE)
+	if err != nil { t.Fatal(err) }
+
+	err = InitStorage(stub, STORAGE_BACKEND_CRUD)
+	if err != nil { t.Fatal(err) }
+
+	if backend := storedValue(stub, STORAGE_BACKEND_KEY); backend != STORAGE_BACKEND_CRUD { t.Fatalf("Expected the crud backend, got: %s", backend) }
+}
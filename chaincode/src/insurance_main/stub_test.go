@@ -0,0 +1,229 @@
+package main
+
+import (
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+//==============================================================================================================================
+//	testStub - Holds state in memory.  Only the stub functions used by the chaincode are implemented.  Calls to other
+//		chaincodes are sent to the crud peer, if one has been given.
+//==============================================================================================================================
+type testStub struct {
+	shim.ChaincodeStubInterface
+	state		map[string][]byte
+	txId		string
+	txCount		int
+	txTime		time.Time
+	attributes	map[string]string
+	events		map[string][]byte
+	crud		*crudPeer
+}
+
+func newTestStub() (*testStub) {
+	stub := &testStub{state: map[string][]byte{}, attributes: map[string]string{}, events: map[string][]byte{}}
+	stub.txTime = time.Date(2016, 10, 1, 9, 0, 0, 0, time.UTC)
+	stub.nextTx()
+
+	return stub
+}
+
+//Starts a new transaction, with a new txId and a later timestamp
+func (s *testStub) nextTx() {
+	s.txCount++
+	s.txId = "tx" + strconv.Itoa(s.txCount)
+	s.txTime = s.txTime.Add(time.Minute)
+	s.events = map[string][]byte{}
+}
+
+func (s *testStub) GetTxID() (string) { return s.txId }
+
+func (s *testStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: s.txTime.Unix(), Nanos: int32(s.txTime.Nanosecond())}, nil
+}
+
+func (s *testStub) GetState(key string) ([]byte, error) { return s.state[key], nil }
+
+func (s *testStub) PutState(key string, value []byte) (error) { s.state[key] = value; return nil }
+
+func (s *testStub) DelState(key string) (error) { delete(s.state, key); return nil }
+
+func (s *testStub) SetEvent(name string, payload []byte) (error) { s.events[name] = payload; return nil }
+
+func (s *testStub) ReadCertAttribute(name string) ([]byte, error) {
+	value, found := s.attributes[name]
+	if !found { return nil, errors.New("No attribute " + name) }
+
+	return []byte(value), nil
+}
+
+func (s *testStub) RangeQueryState(startKey string, endKey string) (shim.StateRangeQueryIteratorInterface, error) {
+	keys := []string{}
+
+	for key := range s.state {
+		if key >= startKey && key < endKey { keys = append(keys, key) }
+	}
+
+	sort.Strings(keys)
+
+	return &testStateIterator{s.state, keys}, nil
+}
+
+func (s *testStub) InvokeChaincode(chaincodeName string, args [][]byte) ([]byte, error) {
+	if s.crud == nil { return nil, errors.New("No chaincode deployed with name: " + chaincodeName) }
+
+	return s.crud.call(args)
+}
+
+func (s *testStub) QueryChaincode(chaincodeName string, args [][]byte) ([]byte, error) {
+	if s.crud == nil { return nil, errors.New("No chaincode deployed with name: " + chaincodeName) }
+
+	return s.crud.call(args)
+}
+
+type testStateIterator struct {
+	state	map[string][]byte
+	keys	[]string
+}
+
+func (t *testStateIterator) HasNext() (bool) { return len(t.keys) > 0 }
+
+func (t *testStateIterator) Next() (string, []byte, error) {
+	key := t.keys[0]
+	t.keys = t.keys[1:]
+
+	return key, t.state[key], nil
+}
+
+func (t *testStateIterator) Close() (error) { return nil }
+
+//==============================================================================================================================
+//	newTestChaincode - Initialises the chaincode with the reference data in the given storage backend.  Anything the build
+//		tags keep between tests, such as the memory backend or the injected caller, is reset first and when the test ends.
+//==============================================================================================================================
+func newTestChaincode(t *testing.T, storageBackend string) (*InsuranceChaincode, *testStub) {
+	resetTestGlobals()
+	t.Cleanup(resetTestGlobals)
+
+	cc := new(InsuranceChaincode)
+	stub := newTestStub()
+
+	_, err := cc.Init(stub, "init", []string{"", "", "", storageBackend})
+	if err != nil { t.Fatal(err) }
+
+	return cc, stub
+}
+
+//Resets for anything kept between tests, added by the test files built with each tag
+var testGlobalResets []func()
+
+func resetTestGlobals() {
+	for _, reset := range testGlobalResets { reset() }
+}
+
+//==============================================================================================================================
+//	crudPeer - Stands in for the CRUD chaincode, keeping a value and version for each key.  Only the functions called by
+//		CrudStorage are implemented.  The function, txId, caller name and token of the last call are recorded, and the calls
+//		to each function are counted.  If a token is set, invokes with any other token are rejected as the CRUD chaincode
+//		rejects them.  Lists are returned in a single page.
+//==============================================================================================================================
+type crudPeer struct {
+	values		map[string]string
+	versions	map[string]int
+	lastArgs	[]string
+	calls		map[string]int
+	token		string
+}
+
+func newCrudPeer() (*crudPeer) {
+	return &crudPeer{values: map[string]string{}, versions: map[string]int{}, calls: map[string]int{}}
+}
+
+//Saves a value as another transaction would, moving the key to its next version
+func (p *crudPeer) put(key string, value string) {
+	p.values[key] = value
+	p.versions[key]++
+}
+
+func (p *crudPeer) call(chaincodeArgs [][]byte) ([]byte, error) {
+	args := []string{}
+
+	for _, arg := range chaincodeArgs {
+		args = append(args, string(arg))
+	}
+
+	p.lastArgs = args
+
+	function := args[0]
+	p.calls[function]++
+
+	if p.token != "" && args[3] != p.token { return []byte(CRUD_ACCESS_REJECTED_PREFIX + "Invalid token"), nil }
+
+	args = args[4:]
+
+	switch function {
+	case RETRIEVE_VERSIONED_FUNCTION:
+		return marshall(KeyValue{Key: args[0], Value: p.values[args[0]], Version: p.versions[args[0]]})
+
+	case SAVE_FUNCTION:
+		p.put(args[0], args[1])
+		return nil, nil
+
+	case LIST_FUNCTION:
+		keys := []string{}
+
+		for key := range p.values {
+			if strings.HasPrefix(key, args[0]) { keys = append(keys, key) }
+		}
+
+		sort.Strings(keys)
+
+		page := Page{Entries: []KeyValue{}}
+		for _, key := range keys {
+			page.Entries = append(page.Entries, KeyValue{Key: key, Value: p.values[key], Version: p.versions[key]})
+		}
+
+		return marshall(page)
+
+	case DELETE_BATCH_FUNCTION:
+		var keys []string
+
+		err := unmarshal([]byte(args[0]), &keys)
+		if err != nil { return nil, err }
+
+		for _, key := range keys {
+			delete(p.values, key)
+			p.versions[key]++
+		}
+
+		return nil, nil
+
+	case SAVE_BATCH_FUNCTION:
+		var keyValues []KeyValue
+
+		err := unmarshal([]byte(args[0]), &keyValues)
+		if err != nil { return nil, err }
+
+		//Every version is checked before anything is saved, as in the CRUD chaincode
+		for _, keyValue := range keyValues {
+			if keyValue.ExpectedVersion != nil && *keyValue.ExpectedVersion != p.versions[keyValue.Key] {
+				details, _ := marshall(VersionConflictError{keyValue.Key, *keyValue.ExpectedVersion, p.versions[keyValue.Key]})
+				return nil, errors.New(CRUD_VERSION_CONFLICT_PREFIX + string(details))
+			}
+		}
+
+		for _, keyValue := range keyValues {
+			p.put(keyValue.Key, keyValue.Value)
+		}
+
+		return nil, nil
+	}
+
+	return nil, errors.New("Function not implemented by the crud peer: " + function)
+}
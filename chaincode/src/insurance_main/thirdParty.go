@@ -0,0 +1,74 @@
+package main
+
+//==============================================================================================================================
+//	ThirdParty - Defines the structure for a ThirdParty object.
+//		A third party is a vehicle involved in a multiple party claim that does not have a policy on the ledger
+//==============================================================================================================================
+type ThirdParty struct {
+	Id			string					`json:"id"`
+	Type		string					`json:"type"`
+	Details		ThirdPartyDetails		`json:"details"`
+	Relations	ThirdPartyRelations		`json:"relations"`
+}
+
+//==============================================================================================================================
+//	ThirdPartyDetails - Defines the structure for a ThirdPartyDetails object.
+//==============================================================================================================================
+type ThirdPartyDetails struct {
+	Registration		string		`json:"registration"`
+	Category			string		`json:"category"`
+	ExternalInsurer		string		`json:"externalInsurer"`
+	LiabilityShare		int			`json:"liabilityShare"`
+}
+
+//==============================================================================================================================
+//	ThirdPartyRelations - Defines the structure for a ThirdPartyRelations object.
+//==============================================================================================================================
+type ThirdPartyRelations struct {
+	LinkedClaims	[]string	`json:"linkedClaims"`
+}
+
+//==============================================================================================================================
+//	 Third party categories
+//==============================================================================================================================
+const   THIRD_PARTY_UNINSURED			= "uninsured"
+const   THIRD_PARTY_HIT_AND_RUN			= "hit_and_run"
+const   THIRD_PARTY_OTHER_INSURER		= "other_insurer"
+
+//Registration given for a vehicle that could not be identified
+const   UNKNOWN_REGISTRATION			= "UNKNOWN"
+
+//=================================================================================================================================
+//	 NewThirdParty	-	Constructs a new third party
+//=================================================================================================================================
+func NewThirdParty(id string, registration string, category string, externalInsurer string) (ThirdParty) {
+	var thirdParty ThirdParty
+
+	thirdParty.Id = id
+	thirdParty.Type = "thirdParty"
+
+	thirdParty.Details.Registration = registration
+	thirdParty.Details.Category = category
+	thirdParty.Details.ExternalInsurer = externalInsurer
+	thirdParty.Relations.LinkedClaims = []string{}
+
+	return thirdParty
+}
+
+//=================================================================================================================================
+//	 PaymentSender	-	The party that recovery payments should be requested from
+//=================================================================================================================================
+func (t *ThirdParty) PaymentSender() (string) {
+	if t.Details.Category == THIRD_PARTY_OTHER_INSURER && t.Details.ExternalInsurer != "" {
+		return t.Details.ExternalInsurer
+	}
+
+	return t.Id
+}
+
+//=================================================================================================================================
+//	 IsValidThirdPartyCategory	-	Checks that the category is a known third party category
+//=================================================================================================================================
+func IsValidThirdPartyCategory(category string) (bool) {
+	return category == THIRD_PARTY_UNINSURED || category == THIRD_PARTY_HIT_AND_RUN || category == THIRD_PARTY_OTHER_INSURER
+}
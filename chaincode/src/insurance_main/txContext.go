@@ -0,0 +1,188 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"errors"
+	"sync"
+	"time"
+)
+
+//==============================================================================================================================
+//	TxContext - Holds details of the transaction currently being invoked, so that they are available to the dao without
+//		having to be passed through every function.
+//==============================================================================================================================
+type TxContext struct {
+	Function			string
+	Caller				string
+	CallerAffiliation	string
+
+	//Writes to the crud chaincode are held until the end of the transaction, then sent in a single batch
+	IsOpen				bool
+	PendingWrites		map[string]PendingWrite
+	PendingKeys			[]string
+
+	//The version of each object when it was first retrieved in the transaction, so that saving it again fails if another
+	//transaction has changed it in the meantime
+	ReadVersions		map[string]int
+
+	//Number of ids created in the transaction, used to give each new object a unique id
+	IdSequence			int
+
+	//Values read or saved in the transaction, so that reading them again doesn't go back to storage.  A nil value means
+	//that nothing is stored with the key.
+	ReadCache			map[string][]byte
+}
+
+//==============================================================================================================================
+//	PendingWrite - A save or delete that has not yet been sent to the crud chaincode
+//==============================================================================================================================
+type PendingWrite struct {
+	Key			string
+	Value		[]byte
+	Deleted		bool
+}
+
+//Transaction contexts keyed by txId
+var txContexts = map[string]*TxContext{}
+var txContextsLock sync.Mutex
+
+//=================================================================================================================================
+//	 OpenTxContext	-	Creates the context for the current transaction
+//=================================================================================================================================
+func OpenTxContext(stub shim.ChaincodeStubInterface, function string, caller string, callerAffiliation string) (*TxContext) {
+	var context TxContext
+
+	context.Function = function
+	context.Caller = caller
+	context.CallerAffiliation = callerAffiliation
+	context.IsOpen = true
+	context.PendingWrites = map[string]PendingWrite{}
+	context.PendingKeys = []string{}
+	context.ReadVersions = map[string]int{}
+	context.ReadCache = map[string][]byte{}
+
+	txContextsLock.Lock()
+	defer txContextsLock.Unlock()
+
+	txContexts[stub.GetTxID()] = &context
+
+	return &context
+}
+
+//=================================================================================================================================
+//	 CloseTxContext	-	Removes the context for the current transaction once it has completed
+//=================================================================================================================================
+func CloseTxContext(stub shim.ChaincodeStubInterface) {
+	txContextsLock.Lock()
+	defer txContextsLock.Unlock()
+
+	delete(txContexts, stub.GetTxID())
+}
+
+//=================================================================================================================================
+//	 GetTxContext	-	Gets the context for the current transaction, or an empty context if none has been opened
+//=================================================================================================================================
+func GetTxContext(stub shim.ChaincodeStubInterface) (*TxContext) {
+	txContextsLock.Lock()
+	context, found := txContexts[stub.GetTxID()]
+	txContextsLock.Unlock()
+
+	if !found {
+		var empty TxContext
+		empty.PendingWrites = map[string]PendingWrite{}
+		empty.ReadVersions = map[string]int{}
+		empty.ReadCache = map[string][]byte{}
+		return &empty
+	}
+
+	return context
+}
+
+//=================================================================================================================================
+//	 AddPendingWrite	-	Holds a write until the end of the transaction, replacing any earlier write to the same key
+//=================================================================================================================================
+func (t *TxContext) AddPendingWrite(write PendingWrite) {
+	if _, found := t.PendingWrites[write.Key]; !found {
+		t.PendingKeys = append(t.PendingKeys, write.Key)
+	}
+
+	t.PendingWrites[write.Key] = write
+}
+
+//=================================================================================================================================
+//	 GetPendingWrite	-	Gets the write held for a key, if there is one
+//=================================================================================================================================
+func (t *TxContext) GetPendingWrite(key string) (PendingWrite, bool) {
+	write, found := t.PendingWrites[key]
+	return write, found
+}
+
+//=================================================================================================================================
+//	 RecordReadVersion	-	Records the version of an object retrieved in the transaction, if it hasn't already been retrieved
+//=================================================================================================================================
+func (t *TxContext) RecordReadVersion(key string, version int) {
+	if _, found := t.ReadVersions[key]; !found {
+		t.ReadVersions[key] = version
+	}
+}
+
+//=================================================================================================================================
+//	 GetReadVersion	-	Gets the version of an object when it was first retrieved in the transaction
+//=================================================================================================================================
+func (t *TxContext) GetReadVersion(key string) (int, bool) {
+	version, found := t.ReadVersions[key]
+	return version, found
+}
+
+//=================================================================================================================================
+//	 ClearPendingWrites	-	Removes all held writes, once they have been sent to the crud chaincode
+//=================================================================================================================================
+func (t *TxContext) ClearPendingWrites() {
+	t.PendingWrites = map[string]PendingWrite{}
+	t.PendingKeys = []string{}
+}
+
+//=================================================================================================================================
+//	 NextIdSequence	-	Gets the next number in the sequence of ids created in the transaction, starting at 1
+//=================================================================================================================================
+func (t *TxContext) NextIdSequence() (int) {
+	t.IdSequence++
+	return t.IdSequence
+}
+
+//=================================================================================================================================
+//	 GetCachedValue	-	Gets the value cached for a key, if it has been read or saved in the transaction
+//=================================================================================================================================
+func (t *TxContext) GetCachedValue(key string) ([]byte, bool) {
+	value, found := t.ReadCache[key]
+	return value, found
+}
+
+//=================================================================================================================================
+//	 CacheValue	-	Caches the value of a key for the rest of the transaction
+//=================================================================================================================================
+func (t *TxContext) CacheValue(key string, value []byte) {
+	t.ReadCache[key] = value
+}
+
+//=================================================================================================================================
+//	 GetTxTime	-	The time of the current transaction, which is the same on every peer
+//=================================================================================================================================
+func GetTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil { return time.Time{}, err }
+
+	if timestamp == nil { return time.Time{}, errors.New("The transaction has no timestamp") }
+
+	return time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC(), nil
+}
+
+//=================================================================================================================================
+//	 GetTxTimestamp	-	The time of the current transaction in RFC3339 format, or empty if the time is unavailable
+//=================================================================================================================================
+func GetTxTimestamp(stub shim.ChaincodeStubInterface) (string) {
+	txTime, err := GetTxTime(stub)
+	if err != nil { return "" }
+
+	return txTime.Format(time.RFC3339)
+}
@@ -1,5 +1,6 @@
 const fetch = require('node-fetch');
 const fs = require('fs');
+const crypto = require('crypto');
 
 const blockchain = require('./utils/blockchain/blockchain-helpers.js');
 
@@ -9,14 +10,16 @@ const deployMainChaincode = options => {
   return blockchain.deploy(options.endpoint,
     "https://github.com/Capgemini-AIE/blockchain-insurance/chaincode/src/insurance_main",
     options.user,
-    [options.crudHash])
+    // The oracle host is left empty, the CRUD token is passed as the third argument.  Deploy arguments are stored on the
+    // ledger, so the token can be read by anyone with access to the ledger
+    [options.crudHash, "", options.crudToken])
     .then(result => {
       console.log("[DEPLOY] Chaincode Deployed Successfully");
       // Write hashes to environment variable
       process.env.CHAINCODE_ID = result;
       process.env.CHAINCODE_CRUD_ID = options.crudHash;
-      // Read existing config return in and object along with api call result
-      return  { chaincodeHash: result, crudHash: options.crudHash };
+      // The CRUD token is saved with the CRUD hash, so the main chaincode can be deployed again against the same CRUD chaincode
+      return  { chaincodeHash: result, crudHash: options.crudHash, crudToken: options.crudToken };
     }).then(result => {
       finalResult = result;
       // write output
@@ -27,6 +30,7 @@ const deployMainChaincode = options => {
           }
 
           console.log("The file was saved!");
+          console.log("[DEPLOY] To deploy the main chaincode again, set CRUD_HASH and CRUD_TOKEN to the crudHash and crudToken in chaincodeIDs.json");
           resolve(result);
         });
       });
@@ -67,19 +71,29 @@ let setupInitialData = (endpoint, hash) => {
 let deploy = (endpoint, user) => {
     if (process.env.CRUD_HASH) {
       console.log("[DEPLOY] CRUD Chaincode already deployed.");
+      // The main chaincode must be registered with the token the CRUD chaincode was deployed with
+      if (!process.env.CRUD_TOKEN) {
+        console.error("[DEPLOY] CRUD_TOKEN must be set when CRUD_HASH is set. Use the crudToken saved in chaincodeIDs.json when the CRUD chaincode was deployed.");
+        throw new Error("CRUD_TOKEN is not set");
+      }
       deployMainChaincode({
         crudHash: process.env.CRUD_HASH,
+        crudToken: process.env.CRUD_TOKEN,
         user: user.enrollmentId,
         endpoint
       });
     } else {
       console.log("[DEPLOY] Deploying CRUD chaincode");
+      // Registers the main chaincode as the only caller allowed to use the CRUD chaincode.  The token is visible on the
+      // ledger in the deploy arguments, see the README
+      const crudToken = crypto.randomBytes(32).toString('hex');
       blockchain.deploy(endpoint,
         "https://github.com/Capgemini-AIE/blockchain-insurance/chaincode/src/insurance_crud",
         user.enrollmentId,
-        [])
+        ["insurance_main:" + crudToken])
         .then(hash => ({
           crudHash: hash,
+          crudToken,
           user: user.enrollmentId,
           endpoint
         }))
@@ -25,21 +25,14 @@ var getFullHistory = function(username, callback){
   blockchainService.query("retrieveAllClaims", [], username, callback);
 };
 
-//For now we're getting all the claims and iterating but this is obviously inefficient.
-//We should add a query to the chaincode for a specific claim id
 var getClaimWithId = function(claimId, username, callback) {
-  getFullHistory(username, function(result) {
-    var claims = JSON.parse(result.results);
-
-    for (var i = 0; i < claims.length; i++) {
-      if (claims[i].id == claimId) {
-        callback(claims[i]);
-        return
-      }
+  blockchainService.query("retrieveClaim", [claimId], username, function(result) {
+    try {
+      callback(JSON.parse(result.results));
+    } catch (error) {
+      //claim not found, or not visible to the user
+      callback();
     }
-
-    //claim not found
-    return callback();
   });
 };
 
@@ -55,11 +48,21 @@ var confirmPaidOut = function(claimId, paymentId, username, callback) {
   blockchainService.invoke("confirmPaidOut", [claimId, paymentId], username, callback);
 };
 
+var getAllowedActions = function(claimId, username, callback) {
+  blockchainService.query("retrieveAllowedClaimActions", [claimId], username, callback);
+};
+
+var getClaimHistory = function(claimId, username, callback) {
+  blockchainService.query("retrieveClaimHistory", [claimId], username, callback);
+};
+
 module.exports = {
   raiseClaim: raiseClaim,
   getFullHistory: getFullHistory,
   makeClaimAgreement: makeClaimAgreement,
   confirmPaidOut: confirmPaidOut,
   getClaimWithId: getClaimWithId,
-  makeLiabilityAgreement: makeLiabilityAgreement
+  makeLiabilityAgreement: makeLiabilityAgreement,
+  getAllowedActions: getAllowedActions,
+  getClaimHistory: getClaimHistory
 };
@@ -13,7 +13,7 @@ var payoutCallback = function(event){
   var payload = JSON.parse(event.payload.toString())
   console.log("Received claim settled event for claimId: " + payload.claimId);
 
-  paymentService.payoutClaim(payload.claimId, payload.policyId, payload.linkedClaimId);
+  paymentService.payoutClaim(payload.claimId, payload.policyId, payload.linkedClaimIds);
 };
 
 module.exports = {
@@ -4,7 +4,7 @@ var emailService = require('../integration/emailService');
 var config = require('config');
 
 //Payments would be made off chain and then call back into the chain to confirm payment
-var payoutClaim = function(claimId, policyId, linkedClaimId) {
+var payoutClaim = function(claimId, policyId, linkedClaimIds) {
   console.log("'Paying out' claim with id: " + claimId);
   
   //Actual payment logic would start here in a production system
@@ -20,8 +20,10 @@ var payoutClaim = function(claimId, policyId, linkedClaimId) {
     if (role == "insurer") {
       confirmPaidOutForInsurer(claimId, policyId, user.enrollmentId);
 
-      if (linkedClaimId) {
-        confirmPaidOutForInsurer(linkedClaimId, policyId, user.enrollmentId)
+      if (linkedClaimIds) {
+        for (var j = 0; j < linkedClaimIds.length; j++) {
+          confirmPaidOutForInsurer(linkedClaimIds[j], policyId, user.enrollmentId)
+        }
       }
     }
   }
@@ -41,29 +43,30 @@ var confirmPaidOutForInsurer = function(claimId, policyId, insurerUsername) {
 
   claimService.getClaimWithId(claimId, insurerUsername, function(claim) {
     if (claim && claim.details.settlement && claim.details.settlement.payments) {
-      for (var i = 0; i < claim.details.settlement.payments.length; i++) {
-        var payment = claim.details.settlement.payments[i];
-        
-        if (payment.sender == insurerUsername) {
-
-          //If we're not liable, dont payout until the other insurer has paid us
-          if (claim.details.liable == true || hasPaidPaymentFromLiableInsurer(claim, insurerUsername)) {
-            claimService.confirmPaidOut(claimId, payment.id, insurerUsername, function (result) {
-              if (result.error) {
-                console.error("There was a problem when marking the claim as paid in the blockchain: " + result.error);
-              } else {
-                console.log("confirmPaidOut invoked for claimId: " + claimId);
-                if (payment.recipientType == "claimant") {
-                  policyService.getPolicyWithId(policyId, insurerUsername, function(policy){
-                    if (policy) {
-                      sendEmail(claimId, policyId, policy.relations.owner);
-                    } else {
-                      console.log("Unable to send email because we failed to retrieve the policy");
-                    }
-                  });
-                }
-              }
-            });
+      var payments = claim.details.settlement.payments;
+
+      //Third parties aren't on the ledger, so the receiving insurer confirms their payments once recovered, then checks
+      //the claim again so that the payout can be released
+      var thirdPartyPayments = payments.filter(function(payment) {
+        return isUnpaidRecoveryPayment(payment, insurerUsername) && payment.senderType == "third_party";
+      });
+
+      if (thirdPartyPayments.length > 0) {
+        confirmPaymentsInTurn(claimId, thirdPartyPayments, insurerUsername, function() {
+          confirmPaidOutForInsurer(claimId, policyId, insurerUsername);
+        });
+        return;
+      }
+
+      for (var i = 0; i < payments.length; i++) {
+        var payment = payments[i];
+
+        if (payment.sender == insurerUsername && payment.status != "paid") {
+
+          //Other insurers are paid straight away, but if we're not fully liable, dont payout until every other liable
+          //party has paid us
+          if (payment.recipientType == "insurer" || claim.details.liabilityShare == 100 || hasAllRecoveryPaymentsPaid(claim, insurerUsername)) {
+            confirmPayout(claimId, policyId, payment, insurerUsername);
           }
         }
       }
@@ -73,16 +76,56 @@ var confirmPaidOutForInsurer = function(claimId, policyId, insurerUsername) {
   })
 };
 
-var hasPaidPaymentFromLiableInsurer = function(claim, insurerUsername) {
-  for (var i = 0; i < claim.details.settlement.payments.length; i++) {
-    var payment = claim.details.settlement.payments[i]
+var confirmPayout = function(claimId, policyId, payment, insurerUsername) {
+  claimService.confirmPaidOut(claimId, payment.id, insurerUsername, function (result) {
+    if (result.error) {
+      console.error("There was a problem when marking the claim as paid in the blockchain: " + result.error);
+    } else {
+      console.log("confirmPaidOut invoked for claimId: " + claimId);
+      if (payment.recipientType == "claimant") {
+        policyService.getPolicyWithId(policyId, insurerUsername, function(policy){
+          if (policy) {
+            sendEmail(claimId, policyId, policy.relations.owner);
+          } else {
+            console.log("Unable to send email because we failed to retrieve the policy");
+          }
+        });
+      }
+    }
+  });
+};
 
-    if (payment.recipient == insurerUsername && payment.senderType == "insurer" && payment.status == "paid") {
-      return true;
+//Confirms each payment after the last has been confirmed, so that the claim isn't updated by more than one invoke at once
+var confirmPaymentsInTurn = function(claimId, payments, insurerUsername, done) {
+  if (payments.length == 0) {
+    done();
+    return;
+  }
+
+  claimService.confirmPaidOut(claimId, payments[0].id, insurerUsername, function (result) {
+    if (result.error) {
+      console.error("There was a problem when confirming the third party payment in the blockchain: " + result.error);
+      return;
+    }
+
+    console.log("Third party payment confirmed for claimId: " + claimId);
+    confirmPaymentsInTurn(claimId, payments.slice(1), insurerUsername, done);
+  });
+};
+
+//A recovery payment is owed to the insurer by another liable insurer or a third party
+var isUnpaidRecoveryPayment = function(payment, insurerUsername) {
+  return payment.recipient == insurerUsername && (payment.senderType == "insurer" || payment.senderType == "third_party") && payment.status != "paid";
+};
+
+var hasAllRecoveryPaymentsPaid = function(claim, insurerUsername) {
+  for (var i = 0; i < claim.details.settlement.payments.length; i++) {
+    if (isUnpaidRecoveryPayment(claim.details.settlement.payments[i], insurerUsername)) {
+      return false;
     }
   }
 
-  return false
+  return true;
 };
 
 var sendEmail = function(claimId, policyId, policyOwner) {